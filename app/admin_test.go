@@ -0,0 +1,44 @@
+package app_test
+
+import (
+	"testing"
+
+	"snipetty.com/main/app"
+	"snipetty.com/main/config"
+	"snipetty.com/main/repositories"
+)
+
+func TestAdminBootstrap(t *testing.T) {
+	application := newTestApp(t, func(cfg *config.Config) { cfg.AdminUsername = "root" })
+	newClient(t, application).signUp("root")
+	newClient(t, application).signUp("mallory")
+	role := func(username string) repositories.Role {
+		var user repositories.User
+		application.DB.Where("username = ?", username).First(&user)
+		return user.Role
+	}
+	restart := func() {
+		t.Helper()
+		// A new process has its own connection
+		restarted, err := app.New(application.Config, openTestDB(t))
+		if err != nil {
+			t.Fatalf("app.New: %v", err)
+		}
+		restarted.Close()
+	}
+
+	if got := role("root"); got == repositories.RoleAdmin {
+		t.Fatalf("root is admin before a restart")
+	}
+	restart()
+	if got := role("root"); got != repositories.RoleAdmin {
+		t.Fatalf("root has role %q after a restart, want admin", got)
+	}
+
+	// Once there is an admin, ADMIN_USERNAME no longer promotes anyone
+	application.Config.AdminUsername = "mallory"
+	restart()
+	if got := role("mallory"); got == repositories.RoleAdmin {
+		t.Errorf("mallory became admin while root is one")
+	}
+}
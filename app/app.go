@@ -0,0 +1,159 @@
+package app
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+    "snipetty.com/main/collab"
+    "snipetty.com/main/config"
+    "snipetty.com/main/database"
+    "snipetty.com/main/handlers"
+    "snipetty.com/main/metrics"
+    "snipetty.com/main/preview"
+    "snipetty.com/main/repositories"
+    "snipetty.com/main/services"
+    "snipetty.com/main/tracing"
+)
+
+// App is the wired application: configuration, database and HTTP router.
+type App struct {
+    Config *config.Config
+    DB     *gorm.DB
+    Router *gin.Engine
+
+    hub  *collab.Hub        // Live editing sessions
+    stop context.CancelFunc // Stops background jobs
+}
+
+// New builds the repositories, services and router on top of an open,
+// migrated database.
+func New(cfg *config.Config, db *gorm.DB) (*App, error) {
+    m := metrics.New()
+    if err := db.Use(m.GormPlugin()); err != nil {
+        return nil, err
+    }
+    m.RegisterTotals(db)
+    if err := db.Use(tracing.GormPlugin()); err != nil {
+        return nil, err
+    }
+
+    // Create repository
+    snippetRepo := repositories.NewSnippetRepository(db)
+    userRepo := repositories.NewUserRepository(db)
+    auditRepo := repositories.NewAuditRepository(db)
+    collectionRepo := repositories.NewCollectionRepository(db)
+    orgRepo := repositories.NewOrganizationRepository(db)
+    collaboratorRepo := repositories.NewCollaboratorRepository(db)
+
+    // Create service
+    auditService := services.NewAuditService(auditRepo, m)
+    grants := services.Grants{Orgs: orgRepo, Collaborators: collaboratorRepo}
+    var previews services.PreviewStore
+    if cfg.PreviewDir != "" {
+        previews = preview.NewCache(cfg.PreviewDir)
+    }
+    svc := Services{
+        Snippets:      services.NewSnippetService(snippetRepo, userRepo, grants, auditService, previews),
+        Collections:   services.NewCollectionService(collectionRepo, snippetRepo, grants, auditService),
+        Organizations: services.NewOrganizationService(orgRepo, userRepo, snippetRepo, auditService),
+        Users:         services.NewUserService(userRepo, snippetRepo, orgRepo, auditService),
+        Audit:         auditService,
+    }
+    // Backups are only offered for SQLite; other databases have their own tools
+    var backupStore services.BackupStore
+    var backups *database.Backups
+    if db.Dialector.Name() == "sqlite" {
+        backups = database.NewBackups(db, cfg.Backup)
+        backupStore = backups
+    }
+    adminService := services.NewAdminService(userRepo, snippetRepo, auditService, backupStore)
+    svc.Admin = adminService
+    if cfg.AdminUsername != "" {
+        promoted, err := adminService.Bootstrap(context.Background(), cfg.AdminUsername)
+        if err != nil {
+            slog.Warn("failed to grant admin role", "username", cfg.AdminUsername, "error", err)
+        } else if promoted {
+            slog.Info("granted admin role", "username", cfg.AdminUsername)
+        }
+    }
+
+    // Optional OIDC login, enabled when the OIDC_* envs are set
+    if cfg.OIDC.Enabled() {
+        oidcService, err := services.NewOIDCService(context.Background(), cfg.OIDC, userRepo, auditService)
+        if err != nil {
+            slog.Warn("OIDC login disabled", "error", err)
+        } else {
+            svc.OIDC = oidcService
+        }
+    }
+
+    hub := collab.NewHub()
+    router, err := NewRouter(RouterConfig{
+        Secret:           cfg.Secret,
+        MaxBodyBytes:     cfg.Server.MaxBodyBytes,
+        TemplatesGlob:    "templates/*",
+        OIDCProviderName: cfg.OIDC.ProviderName,
+        Metrics:          m,
+        MetricsToken:     cfg.MetricsToken,
+        Logger:           slog.Default(),
+        HealthChecks:     healthChecks(db),
+        EmbedFrameAncestors: cfg.EmbedFrameAncestors,
+        TrustedProxies:   cfg.Server.TrustedProxies,
+        LiveHub:          hub,
+    }, svc)
+    if err != nil {
+        return nil, err
+    }
+
+    ctx, stop := context.WithCancel(context.Background())
+    if backups != nil && cfg.Backup.Interval > 0 {
+        go backups.Schedule(ctx, cfg.Backup.Interval)
+    }
+
+    return &App{Config: cfg, DB: db, Router: router, hub: hub, stop: stop}, nil
+}
+
+// healthChecks decide readiness: the database answers and its schema is up
+// to date.
+func healthChecks(db *gorm.DB) []handlers.HealthCheck {
+    return []handlers.HealthCheck{
+        {Name: "database", Check: func(ctx context.Context) error {
+            sqlDB, err := db.DB()
+            if err != nil {
+                return err
+            }
+            return sqlDB.PingContext(ctx)
+        }},
+        {Name: "migrations", Check: func(ctx context.Context) error {
+            pending, err := database.PendingMigrations(db.WithContext(ctx))
+            if err != nil {
+                return err
+            }
+            if pending > 0 {
+                return fmt.Errorf("%d pending migrations", pending)
+            }
+            return nil
+        }},
+    }
+}
+
+// Shutdown disconnects the live editors and waits, until ctx is done, for
+// their sessions to be saved before closing the application.
+func (a *App) Shutdown(ctx context.Context) error {
+    err := a.hub.Close(ctx)
+    return errors.Join(err, a.Close())
+}
+
+// Close stops background jobs and releases the database connection pool.
+func (a *App) Close() error {
+    a.stop()
+    sqlDB, err := a.DB.DB()
+    if err != nil {
+        return err
+    }
+    return sqlDB.Close()
+}
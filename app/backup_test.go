@@ -0,0 +1,50 @@
+package app_test
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"snipetty.com/main/repositories"
+)
+
+func TestAdminBackups(t *testing.T) {
+	application := newTestApp(t)
+	admin := newClient(t, application)
+	admin.signUp("root")
+	admin.createSnippet("Backed up")
+	application.DB.Model(&repositories.User{}).Where("username = ?", "root").Update("role", repositories.RoleAdmin)
+	user := newClient(t, application)
+	user.signUp("alice")
+
+	if res := user.post("/admin/backups", nil); res.Code != http.StatusForbidden {
+		t.Fatalf("backup as user: status %d, want 403", res.Code)
+	}
+	if res := admin.post("/admin/backups", nil); res.Code != http.StatusSeeOther {
+		t.Fatalf("backup as admin: status %d, want 303", res.Code)
+	}
+
+	page := admin.get("/admin")
+	name := regexp.MustCompile(`snippety-[0-9.-]+\.db`).FindString(page.Body)
+	if name == "" {
+		t.Fatalf("admin page does not list the backup:\n%s", page.Body)
+	}
+	if res := user.get("/admin/backups/" + name); res.Code != http.StatusForbidden {
+		t.Errorf("download as user: status %d, want 403", res.Code)
+	}
+	res := admin.get("/admin/backups/" + name)
+	if res.Code != http.StatusOK || !strings.HasPrefix(res.Body, "SQLite format 3") {
+		t.Errorf("download: status %d, want 200 with a SQLite file", res.Code)
+	}
+	if res := admin.get("/admin/backups/app.db"); res.Code != http.StatusNotFound {
+		t.Errorf("download unknown file: status %d, want 404", res.Code)
+	}
+
+	audit := admin.get("/admin/audit?actor=root")
+	for _, action := range []string{repositories.AuditCreateBackup, repositories.AuditDownloadBackup} {
+		if !strings.Contains(audit.Body, action) {
+			t.Errorf("audit log does not contain %s", action)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package app_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snipetty.com/main/repositories"
+)
+
+func TestCollaborators(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	bob := newClient(t, application)
+	bob.signUp("bob")
+	carol := newClient(t, application)
+	carol.signUp("carol")
+	id := alice.createSnippet("Shared snippet")
+	path := "/snippets/" + id
+
+	if res := bob.post(path+"/edit", snippetForm("Bob's edit")); res.Code != http.StatusForbidden {
+		t.Fatalf("edit before sharing: status %d, want 403", res.Code)
+	}
+	if res := alice.get(path + "/edit"); !strings.Contains(res.Body, "Nobody else has access") || strings.Contains(res.Body, `value="viewer"`) {
+		t.Errorf("edit page has no access section for the author, or offers viewers on a public snippet")
+	}
+	if res := alice.post(path+"/collaborators", url.Values{"username": {"bob"}, "role": {"editor"}}); res.Code != http.StatusSeeOther {
+		t.Fatalf("share: status %d\n%s", res.Code, res.Body)
+	}
+
+	// Editors update, and the page keeps the history of who edited
+	if res := bob.post(path+"/edit", snippetForm("Bob's edit")); res.Code != http.StatusSeeOther {
+		t.Fatalf("editor edits: status %d\n%s", res.Code, res.Body)
+	}
+	if res := alice.post(path+"/edit", snippetForm("Alice's edit")); res.Code != http.StatusSeeOther {
+		t.Fatalf("author edits: status %d", res.Code)
+	}
+	res := carol.get(path)
+	if !strings.Contains(res.Body, "Alice&#39;s edit") || !strings.Contains(res.Body, `Edited by <a href="/users/bob"`) || !strings.Contains(res.Body, `Edited by <a href="/users/alice"`) {
+		t.Errorf("snippet page does not show both edits in its history")
+	}
+	var events []repositories.AuditEvent
+	application.DB.Where("action = ? AND target = ?", repositories.AuditUpdateSnippet, id).Order("id").Find(&events)
+	if len(events) != 2 || events[0].ActorName != "bob" {
+		t.Errorf("updates audited as %+v, want bob's then alice's", events)
+	}
+	if res := bob.get(path + "/edit"); res.Code != http.StatusOK || strings.Contains(res.Body, "/collaborators") {
+		t.Errorf("editor's edit page: status %d, shows access management: %v", res.Code, strings.Contains(res.Body, "/collaborators"))
+	}
+
+	var bobUser repositories.User
+	application.DB.Where("username = ?", "bob").First(&bobUser)
+	remove := fmt.Sprintf("%s/collaborators/%d/delete", path, bobUser.ID)
+	tests := []struct {
+		name   string
+		client *client
+		path   string
+		form   url.Values
+		want   int
+	}{
+		{"editor deletes", bob, path + "/delete", nil, http.StatusForbidden},
+		{"editor shares", bob, path + "/collaborators", url.Values{"username": {"carol"}, "role": {"editor"}}, http.StatusForbidden},
+		{"stranger edits", carol, path + "/edit", snippetForm("Carol's edit"), http.StatusForbidden},
+		{"share with author", alice, path + "/collaborators", url.Values{"username": {"alice"}, "role": {"editor"}}, http.StatusBadRequest},
+		{"share with nobody", alice, path + "/collaborators", url.Values{"username": {"nobody"}, "role": {"editor"}}, http.StatusBadRequest},
+		{"invalid role", alice, path + "/collaborators", url.Values{"username": {"carol"}, "role": {"owner"}}, http.StatusBadRequest},
+		{"viewer on a personal snippet", alice, path + "/collaborators", url.Values{"username": {"bob"}, "role": {"viewer"}}, http.StatusBadRequest},
+		{"remove", alice, remove, nil, http.StatusSeeOther},
+		{"remove twice", alice, remove, nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := tt.client.post(tt.path, tt.form); res.Code != tt.want {
+				t.Errorf("status %d, want %d", res.Code, tt.want)
+			}
+		})
+	}
+
+	// Viewers see members-only organization snippets
+	if res := alice.post("/orgs", url.Values{"name": {"Team"}, "slug": {"team"}}); res.Code != http.StatusSeeOther {
+		t.Fatalf("create org: status %d", res.Code)
+	}
+	var org repositories.Organization
+	application.DB.Where("slug = ?", "team").First(&org)
+	form := snippetForm("Team secret")
+	form.Set("organization_id", fmt.Sprint(org.ID))
+	form.Set("visibility", repositories.VisibilityMembers)
+	secret := alice.post("/snippets/new", form).Location
+	if res := carol.get(secret); res.Code == http.StatusOK {
+		t.Fatalf("stranger sees members-only snippet")
+	}
+	alice.post(secret+"/collaborators", url.Values{"username": {"carol"}, "role": {"viewer"}})
+	if res := carol.get(secret); res.Code != http.StatusOK || strings.Contains(res.Body, secret+"/edit") {
+		t.Errorf("viewer: status %d, offered edit: %v", res.Code, strings.Contains(res.Body, secret+"/edit"))
+	}
+	if res := carol.post(secret+"/edit", snippetForm("Carol's edit")); res.Code != http.StatusForbidden {
+		t.Errorf("viewer edits: status %d, want 403", res.Code)
+	}
+
+	// Editors change the snippet but not who sees it
+	alice.post(secret+"/collaborators", url.Values{"username": {"carol"}, "role": {"editor"}})
+	if res := carol.get(secret + "/edit"); strings.Contains(res.Body, `name="visibility"`) {
+		t.Errorf("editor is offered the visibility setting")
+	}
+	form = snippetForm("Team secret, edited")
+	form.Set("visibility", repositories.VisibilityPublic)
+	if res := carol.post(secret+"/edit", form); res.Code != http.StatusForbidden {
+		t.Errorf("editor makes snippet public: status %d, want 403", res.Code)
+	}
+	form.Set("visibility", repositories.VisibilityMembers)
+	if res := carol.post(secret+"/edit", form); res.Code != http.StatusSeeOther {
+		t.Errorf("editor edits keeping the visibility: status %d, want 303", res.Code)
+	}
+	if res := bob.get(secret); res.Code != http.StatusNotFound {
+		t.Errorf("members-only snippet for stranger after editor's change: status %d, want 404", res.Code)
+	}
+}
@@ -0,0 +1,249 @@
+package app_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"snipetty.com/main/repositories"
+)
+
+// createCollection creates a collection and returns its path.
+func (c *client) createCollection(title, visibility string) string {
+	c.t.Helper()
+	res := c.post("/collections", url.Values{"title": {title}, "description": {"d"}, "visibility": {visibility}})
+	if res.Code != http.StatusSeeOther || !strings.HasPrefix(res.Location, "/collections/") {
+		c.t.Fatalf("create collection: status %d, location %q\n%s", res.Code, res.Location, res.Body)
+	}
+	return res.Location
+}
+
+func TestCollections(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	first := alice.createSnippet("First snippet")
+	second := alice.createSnippet("Second snippet")
+	bob := newClient(t, application)
+	bob.signUp("bob")
+	bobs := bob.createSnippet("Bob's snippet")
+	guest := newClient(t, application)
+
+	deploy := alice.createCollection("Deploy scripts", "public")
+	drafts := alice.createCollection("Drafts", "private")
+	id := strings.TrimPrefix(deploy, "/collections/")
+
+	// Added from the snippet pages, including someone else's snippet
+	for _, snippet := range []string{first, second, bobs} {
+		res := alice.post("/snippets/"+snippet+"/collections", url.Values{"collection_id": {id}})
+		if res.Code != http.StatusSeeOther || res.Location != "/snippets/"+snippet {
+			t.Fatalf("add %s: status %d, location %q", snippet, res.Code, res.Location)
+		}
+	}
+	if res := alice.post("/snippets/"+first+"/collections", url.Values{"collection_id": {id}}); res.Code != http.StatusBadRequest {
+		t.Errorf("add twice: status %d, want 400", res.Code)
+	}
+	if res := alice.get("/snippets/" + first); !strings.Contains(res.Body, "/collections/"+id+"/snippets/"+first+"/delete") {
+		t.Errorf("snippet page does not offer removal from the collection")
+	}
+
+	assertOrder := func(c *client, want ...string) {
+		t.Helper()
+		res := c.get(deploy)
+		if res.Code != http.StatusOK {
+			t.Fatalf("view collection: status %d", res.Code)
+		}
+		last := -1
+		for _, title := range want {
+			i := strings.Index(res.Body, title)
+			if i <= last {
+				t.Fatalf("collection does not list %v in order", want)
+			}
+			last = i
+		}
+	}
+	assertOrder(guest, "First snippet", "Second snippet", "Bob&#39;s snippet")
+
+	if res := alice.post(deploy+"/snippets/"+bobs+"/move", url.Values{"direction": {"up"}}); res.Code != http.StatusSeeOther {
+		t.Fatalf("move: status %d", res.Code)
+	}
+	assertOrder(alice, "First snippet", "Bob&#39;s snippet", "Second snippet")
+
+	tests := []struct {
+		name   string
+		client *client
+		method string
+		path   string
+		form   url.Values
+		want   int
+	}{
+		{"guest views private", guest, http.MethodGet, drafts, nil, http.StatusNotFound},
+		{"other user views private", bob, http.MethodGet, drafts, nil, http.StatusNotFound},
+		{"owner views private", alice, http.MethodGet, drafts, nil, http.StatusOK},
+		{"guest lists collections", guest, http.MethodGet, "/collections", nil, http.StatusSeeOther},
+		{"other user adds", bob, http.MethodPost, "/snippets/" + bobs + "/collections", url.Values{"collection_id": {id}}, http.StatusForbidden},
+		{"other user removes", bob, http.MethodPost, deploy + "/snippets/" + first + "/delete", nil, http.StatusForbidden},
+		{"other user edits", bob, http.MethodPost, deploy + "/edit", url.Values{"title": {"x"}, "visibility": {"public"}}, http.StatusForbidden},
+		{"other user deletes", bob, http.MethodPost, deploy + "/delete", nil, http.StatusForbidden},
+		{"invalid visibility", alice, http.MethodPost, deploy + "/edit", url.Values{"title": {"x"}, "visibility": {"secret"}}, http.StatusBadRequest},
+		{"owner removes", alice, http.MethodPost, deploy + "/snippets/" + second + "/delete", nil, http.StatusSeeOther},
+		{"owner makes it private", alice, http.MethodPost, deploy + "/edit", url.Values{"title": {"Deploy"}, "visibility": {"private"}}, http.StatusSeeOther},
+		{"guest views now private", guest, http.MethodGet, deploy, nil, http.StatusNotFound},
+		{"owner deletes", alice, http.MethodPost, deploy + "/delete", nil, http.StatusSeeOther},
+		{"deleted", alice, http.MethodGet, deploy, nil, http.StatusNotFound},
+		{"unknown id", guest, http.MethodGet, "/collections/abc", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if res := tt.client.do(tt.method, tt.path, tt.form); res.Code != tt.want {
+			t.Errorf("%s: %s %s = %d, want %d", tt.name, tt.method, tt.path, res.Code, tt.want)
+		}
+	}
+
+	// The snippets outlive the collection
+	if res := alice.get("/snippets/" + second); res.Code != http.StatusOK {
+		t.Errorf("snippet after collection delete: status %d", res.Code)
+	}
+}
+
+type apiCollection struct {
+	ID           uint   `json:"id"`
+	Owner        string `json:"owner"`
+	Title        string `json:"title"`
+	SnippetCount int    `json:"snippet_count"`
+	Snippets     []struct {
+		ID     string `json:"id"`
+		Author string `json:"author"`
+	} `json:"snippets"`
+}
+
+func decode[T any](t *testing.T, res response) T {
+	t.Helper()
+	var out T
+	if err := json.Unmarshal([]byte(res.Body), &out); err != nil {
+		t.Fatalf("decode %q: %v", res.Body, err)
+	}
+	return out
+}
+
+func TestCollectionAPI(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	first := alice.createSnippet("First")
+	second := alice.createSnippet("Second")
+	bob := newClient(t, application)
+	bob.signUp("bob")
+	bobs := bob.createSnippet("Bob's")
+	guest := newClient(t, application)
+
+	// Scripts sign in for a bearer token
+	script := newClient(t, application)
+	if res := script.send(http.MethodGet, "/api/collections", nil, nil); res.Code != http.StatusUnauthorized || !strings.Contains(res.Body, `"error"`) {
+		t.Errorf("unauthenticated list: status %d, body %s", res.Code, res.Body)
+	}
+	if res := script.sendJSON(http.MethodPost, "/api/tokens", map[string]string{"username": "alice", "password": "wrong"}, nil); res.Code != http.StatusUnauthorized {
+		t.Errorf("token for a wrong password: status %d, want 401", res.Code)
+	}
+	res := script.sendJSON(http.MethodPost, "/api/tokens", map[string]string{"username": "alice", "password": "password123"}, nil)
+	token := decode[struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}](t, res)
+	if res.Code != http.StatusCreated || token.Token == "" || token.ExpiresAt.Before(time.Now()) || script.cookies["Authorization"] != nil {
+		t.Fatalf("token: status %d\n%s", res.Code, res.Body)
+	}
+	bearer := http.Header{"Authorization": {"Bearer " + token.Token}}
+	res = script.sendJSON(http.MethodPost, "/api/collections", map[string]string{"title": "Deploy scripts", "visibility": "public"}, bearer)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("create: status %d\n%s", res.Code, res.Body)
+	}
+	created := decode[apiCollection](t, res)
+	if created.Owner != "alice" || created.Title != "Deploy scripts" || strings.Contains(res.Body, "password") {
+		t.Errorf("created %s", res.Body)
+	}
+	if res := script.sendJSON(http.MethodPost, "/api/collections", map[string]string{"title": "Bad", "visibility": "secret"}, bearer); res.Code != http.StatusBadRequest {
+		t.Errorf("invalid visibility: status %d, want 400", res.Code)
+	}
+	path := fmt.Sprintf("/api/collections/%d", created.ID)
+	for _, snippet := range []string{first, second, bobs} {
+		alice.post("/snippets/"+snippet+"/collections", url.Values{"collection_id": {fmt.Sprint(created.ID)}})
+	}
+
+	got := decode[apiCollection](t, guest.get(path))
+	if len(got.Snippets) != 3 || got.Snippets[0].ID != first || got.Snippets[2].Author != "bob" {
+		t.Errorf("collection %+v, want first, second and bob's", got)
+	}
+	list := decode[[]apiCollection](t, alice.get("/api/collections"))
+	if len(list) != 1 || list[0].SnippetCount != 3 || list[0].Snippets != nil {
+		t.Errorf("list %+v, want one collection of 3 snippets", list)
+	}
+
+	// Snippets left out of the new order follow the listed ones
+	res = alice.sendJSON(http.MethodPut, path+"/order", map[string][]string{"snippet_ids": {bobs, second}}, nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("reorder: status %d\n%s", res.Code, res.Body)
+	}
+	got = decode[apiCollection](t, res)
+	if len(got.Snippets) != 3 || got.Snippets[0].ID != bobs || got.Snippets[1].ID != second || got.Snippets[2].ID != first {
+		t.Errorf("reordered %+v, want bob's, second, first", got.Snippets)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		client *client
+		ids    []string
+		want   int
+	}{
+		{"duplicate", alice, []string{first, first}, http.StatusBadRequest},
+		{"not in collection", alice, []string{"alice-404"}, http.StatusBadRequest},
+		{"not the owner", bob, []string{first}, http.StatusForbidden},
+		{"guest", guest, []string{first}, http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := tt.client.sendJSON(http.MethodPut, path+"/order", map[string][]string{"snippet_ids": tt.ids}, nil); res.Code != tt.want {
+				t.Errorf("status %d, want %d", res.Code, tt.want)
+			}
+		})
+	}
+
+	drafts := strings.TrimPrefix(alice.createCollection("Drafts", "private"), "/collections/")
+	if res := bob.get("/api/collections/" + drafts); res.Code != http.StatusNotFound {
+		t.Errorf("someone else's private collection: status %d, want 404", res.Code)
+	}
+	if res := guest.get("/api/collections/nope"); res.Code != http.StatusNotFound {
+		t.Errorf("invalid ID: status %d, want 404", res.Code)
+	}
+
+	// Snippets the owner can no longer see are not counted
+	bob.post("/orgs", url.Values{"name": {"Team"}, "slug": {"team"}})
+	bob.post("/orgs/team/members", url.Values{"username": {"alice"}, "role": {"member"}})
+	var org repositories.Organization
+	application.DB.Where("slug = ?", "team").First(&org)
+	form := snippetForm("Team secret")
+	form.Set("organization_id", fmt.Sprint(org.ID))
+	form.Set("visibility", repositories.VisibilityMembers)
+	secret := bob.post("/snippets/new", form).Location
+	alice.post(secret+"/collections", url.Values{"collection_id": {fmt.Sprint(created.ID)}})
+	count := func() int {
+		t.Helper()
+		list := decode[[]apiCollection](t, script.send(http.MethodGet, "/api/collections", nil, bearer))
+		for _, collection := range list {
+			if collection.ID == created.ID {
+				return collection.SnippetCount
+			}
+		}
+		t.Fatalf("collection missing from %+v", list)
+		return 0
+	}
+	if got := count(); got != 4 {
+		t.Errorf("count with the team's snippet: %d, want 4", got)
+	}
+	bob.post(memberPath(t, application, "team", "alice")+"/delete", nil)
+	if got := count(); got != 3 {
+		t.Errorf("count after leaving the team: %d, want 3", got)
+	}
+}
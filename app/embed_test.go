@@ -0,0 +1,87 @@
+package app_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snipetty.com/main/config"
+	"snipetty.com/main/repositories"
+)
+
+func TestEmbed(t *testing.T) {
+	application := newTestApp(t, func(cfg *config.Config) {
+		cfg.EmbedFrameAncestors = []string{"https://wiki.example.com", "https://blog.example.com"}
+	})
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	guest := newClient(t, application)
+	id := alice.createSnippet("Embedded <snippet>")
+
+	page := httptest.NewRecorder()
+	application.Router.ServeHTTP(page, httptest.NewRequest(http.MethodGet, "/snippets/"+id+"/embed?theme=dark", nil))
+	if page.Code != http.StatusOK {
+		t.Fatalf("embed page: status %d", page.Code)
+	}
+	if got := page.Header().Get("X-Frame-Options"); got != "" {
+		t.Errorf("embed page X-Frame-Options = %q, want none", got)
+	}
+	if got, want := page.Header().Get("Content-Security-Policy"), "frame-ancestors https://wiki.example.com https://blog.example.com"; got != want {
+		t.Errorf("embed page CSP = %q, want %q", got, want)
+	}
+	body := page.Body.String()
+	for _, want := range []string{"Embedded &lt;snippet&gt;", "language-go", "github-dark", `href="/snippets/` + id + `"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("embed page does not contain %q", want)
+		}
+	}
+	if strings.Contains(body, "authenticated-links") {
+		t.Errorf("embed page includes the site navigation")
+	}
+
+	script := httptest.NewRecorder()
+	application.Router.ServeHTTP(script, httptest.NewRequest(http.MethodGet, "/snippets/"+id+"/embed.js", nil))
+	if script.Code != http.StatusOK || !strings.HasPrefix(script.Header().Get("Content-Type"), "text/javascript") {
+		t.Fatalf("embed.js: status %d, type %q", script.Code, script.Header().Get("Content-Type"))
+	}
+	if got := script.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("embed.js Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if !strings.Contains(script.Body.String(), fmt.Sprintf("var id = %q;", id)) {
+		t.Errorf("embed.js does not embed snippet %s:\n%s", id, script.Body.String())
+	}
+
+	// Other pages may not be framed, and offer the embed code
+	res := httptest.NewRecorder()
+	application.Router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/snippets/"+id, nil))
+	if got := res.Header().Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("snippet page X-Frame-Options = %q, want DENY", got)
+	}
+	if !strings.Contains(res.Body.String(), "http://example.com/snippets/"+id+"/embed.js") {
+		t.Errorf("snippet page does not show the embed code")
+	}
+
+	// Members-only snippets are not embeddable, even by members
+	if res := alice.post("/orgs", url.Values{"name": {"Team"}, "slug": {"team"}}); res.Code != http.StatusSeeOther {
+		t.Fatalf("create org: status %d", res.Code)
+	}
+	var org repositories.Organization
+	application.DB.Where("slug = ?", "team").First(&org)
+	form := snippetForm("Team secret")
+	form.Set("organization_id", fmt.Sprint(org.ID))
+	form.Set("visibility", repositories.VisibilityMembers)
+	secret := alice.post("/snippets/new", form).Location
+	for _, c := range []*client{alice, guest} {
+		for _, path := range []string{secret + "/embed", secret + "/embed.js", "/snippets/missing/embed"} {
+			if res := c.get(path); res.Code != http.StatusNotFound || strings.Contains(res.Body, "Team secret") {
+				t.Errorf("%s: status %d, want 404", path, res.Code)
+			}
+		}
+	}
+	if res := alice.get(secret); strings.Contains(res.Body, "embed.js") {
+		t.Errorf("members-only snippet offers embed code")
+	}
+}
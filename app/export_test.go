@@ -0,0 +1,256 @@
+package app_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"snipetty.com/main/repositories"
+	"snipetty.com/main/services"
+)
+
+func TestExportSnippets(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	first := alice.createSnippet("Hello, World!")
+	second := alice.createSnippet("Binary search")
+	bob := newClient(t, application)
+	bob.signUp("bob")
+	bob.createSnippet("Not alice's")
+
+	if res := newClient(t, application).get("/snippets/my/export"); res.Code != http.StatusSeeOther {
+		t.Fatalf("anonymous export: status %d, want 303", res.Code)
+	}
+
+	res := alice.get("/snippets/my/export")
+	if res.Code != http.StatusOK {
+		t.Fatalf("export: status %d, want 200", res.Code)
+	}
+	archive, err := zip.NewReader(strings.NewReader(res.Body), int64(len(res.Body)))
+	if err != nil {
+		t.Fatalf("export is not a zip: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		content, _ := io.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(content)
+	}
+
+	wantFiles := map[string]string{
+		"snippets/" + first + "-hello-world.go":    "package main",
+		"snippets/" + second + "-binary-search.go": "package main",
+	}
+	for name, content := range wantFiles {
+		if files[name] != content {
+			t.Errorf("%s = %q, want %q", name, files[name], content)
+		}
+	}
+	if len(files) != len(wantFiles)+1 {
+		t.Errorf("archive has %d files, want %d snippets and the manifest", len(files), len(wantFiles))
+	}
+
+	var manifest services.ExportManifest
+	if err := json.Unmarshal([]byte(files[services.ExportManifestName]), &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if manifest.Username != "alice" || len(manifest.Snippets) != 2 {
+		t.Fatalf("manifest = %+v, want alice's 2 snippets", manifest)
+	}
+	for _, s := range manifest.Snippets {
+		if _, ok := wantFiles[s.File]; !ok || s.Language != "Go" || s.Description != "a test snippet" {
+			t.Errorf("manifest entry %+v does not match the archive", s)
+		}
+	}
+}
+
+func TestExportSnippetsAPI(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	alice.createSnippet("Hello, World!")
+
+	// A real server, whose write timeout would cut off any other response
+	server := httptest.NewUnstartedServer(application.Router)
+	server.Config.WriteTimeout = time.Nanosecond
+	server.Start()
+	t.Cleanup(server.Close)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/snippets/export", nil)
+	req.Header.Set("Authorization", "Bearer "+alice.cookies["Authorization"].Value)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("read export: %v", err)
+	}
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: status %d, type %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil || len(archive.File) != 2 {
+		t.Errorf("export is not a zip of one snippet and the manifest: %v", err)
+	}
+
+	if res := newClient(t, application).get("/api/snippets/export"); res.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous export: status %d, want 401", res.Code)
+	}
+}
+
+func TestImportSnippets(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	alice.createSnippet("Hello")
+	alice.createSnippet("World")
+	export := alice.get("/snippets/my/export")
+
+	bob := newClient(t, application)
+	bob.signUp("bob")
+	if res := bob.get("/snippets/import"); res.Code != http.StatusOK {
+		t.Fatalf("import form: status %d", res.Code)
+	}
+	res := bob.upload("/snippets/import", "archive", "alice-snippets.zip", []byte(export.Body))
+	if res.Code != http.StatusOK || !strings.Contains(res.Body, "2 imported") {
+		t.Fatalf("import: status %d, want 200 with 2 imported\n%s", res.Code, res.Body)
+	}
+	mine := bob.get("/snippets/my")
+	for _, title := range []string{"Hello", "World"} {
+		if !strings.Contains(mine.Body, title) {
+			t.Errorf("bob's snippets do not include %s after import", title)
+		}
+	}
+
+	res = bob.upload("/snippets/import", "archive", "notes.txt", []byte("just text"))
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body, "Unsupported file") {
+		t.Errorf("unsupported upload: status %d, want 400", res.Code)
+	}
+
+	// Gist JSON is held to the same file limit as archives
+	files := map[string]map[string]string{}
+	for i := range 1001 {
+		files[fmt.Sprintf("f%d.go", i)] = map[string]string{"content": "package main"}
+	}
+	gist, _ := json.Marshal(map[string]any{"files": files})
+	res = bob.upload("/snippets/import", "archive", "gist.json", gist)
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body, "at most 1000") {
+		t.Errorf("gist of 1001 files: status %d, want 400", res.Code)
+	}
+
+	// Files under 1 MiB each still may not unpack to more than 16 MiB
+	var bomb bytes.Buffer
+	zw := zip.NewWriter(&bomb)
+	for i := range 17 {
+		f, _ := zw.Create(fmt.Sprintf("f%d.go", i))
+		f.Write(bytes.Repeat([]byte("a"), 1<<20))
+	}
+	zw.Close()
+	res = bob.upload("/snippets/import", "archive", "bomb.zip", bomb.Bytes())
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body, "more than 16 MiB") {
+		t.Errorf("zip unpacking to 17 MiB: status %d, want 400", res.Code)
+	}
+}
+
+func TestExportFileNamesKeepCharactersWhole(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	id := alice.createSnippet(strings.Repeat("é", 60))
+
+	res := alice.get("/snippets/my/export")
+	archive, err := zip.NewReader(strings.NewReader(res.Body), int64(len(res.Body)))
+	if err != nil {
+		t.Fatalf("export is not a zip: %v", err)
+	}
+	want := "snippets/" + id + "-" + strings.Repeat("é", 50) + ".go"
+	for _, f := range archive.File {
+		if f.Name != services.ExportManifestName && f.Name != want {
+			t.Errorf("file %q, want %q", f.Name, want)
+		}
+	}
+}
+
+func TestExportKeepsOrganizationSnippetsInTheOrganization(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	bob := newClient(t, application)
+	bob.signUp("bob")
+	alice.post("/orgs", url.Values{"name": {"Platform Team"}, "slug": {"platform"}})
+	alice.post("/orgs/platform/members", url.Values{"username": {"bob"}, "role": {"member"}})
+	var org repositories.Organization
+	application.DB.Where("slug = ?", "platform").First(&org)
+	form := snippetForm("Deploy runbook")
+	form.Set("organization_id", fmt.Sprint(org.ID))
+	form.Set("visibility", repositories.VisibilityMembers)
+	if res := bob.post("/snippets/new", form); res.Code != http.StatusSeeOther {
+		t.Fatalf("create org snippet: status %d", res.Code)
+	}
+	bob.createSnippet("Dotfiles")
+
+	// Members export the organization's snippets, which are not made public
+	// by importing them
+	export := bob.get("/snippets/my/export")
+	manifest := exportManifest(t, export.Body)
+	if len(manifest.Snippets) != 2 {
+		t.Fatalf("member's export has %d snippets, want 2", len(manifest.Snippets))
+	}
+	for _, s := range manifest.Snippets {
+		if s.Title == "Deploy runbook" && (s.Visibility != repositories.VisibilityMembers || s.Organization != "platform") {
+			t.Errorf("runbook exported as %+v", s)
+		}
+	}
+	carol := newClient(t, application)
+	carol.signUp("carol")
+	res := carol.upload("/snippets/import", "archive", "bob.zip", []byte(export.Body))
+	if res.Code != http.StatusOK || !strings.Contains(res.Body, "1 imported") || !strings.Contains(res.Body, "members-only snippets of platform") {
+		t.Errorf("import of a members-only snippet: status %d\n%s", res.Code, res.Body)
+	}
+	if res := newClient(t, application).get("/snippets"); strings.Contains(res.Body, "Deploy runbook") {
+		t.Errorf("members-only snippet is public after an import")
+	}
+
+	// Once bob has left, the runbook is no longer his to export
+	if res := bob.post(memberPath(t, application, "platform", "bob")+"/delete", nil); res.Code != http.StatusSeeOther {
+		t.Fatalf("leave: status %d", res.Code)
+	}
+	manifest = exportManifest(t, bob.get("/snippets/my/export").Body)
+	if len(manifest.Snippets) != 1 || manifest.Snippets[0].Title != "Dotfiles" {
+		t.Errorf("export after leaving the organization: %+v, want only Dotfiles", manifest.Snippets)
+	}
+}
+
+// exportManifest reads the manifest of an export archive.
+func exportManifest(t *testing.T, body string) services.ExportManifest {
+	t.Helper()
+	archive, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("export is not a zip: %v", err)
+	}
+	f, err := archive.Open(services.ExportManifestName)
+	if err != nil {
+		t.Fatalf("open manifest: %v", err)
+	}
+	defer f.Close()
+	var manifest services.ExportManifest
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	return manifest
+}
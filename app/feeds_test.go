@@ -0,0 +1,103 @@
+package app_test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"snipetty.com/main/repositories"
+)
+
+type testFeed struct {
+	Title   string `xml:"title"`
+	Entries []struct {
+		ID      string `xml:"id"`
+		Title   string `xml:"title"`
+		Author  string `xml:"author>name"`
+		Content string `xml:"content"`
+	} `xml:"entry"`
+}
+
+func TestFeeds(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	bob := newClient(t, application)
+	bob.signUp("bob")
+
+	older := alice.createSnippet("Older <one>")
+	application.DB.Model(&repositories.Snippet{}).Where("id = ?", older).Update("created_at", time.Now().Add(-time.Hour))
+	newer := alice.createSnippet("Newer")
+	form := snippetForm("Bob's Python")
+	form.Set("language", "Python")
+	if res := bob.post("/snippets/new", form); res.Code != http.StatusSeeOther {
+		t.Fatalf("create: status %d", res.Code)
+	}
+
+	// Members-only snippets stay out of every feed
+	if res := alice.post("/orgs", url.Values{"name": {"Team"}, "slug": {"team"}}); res.Code != http.StatusSeeOther {
+		t.Fatalf("create org: status %d", res.Code)
+	}
+	var org repositories.Organization
+	application.DB.Where("slug = ?", "team").First(&org)
+	form = snippetForm("Team secret")
+	form.Set("organization_id", fmt.Sprint(org.ID))
+	form.Set("visibility", repositories.VisibilityMembers)
+	alice.post("/snippets/new", form)
+
+	feed := func(path string) testFeed {
+		t.Helper()
+		w := httptest.NewRecorder()
+		application.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/atom+xml") {
+			t.Fatalf("%s: status %d, type %q", path, w.Code, w.Header().Get("Content-Type"))
+		}
+		var f testFeed
+		if err := xml.Unmarshal(w.Body.Bytes(), &f); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return f
+	}
+	titles := func(f testFeed) string {
+		var titles []string
+		for _, entry := range f.Entries {
+			titles = append(titles, entry.Title)
+		}
+		return strings.Join(titles, ", ")
+	}
+
+	for path, want := range map[string]string{
+		"/snippets/feed":                 "Bob's Python, Newer, Older <one>",
+		"/snippets/user/alice/feed":      "Newer, Older <one>",
+		"/snippets/language/python/feed": "Bob's Python",
+		"/snippets/language/Go/feed":     "Newer, Older <one>",
+	} {
+		if got := titles(feed(path)); got != want {
+			t.Errorf("%s lists %q, want %q", path, got, want)
+		}
+	}
+
+	entry := feed("/snippets/user/alice/feed").Entries[0]
+	if entry.ID != "http://example.com/snippets/"+newer || entry.Author != "alice" || !strings.Contains(entry.Content, "<pre><code>package main") {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	for _, path := range []string{"/snippets/user/nobody/feed", "/snippets/language/cobol/feed"} {
+		if res := alice.get(path); res.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, res.Code)
+		}
+	}
+
+	// Listing pages advertise their feeds
+	if res := alice.get("/snippets"); !strings.Contains(res.Body, `type="application/atom+xml" title="Snippety" href="/snippets/feed"`) {
+		t.Errorf("snippet list does not link its feed")
+	}
+	if res := bob.get("/users/alice"); !strings.Contains(res.Body, `href="/snippets/user/alice/feed"`) {
+		t.Errorf("profile does not link the user's feed")
+	}
+}
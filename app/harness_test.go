@@ -0,0 +1,221 @@
+package app_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"snipetty.com/main/app"
+	"snipetty.com/main/config"
+	"snipetty.com/main/database"
+	"snipetty.com/main/logging"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(logging.New(io.Discard, config.Log{}))
+	// app.New loads templates relative to the working directory
+	if err := os.Chdir(".."); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestApp wires the full application against a fresh in-memory SQLite
+// database that is dropped when the test ends. configure may adjust the
+// configuration first.
+func newTestApp(t *testing.T, configure ...func(*config.Config)) *app.App {
+	t.Helper()
+
+	db := openTestDB(t)
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	cfg := &config.Config{
+		Secret:     "test-secret-that-is-long-enough-0123456789",
+		Server:     config.Server{MaxBodyBytes: 1 << 20},
+		Backup:     config.Backup{Dir: t.TempDir(), Keep: 2},
+		PreviewDir: t.TempDir(),
+	}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	application, err := app.New(cfg, db)
+	if err != nil {
+		t.Fatalf("app.New: %v", err)
+	}
+	t.Cleanup(func() { application.Close() })
+	return application
+}
+
+// openTestDB opens the test's in-memory SQLite database. Every connection of
+// the test sees the same database while one of them is open.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := database.Open("sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared", name))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	// Connections to a shared in-memory database fail with "table is locked"
+	// instead of waiting for each other, so the tests use one
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return db
+}
+
+// client sends requests to the router and keeps the session cookie, like a
+// browser that does not follow redirects.
+type client struct {
+	t       *testing.T
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func newClient(t *testing.T, application *app.App) *client {
+	return &client{t: t, handler: application.Router, cookies: map[string]*http.Cookie{}}
+}
+
+type response struct {
+	Code      int
+	Location  string
+	Body      string
+	RequestID string
+	TraceID   string
+}
+
+func (c *client) get(path string) response {
+	return c.do(http.MethodGet, path, nil)
+}
+
+func (c *client) post(path string, form url.Values) response {
+	return c.do(http.MethodPost, path, form)
+}
+
+func (c *client) do(method, path string, form url.Values) response {
+	c.t.Helper()
+	return c.send(method, path, form, nil)
+}
+
+// send is do with extra request headers.
+func (c *client) send(method, path string, form url.Values, header http.Header) response {
+	c.t.Helper()
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req := httptest.NewRequest(method, path, body)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return c.serve(req)
+}
+
+// sendJSON sends body encoded as JSON, with extra request headers.
+func (c *client) sendJSON(method, path string, body any, header http.Header) response {
+	c.t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		c.t.Fatalf("encode %s body: %v", path, err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return c.serve(req)
+}
+
+// upload posts a multipart form with a single file.
+func (c *client) upload(path, field, filename string, content []byte) response {
+	c.t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile(field, filename)
+	if err != nil {
+		c.t.Fatalf("multipart: %v", err)
+	}
+	part.Write(content)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return c.serve(req)
+}
+
+// serve sends req with the session cookies and keeps the cookies it sets.
+func (c *client) serve(req *http.Request) response {
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, req)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(c.cookies, cookie.Name)
+		} else {
+			c.cookies[cookie.Name] = cookie
+		}
+	}
+	return response{
+		Code:      w.Code,
+		Location:  w.Header().Get("Location"),
+		Body:      w.Body.String(),
+		RequestID: w.Header().Get("X-Request-ID"),
+		TraceID:   w.Header().Get("X-Trace-ID"),
+	}
+}
+
+// signUp registers and logs in a user.
+func (c *client) signUp(username string) {
+	c.t.Helper()
+	form := url.Values{"username": {username}, "password": {"password123"}}
+	if res := c.post("/register", form); res.Code != http.StatusOK || strings.Contains(res.Body, "already") {
+		c.t.Fatalf("register %s: %d %s", username, res.Code, res.Body)
+	}
+	if res := c.post("/login", form); res.Code != http.StatusSeeOther {
+		c.t.Fatalf("login %s: status %d", username, res.Code)
+	}
+}
+
+// createSnippet creates a snippet and returns its ID.
+func (c *client) createSnippet(title string) string {
+	c.t.Helper()
+	res := c.post("/snippets/new", snippetForm(title))
+	if res.Code != http.StatusSeeOther || !strings.HasPrefix(res.Location, "/snippets/") {
+		c.t.Fatalf("create snippet: status %d, location %q", res.Code, res.Location)
+	}
+	return strings.TrimPrefix(res.Location, "/snippets/")
+}
+
+func snippetForm(title string) url.Values {
+	return url.Values{
+		"title":       {title},
+		"content":     {"package main"},
+		"description": {"a test snippet"},
+		"language":    {"Go"},
+	}
+}
@@ -0,0 +1,80 @@
+package app_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"snipetty.com/main/app"
+	"snipetty.com/main/database"
+)
+
+type healthBody struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	Checks  map[string]struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"checks"`
+}
+
+func getHealth(t *testing.T, c *client, path string) (int, healthBody) {
+	t.Helper()
+	res := c.get(path)
+	var body healthBody
+	if err := json.Unmarshal([]byte(res.Body), &body); err != nil {
+		t.Fatalf("%s: invalid JSON %q: %v", path, res.Body, err)
+	}
+	return res.Code, body
+}
+
+func TestHealthz(t *testing.T) {
+	code, body := getHealth(t, newClient(t, newTestApp(t)), "/healthz")
+	if code != http.StatusOK || body.Status != "ok" || body.Version == "" {
+		t.Errorf("healthz = %d %+v, want 200 ok with a version", code, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		breakApp   func(t *testing.T, application *app.App)
+		wantCode   int
+		wantFailed map[string]bool
+	}{
+		{"ready", func(*testing.T, *app.App) {}, http.StatusOK, nil},
+		{"pending migrations", func(t *testing.T, application *app.App) {
+			if _, err := database.MigrateDown(application.DB); err != nil {
+				t.Fatalf("MigrateDown: %v", err)
+			}
+		}, http.StatusServiceUnavailable, map[string]bool{"migrations": true}},
+		{"database down", func(t *testing.T, application *app.App) {
+			if err := application.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+		}, http.StatusServiceUnavailable, map[string]bool{"database": true, "migrations": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			application := newTestApp(t)
+			tt.breakApp(t, application)
+
+			code, body := getHealth(t, newClient(t, application), "/readyz")
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%+v)", code, tt.wantCode, body)
+			}
+			if len(body.Checks) != 2 {
+				t.Errorf("got checks %v, want database and migrations", body.Checks)
+			}
+			for name, check := range body.Checks {
+				wantStatus := "ok"
+				if tt.wantFailed[name] {
+					wantStatus = "error"
+				}
+				if check.Status != wantStatus {
+					t.Errorf("check %s = %+v, want %s", name, check, wantStatus)
+				}
+			}
+		})
+	}
+}
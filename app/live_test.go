@@ -0,0 +1,353 @@
+package app_test
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"snipetty.com/main/collab"
+	"snipetty.com/main/repositories"
+)
+
+// editor is a WebSocket client that edits like the browser one: it sends one
+// operation at a time and transforms those still waiting against the ones
+// it receives.
+type editor struct {
+	t        *testing.T
+	conn     *websocket.Conn
+	messages chan collab.Message
+	rev      int
+	doc      []rune
+	pending  []collab.Operation
+	users    []string
+	saved    bool
+}
+
+// dial opens the live editor of the snippet with c's session cookies.
+func dial(server *httptest.Server, c *client, id, origin string) (*websocket.Conn, error) {
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(server.URL, "http")+"/snippets/"+id+"/live/ws", origin)
+	if err != nil {
+		return nil, err
+	}
+	for _, cookie := range c.cookies {
+		config.Header.Add("Cookie", (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).String())
+	}
+	return websocket.DialConfig(config)
+}
+
+func newEditor(t *testing.T, server *httptest.Server, c *client, id string) *editor {
+	t.Helper()
+	conn, err := dial(server, c, id, server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	e := &editor{t: t, conn: conn, messages: make(chan collab.Message, 256)}
+	go func() {
+		defer close(e.messages)
+		for {
+			var m collab.Message
+			if err := websocket.JSON.Receive(conn, &m); err != nil {
+				return
+			}
+			e.messages <- m
+		}
+	}()
+	t.Cleanup(func() { conn.Close() })
+	if m := e.next(); m.Type != collab.TypeInit || m.Doc == nil {
+		t.Fatalf("first message %+v, want init", m)
+	} else {
+		e.rev, e.doc = m.Rev, []rune(*m.Doc)
+	}
+	return e
+}
+
+// next waits for a message and handles it.
+func (e *editor) next() collab.Message {
+	select {
+	case m, ok := <-e.messages:
+		if !ok {
+			e.t.Errorf("connection closed")
+			return collab.Message{}
+		}
+		e.handle(m)
+		return m
+	case <-time.After(5 * time.Second):
+		e.t.Errorf("no message from the server")
+		return collab.Message{}
+	}
+}
+
+func (e *editor) handle(m collab.Message) {
+	switch m.Type {
+	case collab.TypeAck:
+		e.rev = m.Rev
+		e.pending = e.pending[1:]
+		if len(e.pending) > 0 {
+			e.send(collab.Message{Type: collab.TypeOp, Rev: e.rev, Op: e.pending[0]})
+		}
+	case collab.TypeOp:
+		e.rev = m.Rev
+		op := m.Op
+		for i := range e.pending {
+			var err error
+			if e.pending[i], op, err = collab.Transform(e.pending[i], op); err != nil {
+				e.t.Errorf("transform: %v", err)
+				return
+			}
+		}
+		e.apply(op)
+	case collab.TypePresence:
+		e.users = m.Users
+	case collab.TypeSaved:
+		e.saved = true
+	case collab.TypeError:
+		e.t.Errorf("server rejected a message: %s", m.Error)
+	}
+}
+
+func (e *editor) send(m collab.Message) {
+	if err := websocket.JSON.Send(e.conn, m); err != nil {
+		e.t.Errorf("send: %v", err)
+	}
+}
+
+func (e *editor) apply(op collab.Operation) {
+	doc, err := op.Apply(e.doc)
+	if err != nil {
+		e.t.Errorf("apply: %v", err)
+		return
+	}
+	e.doc = doc
+}
+
+// insert types text at pos.
+func (e *editor) insert(pos int, text string) {
+	op := collab.Operation{}.Retain(pos).Insert(text).Retain(len(e.doc) - pos)
+	e.apply(op)
+	e.pending = append(e.pending, op)
+	if len(e.pending) == 1 {
+		e.send(collab.Message{Type: collab.TypeOp, Rev: e.rev, Op: op})
+	}
+}
+
+// until handles messages until done reports true.
+func (e *editor) until(done func() bool) {
+	for !done() && !e.t.Failed() {
+		e.next()
+	}
+}
+
+func TestLiveEditing(t *testing.T) {
+	application := newTestApp(t)
+	server := httptest.NewServer(application.Router)
+	defer server.Close()
+
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	bob := newClient(t, application)
+	bob.signUp("bob")
+	carol := newClient(t, application)
+	carol.signUp("carol")
+	id := alice.createSnippet("Pairing")
+	alice.post("/snippets/"+id+"/collaborators", url.Values{"username": {"bob"}, "role": {"editor"}})
+
+	if res := alice.get("/snippets/" + id + "/live"); res.Code != http.StatusOK || !strings.Contains(res.Body, "/live/ws") {
+		t.Fatalf("live page: status %d", res.Code)
+	}
+	if res := carol.get("/snippets/" + id + "/live"); res.Code != http.StatusForbidden {
+		t.Errorf("live page for a stranger: status %d, want 403", res.Code)
+	}
+	if _, err := dial(server, carol, id, server.URL); err == nil {
+		t.Errorf("stranger joined the live session")
+	}
+	if _, err := dial(server, alice, id, "http://evil.example"); err == nil {
+		t.Errorf("cross-origin WebSocket accepted")
+	}
+
+	a := newEditor(t, server, alice, id)
+	b := newEditor(t, server, bob, id)
+	a.until(func() bool { return len(a.users) == 2 })
+	b.until(func() bool { return len(b.users) == 2 })
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(a.users, want) {
+		t.Errorf("presence %v, want %v", a.users, want)
+	}
+
+	// Both type at once, each without waiting for the other's edits
+	const edits = 20
+	var wg sync.WaitGroup
+	for i, e := range []*editor{a, b} {
+		wg.Add(1)
+		go func(e *editor, letter string, seed int64) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(seed))
+			for n := 0; n < edits; n++ {
+				e.insert(random.Intn(len(e.doc)+1), letter)
+				for len(e.messages) > 0 {
+					e.next()
+				}
+			}
+			e.until(func() bool { return len(e.pending) == 0 && e.rev == 2*edits })
+		}(e, string(rune('a'+i)), int64(i))
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+	if string(a.doc) != string(b.doc) {
+		t.Fatalf("editors diverged:\n%q\n%q", string(a.doc), string(b.doc))
+	}
+	if len(a.doc) != len("package main")+2*edits {
+		t.Errorf("document %q lost edits", string(a.doc))
+	}
+
+	// Saving goes through the snippet service, as bob
+	b.send(collab.Message{Type: collab.TypeSave})
+	a.until(func() bool { return a.saved })
+	var snippet repositories.Snippet
+	application.DB.First(&snippet, "id = ?", id)
+	var revision repositories.SnippetRevision
+	application.DB.Preload("Editor").Where("snippet_id = ?", id).Order("id DESC").First(&revision)
+	if snippet.Content != string(a.doc) || revision.Editor == nil || revision.Editor.Username != "bob" {
+		t.Errorf("saved %q by %v, want %q by bob", snippet.Content, revision.Editor, string(a.doc))
+	}
+
+	// Presence follows editors leaving, and the last one saves on the way out
+	b.conn.Close()
+	a.until(func() bool { return len(a.users) == 1 })
+	a.insert(0, "// ")
+	a.until(func() bool { return len(a.pending) == 0 })
+	want := string(a.doc)
+	a.conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for snippet.Content != want && time.Now().Before(deadline) {
+			application.DB.First(&snippet, "id = ?", id)
+	}
+	if snippet.Content != want {
+		t.Errorf("content after the last editor left: %q, want %q", snippet.Content, want)
+	}
+	if res := carol.get("/snippets/" + id); !strings.Contains(res.Body, "// ") {
+		t.Errorf("snippet page does not show the live edit")
+	}
+}
+
+func TestLiveEditingKeepsFormSaves(t *testing.T) {
+	application := newTestApp(t)
+	server := httptest.NewServer(application.Router)
+	defer server.Close()
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	id := alice.createSnippet("Pairing")
+	content := func() string {
+		var snippet repositories.Snippet
+		application.DB.First(&snippet, "id = ?", id)
+		return snippet.Content
+	}
+
+	// A form save of other fields is merged into the live save
+	a := newEditor(t, server, alice, id)
+	a.insert(0, "// ")
+	a.until(func() bool { return len(a.pending) == 0 })
+	if res := alice.post("/snippets/"+id+"/edit", snippetForm("Renamed")); res.Code != http.StatusSeeOther {
+		t.Fatalf("form save: status %d", res.Code)
+	}
+	a.send(collab.Message{Type: collab.TypeSave})
+	a.until(func() bool { return a.saved })
+	if res := alice.get("/snippets/" + id); !strings.Contains(res.Body, "Renamed") || content() != "// package main" {
+		t.Errorf("live save after renaming: content %q, title kept: %v", content(), strings.Contains(res.Body, "Renamed"))
+	}
+
+	// A form save of the code is not overwritten, by saving or by leaving
+	a.insert(0, "live ")
+	a.until(func() bool { return len(a.pending) == 0 })
+	form := snippetForm("Renamed")
+	form.Set("content", "from the form")
+	if res := alice.post("/snippets/"+id+"/edit", form); res.Code != http.StatusSeeOther {
+		t.Fatalf("form save: status %d", res.Code)
+	}
+	a.send(collab.Message{Type: collab.TypeSave})
+	select {
+	case m := <-a.messages:
+		if m.Type != collab.TypeError || !strings.Contains(m.Error, "changed outside the live editor") {
+			t.Errorf("save over a form save: got %+v, want an error", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no answer to the save")
+	}
+	a.conn.Close()
+	// Give the refused save on leaving time to happen
+	time.Sleep(100 * time.Millisecond)
+	if got := content(); got != "from the form" {
+		t.Errorf("content after the last editor left: %q, want the form's", got)
+	}
+}
+
+func TestLiveEditingDropsInvalidOperations(t *testing.T) {
+	application := newTestApp(t)
+	server := httptest.NewServer(application.Router)
+	defer server.Close()
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	id := alice.createSnippet("Pairing")
+
+	a := newEditor(t, server, alice, id)
+	a.until(func() bool { return len(a.users) == 1 })
+	op := `{"type":"op","rev":0,"op":[9223372036854775807,"x",9223372036854775807,"y",5]}`
+	if _, err := a.conn.Write([]byte(op)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	// The server hangs up on an operation it cannot read
+	deadline := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-a.messages:
+		case <-deadline:
+			t.Fatalf("the connection is still open")
+		}
+	}
+
+	// The editor left the session, so the next one is alone in a new one
+	b := newEditor(t, server, alice, id)
+	b.until(func() bool { return len(b.users) > 0 })
+	if len(b.users) != 1 || string(b.doc) != "package main" {
+		t.Errorf("after the invalid operation: editing %v, document %q", b.users, string(b.doc))
+	}
+}
+
+func TestLiveEditingSavedOnShutdown(t *testing.T) {
+	application := newTestApp(t)
+	server := httptest.NewServer(application.Router)
+	defer server.Close()
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	id := alice.createSnippet("Pairing")
+
+	a := newEditor(t, server, alice, id)
+	a.insert(0, "// ")
+	a.until(func() bool { return len(a.pending) == 0 })
+
+	// Keeps the in-memory database after the application closes its own
+	db := openTestDB(t)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := application.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	var snippet repositories.Snippet
+	db.First(&snippet, "id = ?", id)
+	if snippet.Content != "// package main" {
+		t.Errorf("content after shutdown: %q, want the live edit", snippet.Content)
+	}
+}
@@ -0,0 +1,50 @@
+package app_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"snipetty.com/main/config"
+)
+
+func TestMetrics(t *testing.T) {
+	application := newTestApp(t, func(cfg *config.Config) { cfg.MetricsToken = "scrape-token" })
+	application.Router.GET("/panic", func(*gin.Context) { panic("boom") })
+	c := newClient(t, application)
+	c.signUp("alice")
+	c.createSnippet("Hello")
+	c.post("/login", url.Values{"username": {"alice"}, "password": {"wrong"}})
+	if res := c.get("/panic"); res.Code != http.StatusInternalServerError {
+		t.Fatalf("panic: status %d, want 500", res.Code)
+	}
+
+	if res := c.get("/metrics"); res.Code != http.StatusUnauthorized {
+		t.Fatalf("without token: status %d, want 401", res.Code)
+	}
+	wrong := http.Header{"Authorization": {"Bearer nope"}}
+	if res := c.send(http.MethodGet, "/metrics", nil, wrong); res.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: status %d, want 401", res.Code)
+	}
+
+	res := c.send(http.MethodGet, "/metrics", nil, http.Header{"Authorization": {"Bearer scrape-token"}})
+	if res.Code != http.StatusOK {
+		t.Fatalf("with token: status %d, want 200", res.Code)
+	}
+	for _, want := range []string{
+		`snippety_http_requests_total{method="POST",route="/snippets/new",status="303"} 1`,
+		`snippety_http_request_duration_seconds_count{method="POST",route="/login",status="303"} 1`,
+		`snippety_db_query_duration_seconds_count{operation="create",table="snippets"} 1`,
+		`snippety_http_requests_total{method="GET",route="/panic",status="500"} 1`,
+		`snippety_logins_total{result="success"} 1`,
+		`snippety_logins_total{result="failure"} 1`,
+		"snippety_users 1",
+		"snippety_snippets 1",
+	} {
+		if !strings.Contains(res.Body, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package app_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"snipetty.com/main/config"
+	"snipetty.com/main/repositories"
+)
+
+// mockIssuer is a minimal OpenID Connect provider: discovery, keys and a
+// token endpoint that redeems the codes handed out by authorize.
+type mockIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	codes  map[string]jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{t: t, key: key, codes: map[string]jwt.MapClaims{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.server.URL,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		claims, ok := m.codes[r.FormValue("code")]
+		delete(m.codes, r.FormValue("code"))
+		m.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize plays the user signing in at the issuer after being sent to
+// location. It returns the code and state the issuer redirects back with;
+// the ID token carries the nonce of location unless claims sets one.
+func (m *mockIssuer) authorize(location string, claims jwt.MapClaims) (code, state string) {
+	m.t.Helper()
+	u, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(location, m.server.URL+"/authorize") {
+		m.t.Fatalf("login redirected to %q", location)
+	}
+	query := u.Query()
+	token := jwt.MapClaims{
+		"iss":   m.server.URL,
+		"aud":   query.Get("client_id"),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		token[name] = value
+	}
+	code = base64.RawURLEncoding.EncodeToString(big.NewInt(time.Now().UnixNano()).Bytes())
+	m.mu.Lock()
+	m.codes[code] = token
+	m.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newMockIssuer(t)
+	application := newTestApp(t, func(cfg *config.Config) {
+		cfg.OIDC = config.OIDC{
+			IssuerURL:    issuer.server.URL,
+			ClientID:     "snippety",
+			ClientSecret: "secret",
+			RedirectURL:  "http://example.com/auth/oidc/callback",
+			ProviderName: "Test SSO",
+		}
+	})
+	sso := func(c *client, claims jwt.MapClaims) response {
+		t.Helper()
+		code, state := issuer.authorize(c.get("/auth/oidc/login").Location, claims)
+		return c.get("/auth/oidc/callback?" + url.Values{"code": {code}, "state": {state}}.Encode())
+	}
+	user := func(subject string) repositories.User {
+		var u repositories.User
+		application.DB.Where("oidc_subject = ?", subject).First(&u)
+		return u
+	}
+
+	t.Run("provisions a new user", func(t *testing.T) {
+		carol := newClient(t, application)
+		res := sso(carol, jwt.MapClaims{"sub": "sub-carol", "email": "carol@corp.example", "email_verified": true, "preferred_username": "Carol"})
+		if res.Code != http.StatusSeeOther || carol.cookies["Authorization"] == nil {
+			t.Fatalf("callback: status %d, want a session", res.Code)
+		}
+		if u := user("sub-carol"); u.Username != "carol" || u.Email != "carol@corp.example" || u.Password != "" {
+			t.Errorf("provisioned %+v", u)
+		}
+		if id := carol.createSnippet("From SSO"); !strings.HasPrefix(id, "carol-") {
+			t.Errorf("snippet %s not created as carol", id)
+		}
+
+		// Names registration would refuse are replaced
+		erin := newClient(t, application)
+		sso(erin, jwt.MapClaims{"sub": "sub-erin", "email": "erin@corp.example", "email_verified": true, "preferred_username": "../Erin Smith"})
+		if u := user("sub-erin"); u.Username != "user" {
+			t.Errorf("provisioned %q from an unsafe preferred_username, want user", u.Username)
+		}
+		long := strings.Repeat("x", 32)
+		for _, subject := range []string{"sub-long", "sub-long2"} {
+			sso(newClient(t, application), jwt.MapClaims{"sub": subject, "email": subject + "@corp.example", "email_verified": true, "preferred_username": long})
+		}
+		if first, second := user("sub-long").Username, user("sub-long2").Username; first != long || second != long[:28]+"2" {
+			t.Errorf("provisioned %q and %q for a 32-character name", first, second)
+		}
+
+		// Unverified emails are not trusted to provision an account
+		dave := newClient(t, application)
+		res = sso(dave, jwt.MapClaims{"sub": "sub-dave", "email": "dave@corp.example", "email_verified": false})
+		if !strings.Contains(res.Body, "no verified email") || dave.cookies["Authorization"] != nil {
+			t.Errorf("unverified email: status %d, signed in %v", res.Code, dave.cookies["Authorization"] != nil)
+		}
+	})
+
+	t.Run("links an existing user", func(t *testing.T) {
+		alice := newClient(t, application)
+		alice.signUp("alice")
+		if res := alice.get("/profile"); !strings.Contains(res.Body, "Link Test SSO account") {
+			t.Errorf("profile does not offer linking")
+		}
+		if res := sso(alice, jwt.MapClaims{"sub": "sub-alice"}); res.Code != http.StatusSeeOther || res.Location != "/users/alice" {
+			t.Fatalf("link: status %d, location %q", res.Code, res.Location)
+		}
+
+		laptop := newClient(t, application)
+		if res := sso(laptop, jwt.MapClaims{"sub": "sub-alice"}); res.Code != http.StatusSeeOther {
+			t.Fatalf("login: status %d", res.Code)
+		}
+		if id := laptop.createSnippet("From laptop"); !strings.HasPrefix(id, "alice-") {
+			t.Errorf("SSO login did not reach alice: created %s", id)
+		}
+		var count int64
+		application.DB.Model(&repositories.User{}).Where("username LIKE ?", "alice%").Count(&count)
+		if count != 1 {
+			t.Errorf("%d alice accounts, want 1", count)
+		}
+
+		// A provider account links to one user only
+		bob := newClient(t, application)
+		bob.signUp("bob")
+		if res := sso(bob, jwt.MapClaims{"sub": "sub-alice"}); res.Code != http.StatusConflict {
+			t.Errorf("linking a taken account: status %d, want 409", res.Code)
+		}
+		if u := user("sub-alice"); u.Username != "alice" {
+			t.Errorf("sub-alice moved to %s", u.Username)
+		}
+	})
+
+	t.Run("rejects a bad state or nonce", func(t *testing.T) {
+		mallory := newClient(t, application)
+		code, _ := issuer.authorize(mallory.get("/auth/oidc/login").Location, jwt.MapClaims{"sub": "sub-mallory", "email": "m@corp.example", "email_verified": true})
+		res := mallory.get("/auth/oidc/callback?" + url.Values{"code": {code}, "state": {"forged"}}.Encode())
+		if !strings.Contains(res.Body, "Invalid login state") || mallory.cookies["Authorization"] != nil {
+			t.Errorf("forged state: status %d, signed in %v", res.Code, mallory.cookies["Authorization"] != nil)
+		}
+
+		res = sso(mallory, jwt.MapClaims{"sub": "sub-mallory", "email": "m@corp.example", "email_verified": true, "nonce": "replayed"})
+		if !strings.Contains(res.Body, "Login with Test SSO failed") || mallory.cookies["Authorization"] != nil {
+			t.Errorf("wrong nonce: status %d, signed in %v", res.Code, mallory.cookies["Authorization"] != nil)
+		}
+		if u := user("sub-mallory"); u.ID != 0 {
+			t.Errorf("user provisioned despite the rejected login: %+v", u)
+		}
+	})
+}
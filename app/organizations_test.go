@@ -0,0 +1,196 @@
+package app_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snipetty.com/main/app"
+	"snipetty.com/main/repositories"
+)
+
+// memberPath returns the path of username's membership of the organization.
+func memberPath(t *testing.T, application *app.App, org, username string) string {
+	t.Helper()
+	var user repositories.User
+	if err := application.DB.Where("username = ?", username).First(&user).Error; err != nil {
+		t.Fatalf("find %s: %v", username, err)
+	}
+	return fmt.Sprintf("/orgs/%s/members/%d", org, user.ID)
+}
+
+func TestOrganizations(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	bob := newClient(t, application)
+	bob.signUp("bob")
+	carol := newClient(t, application)
+	carol.signUp("carol")
+	guest := newClient(t, application)
+
+	res := alice.post("/orgs", url.Values{"name": {"Platform Team"}, "slug": {"platform"}})
+	if res.Code != http.StatusSeeOther || res.Location != "/orgs/platform" {
+		t.Fatalf("create org: status %d, location %q\n%s", res.Code, res.Location, res.Body)
+	}
+	if res := bob.post("/orgs", url.Values{"name": {"Taken"}, "slug": {"platform"}}); res.Code != http.StatusBadRequest {
+		t.Errorf("duplicate slug: status %d, want 400", res.Code)
+	}
+	if res := alice.post("/orgs/platform/members", url.Values{"username": {"bob"}, "role": {"member"}}); res.Code != http.StatusSeeOther {
+		t.Fatalf("add bob: status %d\n%s", res.Code, res.Body)
+	}
+
+	// Bob writes a members-only snippet for the organization
+	orgs := bob.get("/snippets/new")
+	if !strings.Contains(orgs.Body, "Platform Team") {
+		t.Fatalf("snippet form does not offer the organization")
+	}
+	var org repositories.Organization
+	if err := application.DB.Where("slug = ?", "platform").First(&org).Error; err != nil {
+		t.Fatalf("find org: %v", err)
+	}
+	form := snippetForm("Deploy runbook")
+	form.Set("organization_id", fmt.Sprint(org.ID))
+	form.Set("visibility", repositories.VisibilityMembers)
+	res = bob.post("/snippets/new", form)
+	if res.Code != http.StatusSeeOther {
+		t.Fatalf("create org snippet: status %d\n%s", res.Code, res.Body)
+	}
+	snippet := res.Location
+	if res := carol.post("/snippets/new", form); res.Code != http.StatusForbidden {
+		t.Errorf("non-member creates org snippet: status %d, want 403", res.Code)
+	}
+	personal := snippetForm("Personal")
+	personal.Set("visibility", repositories.VisibilityMembers)
+	if res := carol.post("/snippets/new", personal); res.Code != http.StatusBadRequest {
+		t.Errorf("members-only personal snippet: status %d, want 400", res.Code)
+	}
+
+	// Only members see it, on its page, the org page and nowhere public
+	for _, c := range []*client{carol, guest} {
+		if res := c.get(snippet); res.Code != http.StatusNotFound || strings.Contains(res.Body, "Deploy runbook") {
+			t.Errorf("members-only snippet for non-member: status %d, want 404", res.Code)
+		}
+		if res := c.get("/orgs/platform"); res.Code != http.StatusOK || strings.Contains(res.Body, "Deploy runbook") {
+			t.Errorf("org page for non-member: status %d, lists members-only snippet: %v", res.Code, strings.Contains(res.Body, "Deploy runbook"))
+		}
+	}
+	if res := guest.get("/snippets"); strings.Contains(res.Body, "Deploy runbook") {
+		t.Errorf("members-only snippet listed publicly")
+	}
+	if res := alice.get("/orgs/platform"); !strings.Contains(res.Body, "Deploy runbook") {
+		t.Errorf("org page does not list the snippet for a member")
+	}
+	if res := alice.get(snippet); res.Code != http.StatusOK || !strings.Contains(res.Body, snippet+"/edit") || !strings.Contains(res.Body, snippet+"/delete") {
+		t.Errorf("org owner cannot edit and delete: status %d", res.Code)
+	}
+
+	bobPath := memberPath(t, application, "platform", "bob")
+	alicePath := memberPath(t, application, "platform", "alice")
+	tests := []struct {
+		name   string
+		client *client
+		method string
+		path   string
+		form   url.Values
+		want   int
+	}{
+		{"non-member edits", carol, http.MethodPost, snippet + "/edit", snippetForm("Hijacked"), http.StatusNotFound},
+		{"non-member deletes", carol, http.MethodPost, snippet + "/delete", nil, http.StatusNotFound},
+		{"non-member shares", carol, http.MethodPost, snippet + "/collaborators", url.Values{"username": {"carol"}, "role": {"editor"}}, http.StatusNotFound},
+		{"owner edits", alice, http.MethodPost, snippet + "/edit", snippetForm("Deploy runbook v2"), http.StatusSeeOther},
+		{"member adds member", bob, http.MethodPost, "/orgs/platform/members", url.Values{"username": {"carol"}, "role": {"member"}}, http.StatusForbidden},
+		{"unknown user", alice, http.MethodPost, "/orgs/platform/members", url.Values{"username": {"nobody"}, "role": {"member"}}, http.StatusBadRequest},
+		{"member promotes self", bob, http.MethodPost, bobPath + "/role", url.Values{"role": {"admin"}}, http.StatusForbidden},
+		{"last owner leaves", alice, http.MethodPost, alicePath + "/delete", nil, http.StatusBadRequest},
+		{"invalid role", alice, http.MethodPost, bobPath + "/role", url.Values{"role": {"boss"}}, http.StatusBadRequest},
+		{"unknown org", guest, http.MethodGet, "/orgs/nope", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res response
+			if tt.method == http.MethodGet {
+				res = tt.client.get(tt.path)
+			} else {
+				res = tt.client.post(tt.path, tt.form)
+			}
+			if res.Code != tt.want {
+				t.Errorf("status %d, want %d", res.Code, tt.want)
+			}
+		})
+	}
+
+	// Plain members edit but do not delete; org admins do both
+	if res := bob.get(snippet); !strings.Contains(res.Body, "Deploy runbook v2") {
+		t.Errorf("owner's edit not shown")
+	}
+	res = alice.post("/orgs/platform/members", url.Values{"username": {"carol"}, "role": {"member"}})
+	if res.Code != http.StatusSeeOther {
+		t.Fatalf("add carol: status %d", res.Code)
+	}
+	if res := carol.post(snippet+"/edit", snippetForm("Carol's edit")); res.Code != http.StatusSeeOther {
+		t.Errorf("member edits: status %d, want 303", res.Code)
+	}
+	if res := carol.post(snippet+"/delete", nil); res.Code != http.StatusForbidden {
+		t.Errorf("member deletes: status %d, want 403", res.Code)
+	}
+	carolPath := memberPath(t, application, "platform", "carol")
+	if res := alice.post(carolPath+"/role", url.Values{"role": {"admin"}}); res.Code != http.StatusSeeOther {
+		t.Fatalf("promote carol: status %d", res.Code)
+	}
+	if res := carol.post(snippet+"/delete", nil); res.Code != http.StatusSeeOther {
+		t.Errorf("org admin deletes: status %d, want 303", res.Code)
+	}
+
+	// Members may leave on their own
+	if res := bob.post(bobPath+"/delete", nil); res.Code != http.StatusSeeOther || res.Location != "/orgs" {
+		t.Errorf("leave: status %d, location %q", res.Code, res.Location)
+	}
+	if res := guest.get("/orgs"); !strings.Contains(res.Body, "Platform Team") || !strings.Contains(res.Body, "2 members") {
+		t.Errorf("org listing does not show the organization with 2 members")
+	}
+}
+
+func TestOrganizationsOutliveAccounts(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	bob := newClient(t, application)
+	bob.signUp("bob")
+	if res := alice.post("/orgs", url.Values{"name": {"Platform Team"}, "slug": {"platform"}}); res.Code != http.StatusSeeOther {
+		t.Fatalf("create org: status %d", res.Code)
+	}
+	if res := alice.post("/orgs/platform/members", url.Values{"username": {"bob"}, "role": {"member"}}); res.Code != http.StatusSeeOther {
+		t.Fatalf("add bob: status %d", res.Code)
+	}
+	var org repositories.Organization
+	application.DB.Where("slug = ?", "platform").First(&org)
+	form := snippetForm("Deploy runbook")
+	form.Set("organization_id", fmt.Sprint(org.ID))
+	form.Set("visibility", repositories.VisibilityMembers)
+	teamSnippet := bob.post("/snippets/new", form).Location
+	personal := bob.createSnippet("Dotfiles")
+
+	deleteAccount := url.Values{"password": {"password123"}, "mode": {"delete"}}
+	if res := alice.post("/profile/delete", deleteAccount); res.Code != http.StatusBadRequest || !strings.Contains(res.Body, "only owner of Platform Team") {
+		t.Errorf("last owner deletes account: status %d, want 400", res.Code)
+	}
+
+	// Bob's personal snippets go with him; the team keeps the runbook
+	if res := bob.post("/profile/delete", deleteAccount); res.Code != http.StatusSeeOther {
+		t.Fatalf("delete bob: status %d", res.Code)
+	}
+	if res := alice.get("/snippets/" + personal); res.Code != http.StatusNotFound {
+		t.Errorf("personal snippet: status %d, want 404", res.Code)
+	}
+	var snippet repositories.Snippet
+	application.DB.Preload("User").Where("id = ?", strings.TrimPrefix(teamSnippet, "/snippets/")).First(&snippet)
+	if snippet.User.Username != "alice" || snippet.OrganizationID == nil || *snippet.OrganizationID != org.ID {
+		t.Errorf("team snippet after deleting its author: %+v", snippet)
+	}
+	if res := alice.get(teamSnippet); res.Code != http.StatusOK || !strings.Contains(res.Body, "Deploy runbook") {
+		t.Errorf("team snippet: status %d, want 200", res.Code)
+	}
+}
@@ -0,0 +1,103 @@
+package app_test
+
+import (
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"snipetty.com/main/config"
+	"snipetty.com/main/repositories"
+)
+
+func TestLinkPreviews(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	guest := newClient(t, application)
+	id := alice.createSnippet("Retry loop")
+	dir := application.Config.PreviewDir
+
+	page := guest.get("/snippets/" + id)
+	for _, want := range []string{
+		`<meta property="og:title" content="Retry loop" />`,
+		`<meta property="og:url" content="http://example.com/snippets/` + id + `" />`,
+		`<meta name="twitter:card" content="summary_large_image" />`,
+		`content="http://example.com/snippets/` + id + `/preview.png?v=`,
+	} {
+		if !strings.Contains(page.Body, want) {
+			t.Errorf("snippet page does not contain %s", want)
+		}
+	}
+
+	image := func() *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		application.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/snippets/"+id+"/preview.png", nil))
+		return w
+	}
+	w := image()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("preview: status %d, type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if img, err := png.Decode(w.Body); err != nil || img.Bounds().Dx() != 1200 || img.Bounds().Dy() != 630 {
+		t.Fatalf("preview is not a 1200x630 PNG: %v", err)
+	}
+	cached := func() int {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(files)
+	}
+	if n := cached(); n != 1 {
+		t.Fatalf("%d cached previews, want 1", n)
+	}
+
+	// Updating the snippet drops the cached image
+	if res := alice.post("/snippets/"+id+"/edit", snippetForm("Retry loop v2")); res.Code != http.StatusSeeOther {
+		t.Fatalf("edit: status %d", res.Code)
+	}
+	if n := cached(); n != 0 {
+		t.Errorf("%d cached previews after an update, want 0", n)
+	}
+	if w := image(); w.Code != http.StatusOK || cached() != 1 {
+		t.Errorf("preview after update: status %d, %d cached", w.Code, cached())
+	}
+
+	// Members-only snippets have no preview
+	if res := alice.post("/orgs", url.Values{"name": {"Team"}, "slug": {"team"}}); res.Code != http.StatusSeeOther {
+		t.Fatalf("create org: status %d", res.Code)
+	}
+	var org repositories.Organization
+	application.DB.Where("slug = ?", "team").First(&org)
+	form := snippetForm("Team secret")
+	form.Set("organization_id", fmt.Sprint(org.ID))
+	form.Set("visibility", repositories.VisibilityMembers)
+	secret := alice.post("/snippets/new", form).Location
+	if res := alice.get(secret); strings.Contains(res.Body, "og:title") {
+		t.Errorf("members-only snippet has link preview tags")
+	}
+	if res := alice.get(secret + "/preview.png"); res.Code != http.StatusNotFound {
+		t.Errorf("members-only preview: status %d, want 404", res.Code)
+	}
+}
+
+func TestLinkPreviewsDisabled(t *testing.T) {
+	application := newTestApp(t, func(cfg *config.Config) { cfg.PreviewDir = "" })
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	id := alice.createSnippet("No image")
+
+	page := alice.get("/snippets/" + id)
+	if !strings.Contains(page.Body, "og:title") || strings.Contains(page.Body, "og:image") {
+		t.Errorf("snippet page should have Open Graph tags without an image")
+	}
+	if res := alice.get("/snippets/" + id + "/preview.png"); res.Code != http.StatusNotFound {
+		t.Errorf("preview: status %d, want 404", res.Code)
+	}
+}
@@ -0,0 +1,217 @@
+package app
+
+import (
+    "log/slog"
+
+    "github.com/gin-gonic/gin"
+    "snipetty.com/main/collab"
+    "snipetty.com/main/handlers"
+    "snipetty.com/main/metrics"
+    "snipetty.com/main/middleware"
+    "snipetty.com/main/repositories"
+    "snipetty.com/main/tracing"
+)
+
+// RouterConfig holds the settings NewRouter needs from the configuration.
+type RouterConfig struct {
+    Secret        string
+    MaxBodyBytes  int64
+    TemplatesGlob string
+    // OIDCProviderName labels the SSO button; only used when Services.OIDC is set
+    OIDCProviderName string
+    // Metrics, when set, instruments every request and serves /metrics,
+    // guarded by MetricsToken if that is not empty
+    Metrics      *metrics.Metrics
+    MetricsToken string
+    // Logger receives one record per request; nil uses slog.Default()
+    Logger *slog.Logger
+    // HealthChecks must all pass for /readyz to report ready
+    HealthChecks []handlers.HealthCheck
+    // EmbedFrameAncestors may frame snippet embeds; empty allows any site
+    EmbedFrameAncestors []string
+    // TrustedProxies may set X-Forwarded-For and X-Forwarded-Proto; empty
+    // trusts none, so clients cannot pick the IP recorded in the audit log
+    TrustedProxies []string
+    // LiveHub holds the live editing sessions; nil starts an empty one
+    LiveHub *collab.Hub
+}
+
+// Services are the dependencies of the HTTP handlers. OIDC may be nil to
+// disable single sign-on.
+type Services struct {
+    Snippets      handlers.SnippetService
+    Collections   handlers.CollectionService
+    Organizations handlers.OrganizationService
+    Users         handlers.UserService
+    Admin         handlers.AdminService
+    Audit         handlers.AuditService
+    OIDC          handlers.OIDCService
+}
+
+// NewRouter builds the gin engine with every route of the application.
+func NewRouter(cfg RouterConfig, svc Services) (*gin.Engine, error) {
+    auth := middleware.NewAuth(cfg.Secret)
+
+    oidcProvider := ""
+    if svc.OIDC != nil {
+        oidcProvider = cfg.OIDCProviderName
+    }
+
+    // Create handler
+    snippetHandler := handlers.NewSnippetHandler(svc.Snippets, svc.Collections, svc.Organizations)
+    collectionHandler := handlers.NewCollectionHandler(svc.Collections)
+    orgHandler := handlers.NewOrganizationHandler(svc.Organizations)
+    hub := cfg.LiveHub
+    if hub == nil {
+        hub = collab.NewHub()
+    }
+    liveHandler := handlers.NewLiveHandler(svc.Snippets, hub)
+    embedHandler := handlers.NewEmbedHandler(svc.Snippets, cfg.EmbedFrameAncestors)
+    feedHandler := handlers.NewFeedHandler(svc.Snippets)
+    userHandler := handlers.NewUserHandler(svc.Users, auth, oidcProvider)
+    adminHandler := handlers.NewAdminHandler(svc.Admin, svc.Audit)
+    healthHandler := handlers.NewHealthHandler(cfg.HealthChecks...)
+
+    // setup gin router
+    router := gin.New()
+    if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+        return nil, err
+    }
+    logger := cfg.Logger
+    if logger == nil {
+        logger = slog.Default()
+    }
+    router.Use(middleware.RequestID(), tracing.Middleware(), middleware.RequestLogger(logger))
+    // Outside Recovery, so a panic is counted with the 500 it turns into
+    if cfg.Metrics != nil {
+        router.Use(cfg.Metrics.Middleware())
+    }
+    router.Use(gin.Recovery())
+    if cfg.Metrics != nil {
+        router.GET("/metrics", cfg.Metrics.Handler(cfg.MetricsToken))
+    }
+    if cfg.MaxBodyBytes > 0 {
+        router.Use(middleware.MaxBodySize(cfg.MaxBodyBytes))
+    }
+    router.Use(middleware.DenyFraming(), auth.Identify)
+
+    // Load HTML templates
+    router.LoadHTMLGlob(cfg.TemplatesGlob)
+
+    // Probes for the orchestrator
+    router.GET("/healthz", healthHandler.Live)
+    router.GET("/readyz", healthHandler.Ready)
+
+    // Auth routes
+    authRoutes := router.Group("/")
+    {
+        authRoutes.GET("", handlers.Home)
+        authRoutes.GET("/login", userHandler.Login)
+        authRoutes.GET("/logout", userHandler.Logout)
+        authRoutes.GET("/register", userHandler.CreateUser)
+        authRoutes.POST("/login", userHandler.Login)
+        authRoutes.POST("/register", userHandler.CreateUser)
+        if svc.OIDC != nil {
+            oidcHandler := handlers.NewOIDCHandler(svc.OIDC, auth, oidcProvider)
+            authRoutes.GET("/auth/oidc/login", oidcHandler.Login)
+            authRoutes.GET("/auth/oidc/callback", oidcHandler.Callback)
+        }
+    }
+
+    // Role checks, run after CheckAuth
+    activeUser := middleware.RequireRole(svc.Users, repositories.RoleUser)
+    moderator := middleware.RequireRole(svc.Users, repositories.RoleModerator)
+    admin := middleware.RequireRole(svc.Users, repositories.RoleAdmin)
+
+    // User routes
+    router.GET("/users/:username", userHandler.Profile)
+    router.GET("/profile", middleware.CheckAuth, activeUser, userHandler.EditProfile)
+    router.POST("/profile", middleware.CheckAuth, activeUser, userHandler.EditProfile)
+    router.GET("/profile/delete", middleware.CheckAuth, activeUser, userHandler.DeleteAccount)
+    router.POST("/profile/delete", middleware.CheckAuth, activeUser, userHandler.DeleteAccount)
+
+    // Admin routes
+    adm := router.Group("/admin", middleware.CheckAuth, moderator)
+    {
+        adm.GET("", adminHandler.Dashboard)
+        adm.POST("/snippets/:id/delete", adminHandler.RemoveSnippet)
+        adm.POST("/users/:id/role", admin, adminHandler.SetRole)
+        adm.POST("/users/:id/disable", admin, adminHandler.SetDisabled)
+        adm.GET("/audit", admin, adminHandler.AuditLog)
+        adm.GET("/audit/export", admin, adminHandler.ExportAudit)
+        adm.POST("/backups", admin, adminHandler.CreateBackup)
+        adm.GET("/backups/:name", admin, adminHandler.DownloadBackup)
+    }
+
+    // Snippet routes
+    snip := router.Group("/snippets")
+    {
+        // Guest routes
+        snip.GET("", snippetHandler.GetSnippetsByLanguage)
+        snip.GET("/feed", feedHandler.Recent)
+        snip.GET("/language/:language/feed", feedHandler.Language)
+        snip.GET("/user/:username", snippetHandler.GetSnippetsByUsername)
+        snip.GET("/user/:username/feed", feedHandler.User)
+        snip.GET("/:id", snippetHandler.GetSnippetByID)
+        snip.GET("/:id/embed", embedHandler.Page)
+        snip.GET("/:id/embed.js", embedHandler.Script)
+        snip.GET("/:id/preview.png", snippetHandler.PreviewImage)
+
+        // Authenticated routes
+        snip.GET("/my", middleware.CheckAuth, activeUser, snippetHandler.GetSnippetsByUsername)
+        snip.GET("/my/export", middleware.CheckAuth, activeUser, snippetHandler.ExportSnippets)
+        snip.GET("/import", middleware.CheckAuth, activeUser, snippetHandler.ImportSnippets)
+        snip.POST("/import", middleware.CheckAuth, activeUser, snippetHandler.ImportSnippets)
+        snip.GET("/new", middleware.CheckAuth, activeUser, snippetHandler.CreateSnippet)
+        snip.POST("/new", middleware.CheckAuth, activeUser, snippetHandler.CreateSnippet)
+        snip.GET("/:id/edit", middleware.CheckAuth, activeUser, snippetHandler.UpdateSnippet)
+        snip.POST("/:id/edit", middleware.CheckAuth, activeUser, snippetHandler.UpdateSnippet)
+        snip.GET("/:id/live", middleware.CheckAuth, activeUser, liveHandler.Page)
+        snip.GET("/:id/live/ws", middleware.CheckAuth, activeUser, liveHandler.Connect)
+        snip.POST("/:id/delete", middleware.CheckAuth, activeUser, snippetHandler.DeleteSnippet)
+        snip.GET("/:id/delete", middleware.CheckAuth, activeUser, snippetHandler.DeleteSnippet)
+        snip.POST("/:id/collections", middleware.CheckAuth, activeUser, collectionHandler.AddSnippet)
+        snip.POST("/:id/collaborators", middleware.CheckAuth, activeUser, snippetHandler.SetCollaborator)
+        snip.POST("/:id/collaborators/:userID/delete", middleware.CheckAuth, activeUser, snippetHandler.RemoveCollaborator)
+    }
+
+    // Collection routes
+    col := router.Group("/collections")
+    {
+        col.GET("/:id", collectionHandler.View)
+        col.GET("", middleware.CheckAuth, activeUser, collectionHandler.List)
+        col.POST("", middleware.CheckAuth, activeUser, collectionHandler.Create)
+        col.GET("/:id/edit", middleware.CheckAuth, activeUser, collectionHandler.Edit)
+        col.POST("/:id/edit", middleware.CheckAuth, activeUser, collectionHandler.Edit)
+        col.POST("/:id/delete", middleware.CheckAuth, activeUser, collectionHandler.Delete)
+        col.POST("/:id/snippets/:snippetID/delete", middleware.CheckAuth, activeUser, collectionHandler.RemoveSnippet)
+        col.POST("/:id/snippets/:snippetID/move", middleware.CheckAuth, activeUser, collectionHandler.MoveSnippet)
+    }
+
+    // JSON API. Clients authenticate with "Authorization: Bearer <token>",
+    // where the token comes from POST /api/tokens or is the value of a
+    // session's Authorization cookie
+    apiUser := middleware.RequireAPIUser(svc.Users)
+    api := router.Group("/api")
+    {
+        api.POST("/tokens", userHandler.APIToken)
+        api.GET("/collections", apiUser, collectionHandler.APIList)
+        api.POST("/collections", apiUser, collectionHandler.APICreate)
+        api.GET("/collections/:id", collectionHandler.APIGet)
+        api.PUT("/collections/:id/order", apiUser, collectionHandler.APIReorder)
+        api.GET("/snippets/export", apiUser, snippetHandler.APIExport)
+    }
+
+    // Organization routes
+    org := router.Group("/orgs")
+    {
+        org.GET("", orgHandler.List)
+        org.GET("/:slug", orgHandler.View)
+        org.POST("", middleware.CheckAuth, activeUser, orgHandler.Create)
+        org.POST("/:slug/members", middleware.CheckAuth, activeUser, orgHandler.AddMember)
+        org.POST("/:slug/members/:userID/role", middleware.CheckAuth, activeUser, orgHandler.SetMemberRole)
+        org.POST("/:slug/members/:userID/delete", middleware.CheckAuth, activeUser, orgHandler.RemoveMember)
+    }
+
+    return router, nil
+}
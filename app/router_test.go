@@ -0,0 +1,193 @@
+package app_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snipetty.com/main/config"
+	"snipetty.com/main/repositories"
+)
+
+func TestAuthRoutes(t *testing.T) {
+	application := newTestApp(t)
+	existing := newClient(t, application)
+	existing.signUp("alice")
+
+	tests := []struct {
+		name         string
+		path         string
+		form         url.Values
+		wantCode     int
+		wantLocation string
+		wantBody     string
+		wantSession  bool
+	}{
+		{"register page", "/register", nil, http.StatusOK, "", "Register", false},
+		{"login page", "/login", nil, http.StatusOK, "", "Login", false},
+		{"register", "/register", url.Values{"username": {"bob"}, "password": {"pw"}}, http.StatusOK, "", "User created successfully", false},
+		{"register taken", "/register", url.Values{"username": {"alice"}, "password": {"pw"}}, http.StatusOK, "", "Username already used", false},
+		{"register missing password", "/register", url.Values{"username": {"carol"}}, http.StatusOK, "", "Error", false},
+		{"register invalid username", "/register", url.Values{"username": {"dave/../x"}, "password": {"pw"}}, http.StatusOK, "", "Usernames are up to 32", false},
+		{"login", "/login", url.Values{"username": {"alice"}, "password": {"password123"}}, http.StatusSeeOther, "/", "", true},
+		{"login wrong password", "/login", url.Values{"username": {"alice"}, "password": {"nope"}}, http.StatusOK, "", "Invalid username or password", false},
+		{"login unknown user", "/login", url.Values{"username": {"nobody"}, "password": {"nope"}}, http.StatusOK, "", "Invalid username or password", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClient(t, application)
+			var res response
+			if tt.form == nil {
+				res = c.get(tt.path)
+			} else {
+				res = c.post(tt.path, tt.form)
+			}
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", res.Code, tt.wantCode)
+			}
+			if res.Location != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", res.Location, tt.wantLocation)
+			}
+			if !strings.Contains(res.Body, tt.wantBody) {
+				t.Errorf("body does not contain %q", tt.wantBody)
+			}
+			if _, ok := c.cookies["Authorization"]; ok != tt.wantSession {
+				t.Errorf("session cookie set = %v, want %v", ok, tt.wantSession)
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	c := newClient(t, newTestApp(t))
+	c.signUp("alice")
+
+	if res := c.get("/logout"); res.Code != http.StatusSeeOther {
+		t.Fatalf("logout: status %d", res.Code)
+	}
+	if res := c.get("/snippets/new"); res.Code != http.StatusSeeOther || res.Location != "/login" {
+		t.Errorf("after logout: status %d, location %q, want redirect to /login", res.Code, res.Location)
+	}
+}
+
+func TestSnippetRoutes(t *testing.T) {
+	application := newTestApp(t)
+	alice := newClient(t, application)
+	alice.signUp("alice")
+	bob := newClient(t, application)
+	bob.signUp("bob")
+	guest := newClient(t, application)
+
+	id := alice.createSnippet("Hello")
+	path := "/snippets/" + id
+
+	edited := snippetForm("Edited")
+	tests := []struct {
+		name         string
+		client       *client
+		method       string
+		path         string
+		form         url.Values
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{"guest views snippet", guest, http.MethodGet, path, nil, http.StatusOK, "", "Hello"},
+		{"guest lists snippets", guest, http.MethodGet, "/snippets", nil, http.StatusOK, "", "Hello"},
+		{"guest lists user snippets", guest, http.MethodGet, "/snippets/user/alice", nil, http.StatusOK, "", "Hello"},
+		{"guest create form", guest, http.MethodGet, "/snippets/new", nil, http.StatusSeeOther, "/login", ""},
+		{"guest create", guest, http.MethodPost, "/snippets/new", snippetForm("Nope"), http.StatusSeeOther, "/login", ""},
+		{"guest edit", guest, http.MethodPost, path + "/edit", edited, http.StatusSeeOther, "/login", ""},
+		{"guest delete", guest, http.MethodPost, path + "/delete", nil, http.StatusSeeOther, "/login", ""},
+		{"create missing fields", alice, http.MethodPost, "/snippets/new", url.Values{"title": {"x"}}, http.StatusBadRequest, "", "Error"},
+		{"my snippets", alice, http.MethodGet, "/snippets/my", nil, http.StatusOK, "", "Hello"},
+		{"owner edit form", alice, http.MethodGet, path + "/edit", nil, http.StatusOK, "", "Hello"},
+		{"other edit form", bob, http.MethodGet, path + "/edit", nil, http.StatusForbidden, "", "Not authorized"},
+		{"other edit", bob, http.MethodPost, path + "/edit", edited, http.StatusForbidden, "", "Not authorized"},
+		{"other delete form", bob, http.MethodGet, path + "/delete", nil, http.StatusForbidden, "", "Not authorized"},
+		{"other delete", bob, http.MethodPost, path + "/delete", nil, http.StatusForbidden, "", "Not authorized"},
+		{"owner edit", alice, http.MethodPost, path + "/edit", edited, http.StatusSeeOther, path, ""},
+		{"shows edit", guest, http.MethodGet, path, nil, http.StatusOK, "", "Edited"},
+		{"owner delete form", alice, http.MethodGet, path + "/delete", nil, http.StatusSeeOther, "/snippets/my", ""},
+		{"owner delete", alice, http.MethodPost, path + "/delete", nil, http.StatusSeeOther, "/snippets/my", ""},
+		{"deleted snippet", guest, http.MethodGet, path, nil, http.StatusNotFound, "", "Snippet not found"},
+	}
+	// The cases run in order: later ones depend on the edit and delete above
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := tt.client.do(tt.method, tt.path, tt.form)
+			if res.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", res.Code, tt.wantCode)
+			}
+			if res.Location != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", res.Location, tt.wantLocation)
+			}
+			if !strings.Contains(res.Body, tt.wantBody) {
+				t.Errorf("body does not contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	c := newClient(t, newTestApp(t))
+
+	generated := c.get("/")
+	if len(generated.RequestID) != 16 {
+		t.Errorf("generated request ID = %q, want 16 hex characters", generated.RequestID)
+	}
+	if again := c.get("/"); again.RequestID == generated.RequestID {
+		t.Errorf("request IDs repeat: %q", again.RequestID)
+	}
+
+	tests := []struct {
+		incoming string
+		keep     bool
+	}{
+		{"abc-123.def_4", true},
+		{"has spaces", false},
+		{strings.Repeat("a", 65), false},
+	}
+	for _, tt := range tests {
+		res := c.send(http.MethodGet, "/", nil, http.Header{"X-Request-Id": {tt.incoming}})
+		if kept := res.RequestID == tt.incoming; kept != tt.keep {
+			t.Errorf("incoming %q: response ID %q, kept = %v, want %v", tt.incoming, res.RequestID, kept, tt.keep)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	forwarded := http.Header{"X-Forwarded-For": {"203.0.113.7"}, "X-Forwarded-Proto": {"https"}}
+	tests := []struct {
+		name    string
+		proxies []string
+		wantIP  string
+		wantURL string
+	}{
+		{"no proxy trusted", nil, "192.0.2.1", "http://example.com/"},
+		{"peer trusted", []string{"192.0.2.0/24"}, "203.0.113.7", "https://example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			application := newTestApp(t, func(cfg *config.Config) { cfg.Server.TrustedProxies = tt.proxies })
+			alice := newClient(t, application)
+			alice.signUp("alice")
+			id := alice.createSnippet("Behind a proxy")
+
+			form := url.Values{"username": {"alice"}, "password": {"password123"}}
+			alice.send(http.MethodPost, "/login", form, forwarded)
+			var event repositories.AuditEvent
+			application.DB.Where("action = ?", repositories.AuditLogin).Order("id DESC").First(&event)
+			if event.IP != tt.wantIP {
+				t.Errorf("audited IP %q, want %q", event.IP, tt.wantIP)
+			}
+
+			page := alice.send(http.MethodGet, "/snippets/"+id, nil, forwarded)
+			if want := `<meta property="og:url" content="` + tt.wantURL + "snippets/" + id; !strings.Contains(page.Body, want) {
+				t.Errorf("snippet page does not contain %s", want)
+			}
+		})
+	}
+}
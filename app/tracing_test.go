@@ -0,0 +1,91 @@
+package app_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"snipetty.com/main/repositories"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	application := newTestApp(t)
+	c := newClient(t, application)
+	c.signUp("alice")
+	id := c.createSnippet("Hello")
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	res := c.send(http.MethodGet, "/snippets/"+id, nil, http.Header{
+		"Traceparent": {"00-" + traceID + "-00f067aa0ba902b7-01"},
+	})
+	if res.Code != http.StatusOK {
+		t.Fatalf("view snippet: status %d", res.Code)
+	}
+	if got := res.TraceID; got != traceID {
+		t.Errorf("X-Trace-ID = %q, want the incoming trace %s", got, traceID)
+	}
+
+	// Server errors show the trace ID; break the database to cause one
+	const failedTraceID = "0af7651916cd43dd8448eb211c80319c"
+	if err := application.DB.Migrator().DropTable(&repositories.Snippet{}); err != nil {
+		t.Fatal(err)
+	}
+	failed := c.send(http.MethodGet, "/snippets/"+id, nil, http.Header{
+		"Traceparent": {"00-" + failedTraceID + "-00f067aa0ba902b7-01"},
+	})
+	if failed.Code != http.StatusInternalServerError || !strings.Contains(failed.Body, "Trace ID: "+failedTraceID) {
+		t.Errorf("error page: status %d, want 500 showing the trace ID", failed.Code)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	var queries []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() == traceID {
+			spans[span.Name()] = span
+			if span.Name() == "gorm.query" {
+				queries = append(queries, span)
+			}
+		}
+	}
+	server, ok := spans["GET /snippets/:id"]
+	if !ok {
+		t.Fatalf("no server span in trace, got %v", keys(spans))
+	}
+	service, ok := spans["SnippetService.GetSnippetByID"]
+	if !ok {
+		t.Fatalf("no service span in trace, got %v", keys(spans))
+	}
+	if len(queries) == 0 {
+		t.Fatalf("no gorm span in trace, got %v", keys(spans))
+	}
+	if service.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("service span is not a child of the server span")
+	}
+	// The page runs more queries after the lookup; one of them is the lookup
+	child := false
+	for _, query := range queries {
+		child = child || query.Parent().SpanID() == service.SpanContext().SpanID()
+	}
+	if !child {
+		t.Errorf("no gorm span is a child of the service span")
+	}
+}
+
+func keys(m map[string]sdktrace.ReadOnlySpan) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,30 @@
+// Package buildinfo reports the version of the running binary.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version is set at build time:
+//
+//	go build -ldflags "-X snipetty.com/main/buildinfo.Version=v1.2.3"
+var Version = "dev"
+
+type Info struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"` // VCS commit, when built from a checkout
+	GoVersion string `json:"go_version"`
+}
+
+func Get() Info {
+	info := Info{Version: Version, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" {
+				info.Revision = setting.Value
+			}
+		}
+	}
+	return info
+}
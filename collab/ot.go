@@ -0,0 +1,228 @@
+// Package collab lets several people edit a snippet at once. Edits are text
+// operations that the server puts in a single order, transforming each one
+// against those it had not seen, and broadcasts to every editor.
+package collab
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "unicode/utf8"
+)
+
+var ErrLengthMismatch = errors.New("operation does not match the document length")
+
+// Component is one step of an Operation. Exactly one field is set.
+type Component struct {
+    Retain int    // Keep this many characters
+    Delete int    // Remove this many characters
+    Insert string // Insert this text
+}
+
+// Operation walks a document from start to end, retaining, deleting and
+// inserting text. Lengths count Unicode code points. In JSON it is the
+// ot.js format: a positive number retains, a negative number deletes and a
+// string inserts, e.g. [3, "abc", -2].
+type Operation []Component
+
+// Retain, Insert and Delete append a component, merging it with the last one
+// where they are of the same kind. An insert always goes before a delete at
+// the same position so equal edits have one representation.
+func (o Operation) Retain(n int) Operation {
+    if n <= 0 {
+        return o
+    }
+    if last := len(o) - 1; last >= 0 && o[last].Retain > 0 {
+        o[last].Retain += n
+        return o
+    }
+    return append(o, Component{Retain: n})
+}
+
+func (o Operation) Insert(s string) Operation {
+    if s == "" {
+        return o
+    }
+    last := len(o) - 1
+    if last >= 0 && o[last].Insert != "" {
+        o[last].Insert += s
+        return o
+    }
+    if last >= 0 && o[last].Delete > 0 {
+        // Keep inserts before deletes
+        if last > 0 && o[last-1].Insert != "" {
+            o[last-1].Insert += s
+            return o
+        }
+        o = append(o, o[last])
+        o[last] = Component{Insert: s}
+        return o
+    }
+    return append(o, Component{Insert: s})
+}
+
+func (o Operation) Delete(n int) Operation {
+    if n <= 0 {
+        return o
+    }
+    if last := len(o) - 1; last >= 0 && o[last].Delete > 0 {
+        o[last].Delete += n
+        return o
+    }
+    return append(o, Component{Delete: n})
+}
+
+// BaseLen is the length of the documents the operation applies to. A sum
+// too large for an int is reported as math.MaxInt, which no document has.
+func (o Operation) BaseLen() int {
+    n := 0
+    for _, c := range o {
+        if c.Retain < 0 || c.Delete < 0 || c.Retain > math.MaxInt-n-c.Delete {
+            return math.MaxInt
+        }
+        n += c.Retain + c.Delete
+    }
+    return n
+}
+
+// TargetLen is the length of the document after applying the operation.
+func (o Operation) TargetLen() int {
+    n := 0
+    for _, c := range o {
+        n += c.Retain + utf8.RuneCountInString(c.Insert)
+    }
+    return n
+}
+
+// Apply returns doc with the operation applied.
+func (o Operation) Apply(doc []rune) ([]rune, error) {
+    if o.BaseLen() != len(doc) {
+        return nil, ErrLengthMismatch
+    }
+    out := make([]rune, 0, o.TargetLen())
+    pos := 0
+    for _, c := range o {
+        switch {
+        case c.Retain > 0:
+            out = append(out, doc[pos:pos+c.Retain]...)
+            pos += c.Retain
+        case c.Delete > 0:
+            pos += c.Delete
+        default:
+            out = append(out, []rune(c.Insert)...)
+        }
+    }
+    return out, nil
+}
+
+// Transform takes two operations made concurrently on the same document and
+// returns a' and b' such that applying a then b' gives the same document as
+// b then a'. When both insert at the same position, a's text goes first.
+func Transform(a, b Operation) (Operation, Operation, error) {
+    if a.BaseLen() != b.BaseLen() {
+        return nil, nil, ErrLengthMismatch
+    }
+    var aPrime, bPrime Operation
+    ia, ib := 0, 0
+    var ca, cb *Component
+    next := func(op Operation, i *int) *Component {
+        if *i >= len(op) {
+            return nil
+        }
+        c := op[*i]
+        *i++
+        return &c
+    }
+    ca, cb = next(a, &ia), next(b, &ib)
+    for ca != nil || cb != nil {
+        if ca != nil && ca.Insert != "" {
+            aPrime = aPrime.Insert(ca.Insert)
+            bPrime = bPrime.Retain(utf8.RuneCountInString(ca.Insert))
+            ca = next(a, &ia)
+            continue
+        }
+        if cb != nil && cb.Insert != "" {
+            aPrime = aPrime.Retain(utf8.RuneCountInString(cb.Insert))
+            bPrime = bPrime.Insert(cb.Insert)
+            cb = next(b, &ib)
+            continue
+        }
+        if ca == nil || cb == nil {
+            return nil, nil, ErrLengthMismatch
+        }
+        n := min(ca.Retain+ca.Delete, cb.Retain+cb.Delete)
+        switch {
+        case ca.Retain > 0 && cb.Retain > 0:
+            aPrime = aPrime.Retain(n)
+            bPrime = bPrime.Retain(n)
+        case ca.Delete > 0 && cb.Retain > 0:
+            aPrime = aPrime.Delete(n)
+        case ca.Retain > 0 && cb.Delete > 0:
+            bPrime = bPrime.Delete(n)
+        }
+        // Both deleting the same text leaves nothing to do
+        ca = consume(ca, n, func() *Component { return next(a, &ia) })
+        cb = consume(cb, n, func() *Component { return next(b, &ib) })
+    }
+    return aPrime, bPrime, nil
+}
+
+// consume shortens a retain or delete by n, moving on when it is used up.
+func consume(c *Component, n int, next func() *Component) *Component {
+    if c.Retain > 0 {
+        c.Retain -= n
+        if c.Retain == 0 {
+            return next()
+        }
+        return c
+    }
+    c.Delete -= n
+    if c.Delete == 0 {
+        return next()
+    }
+    return c
+}
+
+func (o Operation) MarshalJSON() ([]byte, error) {
+    parts := make([]any, 0, len(o))
+    for _, c := range o {
+        switch {
+        case c.Retain > 0:
+            parts = append(parts, c.Retain)
+        case c.Delete > 0:
+            parts = append(parts, -c.Delete)
+        default:
+            parts = append(parts, c.Insert)
+        }
+    }
+    return json.Marshal(parts)
+}
+
+func (o *Operation) UnmarshalJSON(data []byte) error {
+    var parts []json.RawMessage
+    if err := json.Unmarshal(data, &parts); err != nil {
+        return err
+    }
+    var op Operation
+    for _, part := range parts {
+        var s string
+        if err := json.Unmarshal(part, &s); err == nil {
+            op = op.Insert(s)
+            continue
+        }
+        // No document is longer than MaxDocumentLength, so neither is a
+        // retain or delete; this also keeps the lengths from overflowing
+        var n int
+        if err := json.Unmarshal(part, &n); err != nil || n == 0 || n > MaxDocumentLength || n < -MaxDocumentLength {
+            return fmt.Errorf("invalid operation component %s", part)
+        }
+        if n > 0 {
+            op = op.Retain(n)
+        } else {
+            op = op.Delete(-n)
+        }
+    }
+    *o = op
+    return nil
+}
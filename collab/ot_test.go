@@ -0,0 +1,240 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTransformConverges(t *testing.T) {
+	doc := []rune("héllo world")
+	tests := []struct {
+		name string
+		a, b Operation
+		want string
+	}{
+		{"inserts apart", Operation{}.Insert(">").Retain(11), Operation{}.Retain(11).Insert("!"), ">héllo world!"},
+		{"inserts at the same place", Operation{}.Retain(5).Insert(" there").Retain(6), Operation{}.Retain(5).Insert(",").Retain(6), "héllo there, world"},
+		{"insert inside a delete", Operation{}.Retain(2).Insert("LL").Retain(9), Operation{}.Delete(5).Retain(6), "LL world"},
+		{"overlapping deletes", Operation{}.Retain(1).Delete(6).Retain(4), Operation{}.Retain(4).Delete(4).Retain(3), "hrld"},
+		{"same delete", Operation{}.Retain(5).Delete(6), Operation{}.Retain(5).Delete(6), "héllo"},
+		{"replace all", Operation{}.Delete(11).Insert("bye"), Operation{}.Retain(6).Insert("big ").Retain(5), "byebig "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aPrime, bPrime, err := Transform(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("transform: %v", err)
+			}
+			ab := apply(t, apply(t, doc, tt.a), bPrime)
+			ba := apply(t, apply(t, doc, tt.b), aPrime)
+			if string(ab) != string(ba) {
+				t.Fatalf("diverged: a then b' = %q, b then a' = %q", string(ab), string(ba))
+			}
+			if string(ab) != tt.want {
+				t.Errorf("got %q, want %q", string(ab), tt.want)
+			}
+		})
+	}
+}
+
+func TestOperationErrors(t *testing.T) {
+	doc := []rune("abc")
+	if _, err := (Operation{}.Retain(2)).Apply(doc); !errors.Is(err, ErrLengthMismatch) {
+		t.Errorf("apply short op: %v", err)
+	}
+	if _, _, err := Transform(Operation{}.Retain(3), Operation{}.Retain(4)); !errors.Is(err, ErrLengthMismatch) {
+		t.Errorf("transform different bases: %v", err)
+	}
+}
+
+func TestOperationJSON(t *testing.T) {
+	op := Operation{}.Retain(3).Delete(2).Insert("xy").Retain(1)
+	data, err := json.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Inserts go before deletes at the same position
+	if string(data) != `[3,"xy",-2,1]` {
+		t.Errorf("marshal: %s", data)
+	}
+	var back Operation
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, op) {
+		t.Errorf("round trip: %+v, want %+v", back, op)
+	}
+	for _, bad := range []string{`[0]`, `[true]`, `{}`, `[9223372036854775807,"x"]`, `[-2000000]`} {
+		if err := json.Unmarshal([]byte(bad), &back); err == nil {
+			t.Errorf("unmarshal %s: no error", bad)
+		}
+	}
+}
+
+func TestSessionTransformsStaleOperations(t *testing.T) {
+	hub := NewHub()
+	s, alice := join(t, hub, Base{Content: "abc"}, "alice")
+	_, bob := join(t, hub, Base{Content: "ignored"}, "bob")
+
+	// Both edit revision 0
+	if err := s.Submit(alice, 0, Operation{}.Insert("A").Retain(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Submit(bob, 0, Operation{}.Retain(3).Insert("B")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Submit(bob, 5, Operation{}.Retain(1)); !errors.Is(err, ErrInvalidRevision) {
+		t.Errorf("future revision: %v", err)
+	}
+	if doc, rev := s.Snapshot(); doc != "AabcB" || rev != 2 {
+		t.Errorf("snapshot %q@%d, want AabcB@2", doc, rev)
+	}
+
+	var types []string
+	for len(bob.Send) > 0 {
+		types = append(types, (<-bob.Send).Type)
+	}
+	want := []string{TypeInit, TypePresence, TypeOp, TypeAck}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("bob received %v, want %v", types, want)
+	}
+
+	// Both have seen the operations, so they are no longer kept
+	if err := s.Submit(alice, 2, Operation{}.Retain(5).Insert("!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Submit(bob, 3, Operation{}.Retain(6)); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.history) != 2 || s.rev() != 4 {
+		t.Errorf("history of %d operations up to revision %d, want 2 up to 4", len(s.history), s.rev())
+	}
+	if err := s.Submit(bob, 1, Operation{}.Retain(4)); !errors.Is(err, ErrInvalidRevision) {
+		t.Errorf("trimmed revision: %v", err)
+	}
+
+	var saved []string
+	save := func(doc string, base Base) (Base, error) {
+		saved = append(saved, doc)
+		return base, nil
+	}
+	if err := hub.Leave("1", s, alice, save); err != nil || len(saved) != 0 {
+		t.Errorf("saved %q while bob is editing: %v", saved, err)
+	}
+	if len(s.history) != 1 {
+		t.Errorf("%d operations kept for bob alone, want the one after his last", len(s.history))
+	}
+	if err := hub.Leave("1", s, bob, save); err != nil || !reflect.DeepEqual(saved, []string{"AabcB!"}) {
+		t.Errorf("last editor leaving saved %q, want AabcB!: %v", saved, err)
+	}
+	for range bob.Send {
+		// Drain what was sent before leaving; the loop ends once it is closed
+	}
+}
+
+func TestSessionRejectsOverflowingOperations(t *testing.T) {
+	s, alice := join(t, NewHub(), Base{Content: "abc"}, "alice")
+	// Lengths that wrap around to the document's would slice past its end
+	op := Operation{}.Retain(math.MaxInt).Insert("x").Retain(math.MaxInt).Insert("y").Retain(5)
+	if err := s.Submit(alice, 0, op); !errors.Is(err, ErrLengthMismatch) {
+		t.Errorf("overflowing operation: %v, want %v", err, ErrLengthMismatch)
+	}
+	if doc, rev := s.Snapshot(); doc != "abc" || rev != 0 {
+		t.Errorf("snapshot %q@%d after the rejected operation", doc, rev)
+	}
+}
+
+func TestSessionSavesFromItsBase(t *testing.T) {
+	hub := NewHub()
+	first := Base{Content: "abc", UpdatedAt: time.Unix(1, 0)}
+	s, alice := join(t, hub, first, "alice")
+	var bases []Base
+	store := func(doc string, base Base) (Base, error) {
+		bases = append(bases, base)
+		return Base{Content: doc, UpdatedAt: base.UpdatedAt.Add(time.Second)}, nil
+	}
+
+	if err := s.Submit(alice, 0, Operation{}.Retain(3).Insert("d")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if rev, err := s.Save(store); err != nil || rev != 1 {
+			t.Fatalf("save: %d, %v", rev, err)
+		}
+	}
+	if err := s.Submit(alice, 1, Operation{}.Retain(4).Insert("e")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save(store); err != nil {
+		t.Fatal(err)
+	}
+	want := []Base{first, {Content: "abcd", UpdatedAt: time.Unix(2, 0)}}
+	if !reflect.DeepEqual(bases, want) {
+		t.Errorf("stored from %v, want %v", bases, want)
+	}
+
+	failed := errors.New("conflict")
+	if err := s.Submit(alice, 2, Operation{}.Insert("_").Retain(5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save(func(string, Base) (Base, error) { return Base{}, failed }); err != failed {
+		t.Errorf("failed save: %v", err)
+	}
+	if err := hub.Leave("1", s, alice, store); err != nil || len(bases) != 3 {
+		t.Errorf("failed save counted as saved: %v", err)
+	}
+}
+
+func TestHubCloseSavesSessions(t *testing.T) {
+	hub := NewHub()
+	s, alice := join(t, hub, Base{Content: "abc"}, "alice")
+	if err := s.Submit(alice, 0, Operation{}.Retain(3).Insert("d")); err != nil {
+		t.Fatal(err)
+	}
+	saved := make(chan string, 1)
+	go func() {
+		for range alice.Send {
+			// The editor's connection ends when the hub disconnects it
+		}
+		hub.Leave("1", s, alice, func(doc string, base Base) (Base, error) {
+			saved <- doc
+			return base, nil
+		})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hub.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if doc := <-saved; doc != "abcd" {
+		t.Errorf("saved %q on close, want abcd", doc)
+	}
+	if _, _, err := hub.Join("1", Base{}, "bob"); !errors.Is(err, ErrHubClosed) {
+		t.Errorf("join after close: %v", err)
+	}
+}
+
+// join adds user to the snippet "1" of hub.
+func join(t *testing.T, hub *Hub, base Base, user string) (*Session, *Client) {
+	t.Helper()
+	s, c, err := hub.Join("1", base, user)
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	return s, c
+}
+
+func apply(t *testing.T, doc []rune, op Operation) []rune {
+	t.Helper()
+	out, err := op.Apply(doc)
+	if err != nil {
+		t.Fatalf("apply %v: %v", op, err)
+	}
+	return out
+}
@@ -0,0 +1,307 @@
+package collab
+
+import (
+    "context"
+    "errors"
+    "sort"
+    "sync"
+    "time"
+)
+
+// MaxDocumentLength caps a live document, in code points.
+const MaxDocumentLength = 1 << 20
+
+// sendBuffer is how many messages may wait for a slow editor before they are
+// disconnected.
+const sendBuffer = 64
+
+var (
+    ErrInvalidRevision = errors.New("unknown revision")
+    ErrTooLong         = errors.New("the document is too long")
+    ErrHubClosed       = errors.New("live editing has stopped because the server is shutting down")
+)
+
+// Message types exchanged with editors.
+const (
+    TypeInit     = "init"     // Server: the document and revision on joining
+    TypeOp       = "op"       // Both: an operation; from the server, someone else's
+    TypeAck      = "ack"      // Server: the sender's operation was applied
+    TypePresence = "presence" // Server: who is editing
+    TypeSave     = "save"     // Editor: save the document
+    TypeSaved    = "saved"    // Server: the document was saved at Rev
+    TypeError    = "error"    // Server: the last message was rejected
+)
+
+// Message is the JSON sent over the WebSocket in either direction.
+type Message struct {
+    Type  string    `json:"type"`
+    Rev   int       `json:"rev"`
+    Op    Operation `json:"op,omitempty"`
+    Doc   *string   `json:"doc,omitempty"`
+    User  string    `json:"user,omitempty"`
+    Users []string  `json:"users,omitempty"`
+    Error string    `json:"error,omitempty"`
+}
+
+// Client is one editor's connection to a session. Messages for it are queued
+// on Send, which is closed when the client leaves or falls too far behind.
+type Client struct {
+    User string
+    Send chan Message
+
+    rev    int // Oldest revision the editor may still submit against
+    closed bool
+}
+
+// Base is the stored snippet a session started from or last saved. Saving
+// compares it with what is stored now, to notice changes made elsewhere.
+type Base struct {
+    Content   string
+    UpdatedAt time.Time
+}
+
+// SaveFunc stores doc, a session's document, and returns the new base. base
+// is what the session started from or last saved.
+type SaveFunc func(doc string, base Base) (Base, error)
+
+// Session is the shared state of one snippet being edited. Rev counts the
+// operations applied since the session started; an operation based on an
+// older revision is transformed against those that came after it. Only the
+// operations some editor has not seen yet are kept.
+type Session struct {
+    mu      sync.Mutex
+    doc     []rune
+    history []Operation
+    trimmed int // Operations dropped from the start of history
+    saved   int // Revision last saved
+    base    Base
+    clients map[*Client]struct{}
+
+    saving sync.Mutex // Held while a save is in progress
+}
+
+// Submit applies op, made by c against revision rev, and sends it to the
+// other editors. The sender gets an ack with the new revision.
+func (s *Session) Submit(c *Client, rev int, op Operation) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if rev < max(c.rev, s.trimmed) || rev > s.rev() {
+        return ErrInvalidRevision
+    }
+    for _, concurrent := range s.history[rev-s.trimmed:] {
+        var err error
+        op, _, err = Transform(op, concurrent)
+        if err != nil {
+            return err
+        }
+    }
+    if op.TargetLen() > MaxDocumentLength {
+        return ErrTooLong
+    }
+    doc, err := op.Apply(s.doc)
+    if err != nil {
+        return err
+    }
+    s.doc = doc
+    s.history = append(s.history, op)
+    c.rev = rev
+    s.trim()
+    rev = s.rev()
+
+    s.send(c, Message{Type: TypeAck, Rev: rev})
+    for other := range s.clients {
+        if other != c {
+            s.send(other, Message{Type: TypeOp, Rev: rev, Op: op, User: c.User})
+        }
+    }
+    return nil
+}
+
+// Snapshot returns the document and its revision.
+func (s *Session) Snapshot() (string, int) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return string(s.doc), s.rev()
+}
+
+// Save stores the document with store, one save at a time, unless it is
+// already stored. store gets the session's base and returns the new one.
+func (s *Session) Save(store SaveFunc) (int, error) {
+    s.saving.Lock()
+    defer s.saving.Unlock()
+
+    s.mu.Lock()
+    doc, rev, base, saved := string(s.doc), s.rev(), s.base, s.saved
+    s.mu.Unlock()
+    if rev <= saved {
+        return rev, nil
+    }
+    base, err := store(doc, base)
+    if err != nil {
+        return 0, err
+    }
+    s.mu.Lock()
+    s.base = base
+    s.saved = max(s.saved, rev)
+    s.mu.Unlock()
+    return rev, nil
+}
+
+// Saved records that the document was saved at rev and tells every editor.
+func (s *Session) Saved(rev int, user string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.saved = max(s.saved, rev)
+    for c := range s.clients {
+        s.send(c, Message{Type: TypeSaved, Rev: rev, User: user})
+    }
+}
+
+// Reject tells c its last message was refused.
+func (s *Session) Reject(c *Client, err error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.send(c, Message{Type: TypeError, Error: err.Error()})
+}
+
+// send queues m for c without blocking. An editor whose queue is full has
+// fallen behind and is disconnected. Called with s.mu held.
+func (s *Session) send(c *Client, m Message) {
+    if c.closed {
+        return
+    }
+    select {
+    case c.Send <- m:
+    default:
+        s.remove(c)
+    }
+}
+
+// remove closes c and tells the others who is left. Called with s.mu held.
+func (s *Session) remove(c *Client) {
+    if c.closed {
+        return
+    }
+    c.closed = true
+    close(c.Send)
+    delete(s.clients, c)
+    s.trim()
+    s.broadcastPresence()
+}
+
+// rev is the current revision. Called with s.mu held.
+func (s *Session) rev() int {
+    return s.trimmed + len(s.history)
+}
+
+// trim drops the operations that every editor has seen, which no operation
+// can be based on anymore. Called with s.mu held.
+func (s *Session) trim() {
+    oldest := s.rev()
+    for c := range s.clients {
+        oldest = min(oldest, c.rev)
+    }
+    s.history = s.history[oldest-s.trimmed:]
+    s.trimmed = oldest
+}
+
+func (s *Session) broadcastPresence() {
+    users := make([]string, 0, len(s.clients))
+    for c := range s.clients {
+        users = append(users, c.User)
+    }
+    sort.Strings(users)
+    for c := range s.clients {
+        s.send(c, Message{Type: TypePresence, Users: users})
+    }
+}
+
+// Hub keeps one Session per snippet while anyone is editing it.
+type Hub struct {
+    mu       sync.Mutex
+    sessions map[string]*Session
+    editors  sync.WaitGroup // Editors who have not left yet
+    closed   bool
+}
+
+func NewHub() *Hub {
+    return &Hub{sessions: map[string]*Session{}}
+}
+
+// Join adds user to the snippet's session, starting one from base if nobody
+// is editing it yet. The client is first sent the document. Every editor
+// who joined must Leave.
+func (h *Hub) Join(snippetID string, base Base, user string) (*Session, *Client, error) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    if h.closed {
+        return nil, nil, ErrHubClosed
+    }
+    h.editors.Add(1)
+
+    s, ok := h.sessions[snippetID]
+    if !ok {
+        s = &Session{doc: []rune(base.Content), base: base, clients: map[*Client]struct{}{}}
+        h.sessions[snippetID] = s
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    c := &Client{User: user, Send: make(chan Message, sendBuffer), rev: s.rev()}
+    doc := string(s.doc)
+    s.clients[c] = struct{}{}
+    s.send(c, Message{Type: TypeInit, Rev: c.rev, Doc: &doc})
+    s.broadcastPresence()
+    return s, c, nil
+}
+
+// Leave removes c from the session. When c was the last editor the session
+// ends, and Leave stores its unsaved changes with save.
+func (h *Hub) Leave(snippetID string, s *Session, c *Client, save SaveFunc) error {
+    defer h.editors.Done()
+
+    h.mu.Lock()
+    s.mu.Lock()
+    s.remove(c)
+    last := len(s.clients) == 0
+    if last && h.sessions[snippetID] == s {
+        delete(h.sessions, snippetID)
+    }
+    s.mu.Unlock()
+    h.mu.Unlock()
+
+    if !last {
+        return nil
+    }
+    _, err := s.Save(save)
+    return err
+}
+
+// Close disconnects every editor and refuses new ones, then waits until the
+// editors have left and their sessions are saved, or until ctx is done.
+func (h *Hub) Close(ctx context.Context) error {
+    h.mu.Lock()
+    h.closed = true
+    for _, s := range h.sessions {
+        s.mu.Lock()
+        for c := range s.clients {
+            s.send(c, Message{Type: TypeError, Error: ErrHubClosed.Error()})
+            s.remove(c)
+        }
+        s.mu.Unlock()
+    }
+    h.mu.Unlock()
+
+    left := make(chan struct{})
+    go func() {
+        h.editors.Wait()
+        close(left)
+    }()
+    select {
+    case <-left:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
@@ -0,0 +1,367 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// MinSecretLength is the minimum length of the JWT signing secret.
+const MinSecretLength = 32
+
+const defaultEnvFile = ".env"
+
+type Config struct {
+	Port          string
+	Secret        string
+	AdminUsername string
+	MetricsToken  string // Bearer token required by /metrics; empty leaves it open
+	Log           Log
+	Tracing       Tracing
+	Server        Server
+	Database      Database
+	Backup        Backup
+	OIDC          OIDC
+
+	// EmbedFrameAncestors are the origins whose pages may frame snippet
+	// embeds; empty allows any
+	EmbedFrameAncestors []string
+	// PreviewDir caches the link preview images of snippets; empty disables
+	// them
+	PreviewDir string
+}
+
+type Log struct {
+	Level  slog.Level
+	Format string // text or json
+}
+
+// Tracing uses the standard OTEL_* variable names.
+type Tracing struct {
+	Exporter    string // none, otlp or stdout
+	Endpoint    string // OTLP/HTTP endpoint URL; empty uses the exporter default
+	ServiceName string
+	SampleRatio float64 // Fraction of new traces recorded, 0 to 1
+}
+
+type Server struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration // How long in-flight requests may take to drain
+	MaxHeaderBytes    int
+	MaxBodyBytes      int64
+	TLSCertFile       string // TLS is enabled when both files are set
+	TLSKeyFile        string
+	// TrustedProxies are the IPs or CIDRs of reverse proxies whose
+	// X-Forwarded-For and X-Forwarded-Proto headers are believed; empty
+	// trusts none
+	TrustedProxies []string
+}
+
+// TLS reports whether the server should serve HTTPS.
+func (s Server) TLS() bool {
+	return s.TLSCertFile != "" && s.TLSKeyFile != ""
+}
+
+type Database struct {
+	Driver          string // sqlite, postgres or mysql
+	Path            string // SQLite file
+	DSN             string // PostgreSQL/MySQL connection string
+	MaxOpenConns    int    // Zero keeps the database/sql default
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// Backup configures SQLite backups. The directory is created when needed.
+type Backup struct {
+	Dir      string
+	Interval time.Duration // Zero disables scheduled backups
+	Keep     int           // Newest backups kept in Dir; older ones are deleted
+}
+
+type OIDC struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	ProviderName string // Label shown on the login button
+	Scopes       []string
+}
+
+// Enabled reports whether OIDC login is configured.
+func (o OIDC) Enabled() bool {
+	return o.IssuerURL != ""
+}
+
+// Load builds the configuration from command line flags, the environment
+// and an optional .env file, in that order of precedence. It returns the
+// arguments left after the flags (e.g. a subcommand).
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("snippety", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	envFile := fs.String("env-file", defaultEnvFile, "file to load environment variables from")
+	port := fs.String("port", "", "HTTP port (env PORT)")
+	driver := fs.String("db", "", "database driver: sqlite, postgres or mysql (env DB)")
+	path := fs.String("database-path", "", "SQLite database file (env DATABASE_PATH)")
+	dsn := fs.String("database-dsn", "", "PostgreSQL/MySQL DSN (env DATABASE_DSN)")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	// The default .env is optional, an explicitly requested one is not
+	if err := godotenv.Load(*envFile); err != nil {
+		if *envFile != defaultEnvFile || !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, fmt.Errorf("load %s: %w", *envFile, err)
+		}
+	}
+
+	cfg, err := FromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if *port != "" {
+		cfg.Port = *port
+	}
+	if *driver != "" {
+		cfg.Database.Driver = *driver
+	}
+	if *path != "" {
+		cfg.Database.Path = *path
+	}
+	if *dsn != "" {
+		cfg.Database.DSN = *dsn
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// FromEnv reads the configuration from environment variables only, applying
+// defaults. It does not validate the result.
+func FromEnv() (*Config, error) {
+	var errs []error
+	cfg := &Config{
+		Port:          envOr("PORT", "8080"),
+		Secret:        os.Getenv("SECRET"),
+		AdminUsername: os.Getenv("ADMIN_USERNAME"),
+		MetricsToken:  os.Getenv("METRICS_TOKEN"),
+		PreviewDir:    envOr("PREVIEW_DIR", "previews"),
+		Log: Log{
+			Level:  levelEnvOr("LOG_LEVEL", slog.LevelInfo, &errs),
+			Format: envOr("LOG_FORMAT", "text"),
+		},
+		Tracing: Tracing{
+			Exporter:    envOr("OTEL_TRACES_EXPORTER", "none"),
+			Endpoint:    os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+			ServiceName: envOr("OTEL_SERVICE_NAME", "snippety"),
+			SampleRatio: floatEnvOr("OTEL_TRACES_SAMPLER_ARG", 1, &errs),
+		},
+		Server: Server{
+			ReadTimeout:       durationEnvOr("HTTP_READ_TIMEOUT", 15*time.Second, &errs),
+			ReadHeaderTimeout: durationEnvOr("HTTP_READ_HEADER_TIMEOUT", 5*time.Second, &errs),
+			WriteTimeout:      durationEnvOr("HTTP_WRITE_TIMEOUT", 30*time.Second, &errs),
+			IdleTimeout:       durationEnvOr("HTTP_IDLE_TIMEOUT", 120*time.Second, &errs),
+			ShutdownTimeout:   durationEnvOr("HTTP_SHUTDOWN_TIMEOUT", 30*time.Second, &errs),
+			MaxHeaderBytes:    intEnvOr("HTTP_MAX_HEADER_BYTES", 1<<20, &errs),
+			MaxBodyBytes:      int64(intEnvOr("HTTP_MAX_BODY_BYTES", 10<<20, &errs)),
+			TLSCertFile:       os.Getenv("TLS_CERT_FILE"),
+			TLSKeyFile:        os.Getenv("TLS_KEY_FILE"),
+		},
+		Database: Database{
+			Driver:          envOr("DB", "sqlite"),
+			Path:            envOr("DATABASE_PATH", "/opt/auth-service/gorm.db"),
+			DSN:             os.Getenv("DATABASE_DSN"),
+			MaxOpenConns:    intEnv("DB_MAX_OPEN_CONNS", &errs),
+			MaxIdleConns:    intEnv("DB_MAX_IDLE_CONNS", &errs),
+			ConnMaxLifetime: durationEnv("DB_CONN_MAX_LIFETIME", &errs),
+			ConnMaxIdleTime: durationEnv("DB_CONN_MAX_IDLE_TIME", &errs),
+		},
+		Backup: Backup{
+			Dir:      envOr("BACKUP_DIR", "backups"),
+			Interval: durationEnv("BACKUP_INTERVAL", &errs),
+			Keep:     intEnvOr("BACKUP_KEEP", 7, &errs),
+		},
+		OIDC: OIDC{
+			IssuerURL:    os.Getenv("OIDC_ISSUER_URL"),
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			ProviderName: envOr("OIDC_PROVIDER_NAME", "SSO"),
+		},
+	}
+	if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+		cfg.OIDC.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+	}
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		cfg.Server.TrustedProxies = strings.Fields(strings.ReplaceAll(proxies, ",", " "))
+	}
+	if ancestors := os.Getenv("EMBED_FRAME_ANCESTORS"); ancestors != "" {
+		cfg.EmbedFrameAncestors = strings.Fields(strings.ReplaceAll(ancestors, ",", " "))
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT must be a number between 1 and 65535, got %q", c.Port))
+	}
+
+	if c.Secret == "" {
+		errs = append(errs, errors.New("SECRET must be set"))
+	} else if len(c.Secret) < MinSecretLength {
+		errs = append(errs, fmt.Errorf("SECRET must be at least %d characters, got %d", MinSecretLength, len(c.Secret)))
+	}
+
+	switch c.Database.Driver {
+	case "sqlite":
+		dir := filepath.Dir(c.Database.Path)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("DATABASE_PATH directory %s does not exist", dir))
+		}
+	case "postgres", "mysql":
+		if c.Database.DSN == "" {
+			errs = append(errs, fmt.Errorf("DATABASE_DSN must be set when DB=%s", c.Database.Driver))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("DB must be sqlite, postgres or mysql, got %q", c.Database.Driver))
+	}
+
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be text or json, got %q", c.Log.Format))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	default:
+		errs = append(errs, fmt.Errorf("OTEL_TRACES_EXPORTER must be none, otlp or stdout, got %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("OTEL_TRACES_SAMPLER_ARG must be between 0 and 1, got %v", c.Tracing.SampleRatio))
+	}
+
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("DB_MAX_OPEN_CONNS and DB_MAX_IDLE_CONNS must not be negative"))
+	}
+
+	if c.Backup.Interval < 0 || c.Backup.Keep < 1 {
+		errs = append(errs, errors.New("BACKUP_INTERVAL must not be negative and BACKUP_KEEP must be at least 1"))
+	}
+	if c.Backup.Interval > 0 && c.Database.Driver != "sqlite" {
+		errs = append(errs, fmt.Errorf("BACKUP_INTERVAL requires DB=sqlite, got %q", c.Database.Driver))
+	}
+
+	for _, origin := range c.EmbedFrameAncestors {
+		if strings.ContainsAny(origin, ";'\"") {
+			errs = append(errs, fmt.Errorf("EMBED_FRAME_ANCESTORS must be origins such as https://wiki.example.com, got %q", origin))
+		}
+	}
+
+	if c.Server.MaxHeaderBytes <= 0 || c.Server.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("HTTP_MAX_HEADER_BYTES and HTTP_MAX_BODY_BYTES must be positive"))
+	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		if cidrErr != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("TRUSTED_PROXIES must be IP addresses or CIDRs, got %q", proxy))
+		}
+	}
+	for _, file := range []string{c.Server.TLSCertFile, c.Server.TLSKeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Errorf("TLS file %s is not readable: %v", file, err))
+		}
+	}
+
+	if c.OIDC.Enabled() && (c.OIDC.ClientID == "" || c.OIDC.RedirectURL == "") {
+		errs = append(errs, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set when OIDC_ISSUER_URL is set"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func intEnv(name string, errs *[]error) int {
+	return intEnvOr(name, 0, errs)
+}
+
+func intEnvOr(name string, fallback int, errs *[]error) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s must be a number, got %q", name, value))
+	}
+	return n
+}
+
+func floatEnvOr(name string, fallback float64, errs *[]error) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s must be a number, got %q", name, value))
+	}
+	return f
+}
+
+func durationEnv(name string, errs *[]error) time.Duration {
+	return durationEnvOr(name, 0, errs)
+}
+
+func durationEnvOr(name string, fallback time.Duration, errs *[]error) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s must be a duration such as 30m, got %q", name, value))
+	}
+	return d
+}
+
+func levelEnvOr(name string, fallback slog.Level, errs *[]error) slog.Level {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		*errs = append(*errs, fmt.Errorf("%s must be debug, info, warn or error, got %q", name, value))
+		return fallback
+	}
+	return level
+}
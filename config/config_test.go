@@ -0,0 +1,106 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func validConfig(t *testing.T) Config {
+	return Config{
+		Port:    "8080",
+		Secret:  strings.Repeat("s", MinSecretLength),
+		Log:     Log{Format: "text"},
+		Tracing: Tracing{Exporter: "none", SampleRatio: 1},
+		Server:  Server{MaxHeaderBytes: 1 << 20, MaxBodyBytes: 10 << 20},
+		Backup:  Backup{Dir: "backups", Keep: 7},
+		Database: Database{
+			Driver: "sqlite",
+			Path:   t.TempDir() + "/test.db",
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"port not a number", func(c *Config) { c.Port = "http" }, "PORT must be a number"},
+		{"port out of range", func(c *Config) { c.Port = "70000" }, "PORT must be a number"},
+		{"missing secret", func(c *Config) { c.Secret = "" }, "SECRET must be set"},
+		{"short secret", func(c *Config) { c.Secret = "short" }, "SECRET must be at least 32 characters"},
+		{"unknown driver", func(c *Config) { c.Database.Driver = "oracle" }, `DB must be sqlite, postgres or mysql, got "oracle"`},
+		{"missing sqlite dir", func(c *Config) { c.Database.Path = "/does/not/exist/gorm.db" }, "DATABASE_PATH directory /does/not/exist does not exist"},
+		{"postgres without dsn", func(c *Config) { c.Database.Driver = "postgres" }, "DATABASE_DSN must be set when DB=postgres"},
+		{"mysql with dsn", func(c *Config) { c.Database.Driver = "mysql"; c.Database.DSN = "u:p@tcp(db)/x" }, ""},
+		{"unknown log format", func(c *Config) { c.Log.Format = "xml" }, `LOG_FORMAT must be text or json, got "xml"`},
+		{"unknown trace exporter", func(c *Config) { c.Tracing.Exporter = "jaeger" }, `OTEL_TRACES_EXPORTER must be none, otlp or stdout, got "jaeger"`},
+		{"sample ratio above 1", func(c *Config) { c.Tracing.SampleRatio = 2 }, "OTEL_TRACES_SAMPLER_ARG must be between 0 and 1"},
+		{"negative pool", func(c *Config) { c.Database.MaxOpenConns = -1 }, "must not be negative"},
+		{"backups kept below 1", func(c *Config) { c.Backup.Keep = 0 }, "BACKUP_KEEP must be at least 1"},
+		{"scheduled backups without sqlite", func(c *Config) {
+			c.Database.Driver, c.Database.DSN, c.Backup.Interval = "postgres", "host=db", time.Hour
+		}, `BACKUP_INTERVAL requires DB=sqlite, got "postgres"`},
+		{"zero body limit", func(c *Config) { c.Server.MaxBodyBytes = 0 }, "must be positive"},
+		{"tls cert without key", func(c *Config) { c.Server.TLSCertFile = "/tmp/cert.pem" }, "TLS_CERT_FILE and TLS_KEY_FILE must be set together"},
+		{"missing tls files", func(c *Config) { c.Server.TLSCertFile = "/no/cert.pem"; c.Server.TLSKeyFile = "/no/key.pem" }, "TLS file /no/cert.pem is not readable"},
+		{"trusted proxy cidr", func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.0/8", "::1"} }, ""},
+		{"trusted proxy hostname", func(c *Config) { c.Server.TrustedProxies = []string{"proxy.internal"} }, `TRUSTED_PROXIES must be IP addresses or CIDRs, got "proxy.internal"`},
+		{"embed ancestor with a directive", func(c *Config) { c.EmbedFrameAncestors = []string{"https://wiki.example.com;script-src"} }, "EMBED_FRAME_ANCESTORS must be origins"},
+		{"partial oidc", func(c *Config) { c.OIDC.IssuerURL = "https://idp.example.com" }, "OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig(t)
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	cfg := validConfig(t)
+	cfg.Port = ""
+	cfg.Secret = ""
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "PORT") || !strings.Contains(err.Error(), "SECRET") {
+		t.Fatalf("Validate() = %v, want both PORT and SECRET errors", err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SECRET", strings.Repeat("s", MinSecretLength))
+	t.Setenv("PORT", "9000")
+	t.Setenv("DATABASE_PATH", dir+"/env.db")
+
+	cfg, args, err := Load([]string{"-port", "9100", "migrate", "status"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Port != "9100" {
+		t.Errorf("Port = %q, want flag value 9100", cfg.Port)
+	}
+	if cfg.Database.Path != dir+"/env.db" {
+		t.Errorf("Database.Path = %q, want env value", cfg.Database.Path)
+	}
+	if strings.Join(args, " ") != "migrate status" {
+		t.Errorf("args = %v, want [migrate status]", args)
+	}
+
+	if _, _, err := Load([]string{"-env-file", dir + "/missing.env"}); err == nil {
+		t.Error("Load with a missing explicit -env-file succeeded")
+	}
+}
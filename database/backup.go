@@ -0,0 +1,322 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+	"snipetty.com/main/config"
+)
+
+var (
+	ErrBackupUnsupported = errors.New("backups are only supported for SQLite databases")
+	ErrBackupNotFound    = errors.New("backup not found")
+)
+
+const (
+	backupPrefix     = "snippety-"
+	backupSuffix     = ".db"
+	backupTimeFormat = "20060102-150405.000"
+)
+
+// BackupFile is a backup in the backup directory.
+type BackupFile struct {
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// Backups writes online backups of a SQLite database to a directory and
+// keeps only the newest ones.
+type Backups struct {
+	db   *gorm.DB
+	dir  string
+	keep int
+	mu   sync.Mutex // One backup at a time
+}
+
+func NewBackups(db *gorm.DB, cfg config.Backup) *Backups {
+	return &Backups{db: db, dir: cfg.Dir, keep: cfg.Keep}
+}
+
+// Create backs up the database to a new file in the backup directory and
+// deletes the backups beyond the newest keep.
+func (b *Backups) Create(ctx context.Context) (*BackupFile, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.MkdirAll(b.dir, 0o750); err != nil {
+		return nil, err
+	}
+	createdAt := time.Now().UTC()
+	name := backupPrefix + createdAt.Format(backupTimeFormat) + backupSuffix
+	path := filepath.Join(b.dir, name)
+	if err := Backup(ctx, b.db, path); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := b.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := b.keep; i < len(backups); i++ {
+		if err := os.Remove(filepath.Join(b.dir, backups[i].Name)); err != nil {
+			slog.WarnContext(ctx, "failed to delete old backup", "name", backups[i].Name, "error", err)
+		}
+	}
+	return &BackupFile{Name: name, Size: info.Size(), CreatedAt: createdAt}, nil
+}
+
+// List returns the backups in the backup directory, newest first.
+func (b *Backups) List() ([]BackupFile, error) {
+	entries, err := os.ReadDir(b.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []BackupFile
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), backupPrefix)
+		stamp, ok2 := strings.CutSuffix(stamp, backupSuffix)
+		if !ok || !ok2 || entry.IsDir() {
+			continue
+		}
+		createdAt, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, BackupFile{Name: entry.Name(), Size: info.Size(), CreatedAt: createdAt})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// Path returns the path of the named backup. Only names returned by List are
+// accepted, so the name can come from a request.
+func (b *Backups) Path(name string) (string, error) {
+	backups, err := b.List()
+	if err != nil {
+		return "", err
+	}
+	for _, backup := range backups {
+		if backup.Name == name {
+			return filepath.Join(b.dir, name), nil
+		}
+	}
+	return "", ErrBackupNotFound
+}
+
+// Schedule creates a backup every interval until ctx is done.
+func (b *Backups) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			backup, err := b.Create(ctx)
+			if err != nil {
+				slog.Error("scheduled backup failed", "error", err)
+				continue
+			}
+			slog.Info("scheduled backup written", "name", backup.Name, "bytes", backup.Size)
+		}
+	}
+}
+
+// Backup copies a SQLite database to dest with SQLite's online backup API.
+// The copy is a consistent snapshot even while other connections write. It
+// is written next to dest first and renamed into place once complete.
+func Backup(ctx context.Context, db *gorm.DB, dest string) error {
+	if db.Dialector.Name() != "sqlite" {
+		return ErrBackupUnsupported
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	src, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+	destDB, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+	dst, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = dst.Raw(func(dstConn any) error {
+		return src.Raw(func(srcConn any) error {
+			backup, err := dstConn.(*sqlite3.SQLiteConn).Backup("main", srcConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			for {
+				// -1 copies every page in one step; busy or locked sources
+				// report not done and are retried
+				done, err := backup.Step(-1)
+				if err != nil {
+					backup.Close()
+					return err
+				}
+				if done {
+					return backup.Finish()
+				}
+				select {
+				case <-ctx.Done():
+					backup.Close()
+					return ctx.Err()
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+		})
+	})
+	dst.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("backup: %w", err)
+	}
+	if err := destDB.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// VerifyBackup checks that path is an intact SQLite database written by this
+// application, with no migrations newer than the ones this binary knows.
+func VerifyBackup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("%s is not a SQLite database: %w", path, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%s failed the integrity check: %s", path, result)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("%s has no schema_migrations table: %w", path, err)
+	}
+	defer rows.Close()
+	known := map[int]bool{}
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	applied := 0
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return err
+		}
+		if !known[version] {
+			return fmt.Errorf("%s has migration %d, which this version does not know", path, version)
+		}
+		applied++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if applied == 0 {
+		return fmt.Errorf("%s has no applied migrations", path)
+	}
+	return nil
+}
+
+// Restore verifies backup and replaces the SQLite database at dbPath with a
+// copy of it. The server must not be running. The replaced database is kept
+// next to it and its path returned, empty if there was none.
+func Restore(ctx context.Context, backup, dbPath string) (_ string, err error) {
+	if err := VerifyBackup(ctx, backup); err != nil {
+		return "", err
+	}
+
+	tmp := dbPath + ".restore"
+	if err := copyFile(backup, tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	previous := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		previous = fmt.Sprintf("%s.pre-restore-%s", dbPath, time.Now().UTC().Format("20060102-150405"))
+		if err := os.Rename(dbPath, previous); err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+	}
+	// A journal left by the old database would be applied to the new one
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if _, err := os.Stat(dbPath + suffix); err != nil {
+			continue
+		}
+		if previous == "" {
+			err = os.Remove(dbPath + suffix)
+		} else {
+			err = os.Rename(dbPath+suffix, previous+suffix)
+		}
+		if err != nil {
+			return previous, err
+		}
+	}
+	return previous, os.Rename(tmp, dbPath)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
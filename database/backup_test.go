@@ -0,0 +1,154 @@
+package database_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"snipetty.com/main/config"
+	"snipetty.com/main/database"
+	"snipetty.com/main/repositories"
+)
+
+var ctx = context.Background()
+
+func openSQLite(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+	db, err := database.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func countUsers(t *testing.T, db *gorm.DB) int64 {
+	t.Helper()
+	var n int64
+	if err := db.Model(&repositories.User{}).Count(&n).Error; err != nil {
+		t.Fatalf("count users: %v", err)
+	}
+	return n
+}
+
+func addUser(t *testing.T, db *gorm.DB, username string) {
+	t.Helper()
+	if err := repositories.NewUserRepository(db).Create(ctx, &repositories.User{Username: username, Password: "x"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+}
+
+func TestBackupsKeepNewest(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "app.db"))
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	backups := database.NewBackups(db, config.Backup{Dir: filepath.Join(t.TempDir(), "backups"), Keep: 2})
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		backup, err := backups.Create(ctx)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		names = append(names, backup.Name)
+	}
+
+	list, err := backups.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != names[2] || list[1].Name != names[1] {
+		t.Fatalf("List = %+v, want the two newest of %v", list, names)
+	}
+	if _, err := backups.Path(names[0]); err != database.ErrBackupNotFound {
+		t.Errorf("Path(pruned) error = %v, want ErrBackupNotFound", err)
+	}
+	if _, err := backups.Path("../app.db"); err != database.ErrBackupNotFound {
+		t.Errorf("Path(../app.db) error = %v, want ErrBackupNotFound", err)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.db")
+	db := openSQLite(t, path)
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	addUser(t, db, "alice")
+
+	backup := filepath.Join(dir, "backup.db")
+	if err := database.Backup(ctx, db, backup); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if err := database.VerifyBackup(ctx, backup); err != nil {
+		t.Fatalf("VerifyBackup: %v", err)
+	}
+
+	// Changes after the backup are undone by the restore
+	addUser(t, db, "bob")
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	previous, err := database.Restore(ctx, backup, path)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if previous == "" {
+		t.Fatal("Restore did not keep the previous database")
+	}
+	if got := countUsers(t, openSQLite(t, path)); got != 1 {
+		t.Errorf("restored database has %d users, want 1", got)
+	}
+	if got := countUsers(t, openSQLite(t, previous)); got != 2 {
+		t.Errorf("previous database has %d users, want 2", got)
+	}
+}
+
+func TestVerifyBackupRejects(t *testing.T) {
+	dir := t.TempDir()
+
+	garbage := filepath.Join(dir, "garbage.db")
+	os.WriteFile(garbage, []byte("not a database"), 0o600)
+
+	empty := filepath.Join(dir, "empty.db")
+	openSQLite(t, empty).Exec("CREATE TABLE t (id INTEGER)")
+
+	future := filepath.Join(dir, "future.db")
+	db := openSQLite(t, future)
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	db.Exec("INSERT INTO schema_migrations (version, name) VALUES (9999, 'from_the_future')")
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{filepath.Join(dir, "missing.db"), "no such file"},
+		{garbage, "not a SQLite database"},
+		{empty, "no schema_migrations table"},
+		{future, "migration 9999"},
+	}
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.path), func(t *testing.T) {
+			err := database.VerifyBackup(ctx, tt.path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyBackup = %v, want error containing %q", err, tt.wantErr)
+			}
+			if _, err := database.Restore(ctx, tt.path, filepath.Join(dir, "target.db")); err == nil {
+				t.Error("Restore accepted an invalid backup")
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package database
+
+import "gorm.io/gorm"
+
+// StatementHooks are run around every statement GORM executes, for plugins
+// that instrument the database. Each function receives the operation name
+// (create, query, update, delete, row or raw) once, at registration.
+type StatementHooks struct {
+	Before func(operation string) func(*gorm.DB)
+	After  func(operation string) func(*gorm.DB)
+}
+
+// RegisterHooks installs hooks on db around each operation, under callback
+// names prefixed by plugin so several plugins can coexist.
+func RegisterHooks(db *gorm.DB, plugin string, hooks StatementHooks) error {
+	cb := db.Callback()
+	register := []struct {
+		operation string
+		before    func(string, func(*gorm.DB)) error
+		after     func(string, func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+	for _, r := range register {
+		if err := r.before(plugin+":before_"+r.operation, hooks.Before(r.operation)); err != nil {
+			return err
+		}
+		if err := r.after(plugin+":after_"+r.operation, hooks.After(r.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,11 +2,15 @@ package database
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+	"snipetty.com/main/config"
+	"snipetty.com/main/logging"
 )
 
 var dbInstance *gorm.DB
@@ -15,41 +19,96 @@ func GetDB() *gorm.DB {
 	return dbInstance
 }
 
-func setupSQLite() (*gorm.DB, error) {
-	dbLocation := os.Getenv("DATABASE_PATH")
-	if dbLocation == "" {
-		dbLocation = "/opt/auth-service/gorm.db"
-	}
-
+func setupSQLite(dbLocation string) (*gorm.DB, error) {
 	// Create the sqlite file if it's not available
 	if _, err := os.Stat(dbLocation); err != nil {
 		if _, err = os.Create(dbLocation); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("create sqlite database: %w", err)
 		}
 	}
 
-	db, err := gorm.Open(sqlite.Open(dbLocation), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+	return Open("sqlite", dbLocation)
+}
+
+// Open connects to a database of the given dialect (sqlite, postgres or
+// mysql). For sqlite the dsn is the database file path.
+//
+// Example DSNs:
+//
+//	postgres: host=localhost user=snippety password=secret dbname=snippety port=5432 sslmode=disable
+//	mysql:    snippety:secret@tcp(localhost:3306)/snippety?charset=utf8mb4&parseTime=True&loc=Local
+func Open(dialect, dsn string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch dialect {
+	case "sqlite":
+		dialector = sqlite.Open(dsn)
+	case "postgres":
+		dialector = postgres.Open(dsn)
+	case "mysql":
+		dialector = mysql.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported database %q", dialect)
+	}
+
+	return gorm.Open(dialector, &gorm.Config{
+		Logger: logging.NewGormLogger(slog.Default()),
 	})
-	return db, err
 }
 
-func InitializeDatabaseLayer() error {
-	dbs := os.Getenv("DB")
+// configurePool applies the pool settings. Zero values keep the
+// database/sql defaults.
+func configurePool(db *gorm.DB, cfg config.Database) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	if cfg.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+	return nil
+}
+
+func InitializeDatabaseLayer(cfg config.Database) error {
 	var db *gorm.DB
 	var err error
 
-	switch dbs {
+	switch cfg.Driver {
 	case "sqlite":
-		db, err = setupSQLite()
-		break
+		db, err = setupSQLite(cfg.Path)
+	case "postgres", "mysql":
+		db, err = Open(cfg.Driver, cfg.DSN)
 	default:
 		return fmt.Errorf("No database found, set the DB env")
 	}
 	if err != nil {
 		return err
 	}
+	if err := configurePool(db, cfg); err != nil {
+		return err
+	}
 
 	dbInstance = db
 	return nil
-}
\ No newline at end of file
+}
+
+// Close closes the connection pool of the default database.
+func Close() error {
+	if dbInstance == nil {
+		return nil
+	}
+	sqlDB, err := dbInstance.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
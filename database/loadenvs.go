@@ -1,14 +0,0 @@
-package database
-
-import (
-	"log"
-
-	"github.com/joho/godotenv"
-)
-
-func LoadEnvs() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-}
\ No newline at end of file
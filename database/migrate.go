@@ -1,34 +1,142 @@
 package database
 
 import (
-    "log"
-	"snipetty.com/main/repositories"	
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
 )
 
-func TablesExist() bool {
-    db := GetDB()
-    
-    // Check if tables exist
-    hasUser := db.Migrator().HasTable(&repositories.User{})
-    hasSnippet := db.Migrator().HasTable(&repositories.Snippet{})
-    
-    return hasUser && hasSnippet
+// Migration is one numbered schema change. Up and Down run inside a
+// transaction together with the schema_migrations bookkeeping. Note that
+// MySQL commits DDL statements implicitly, so a failing migration there may
+// be partially applied.
+//
+// Migrations must not reference the models in the repositories package: the
+// models keep changing while a migration has to describe the schema as it was
+// at that version. Declare the structs the migration needs inside it instead.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration is a row of the schema_migrations table.
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// MigrationStatus describes whether a known migration has been applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
 }
 
+// Migrate applies all pending migrations to the default database.
 func Migrate() error {
-    db := GetDB()
-    
-    // Run migrations
-    err := db.AutoMigrate(
-        &repositories.User{},
-        &repositories.Snippet{}, // Note: Changed from Snippets to Snippet to match model name
-    )
-    
-    if err != nil {
-        log.Printf("Failed to migrate database: %v", err)
-        return err
-    }
-    
-    log.Println("Database migration completed successfully")
-    return nil
-}
\ No newline at end of file
+	return MigrateUp(GetDB())
+}
+
+// MigrateUp applies every pending migration in version order, each in its own
+// transaction.
+func MigrateUp(db *gorm.DB) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		slog.Info("applying migration", "version", m.Version, "name", m.Name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown rolls back the most recently applied migration. It returns
+// false when there is nothing to roll back.
+func MigrateDown(db *gorm.DB) (bool, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return false, err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		slog.Info("rolling back migration", "version", m.Version, "name", m.Name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return false, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Status lists every known migration and whether it has been applied.
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		row, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{Migration: m, Applied: ok, AppliedAt: row.AppliedAt})
+	}
+	return statuses, nil
+}
+
+// PendingMigrations returns how many known migrations have not been applied.
+func PendingMigrations(db *gorm.DB) (int, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func appliedMigrations(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
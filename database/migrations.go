@@ -0,0 +1,196 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrations is the ordered list of schema changes. Append new migrations at
+// the end with the next version number; never edit one that has shipped.
+var migrations = []Migration{
+	{
+		// Matches the schema previously created by AutoMigrate, so existing
+		// databases are brought up to date without losing data.
+		Version: 1,
+		Name:    "initial_schema",
+		Up: func(tx *gorm.DB) error {
+			type User struct {
+				ID          uint   `gorm:"primary_key"`
+				Username    string `gorm:"size:191;unique"`
+				Password    string
+				Email       string `gorm:"size:191;index"`
+				DisplayName string
+				Bio         string
+				AvatarURL   string
+				Role        string  `gorm:"size:20;default:user"`
+				Disabled    bool
+				OIDCSubject *string `gorm:"column:oidc_subject;size:191;uniqueIndex"`
+				CreatedAt   time.Time
+				UpdatedAt   time.Time
+			}
+			type Snippet struct {
+				ID          string `gorm:"size:191"`
+				UserID      uint
+				User        User `gorm:"foreignKey:UserID"`
+				Title       string
+				Content     string
+				Language    string
+				Description string
+				CreatedAt   time.Time
+				UpdatedAt   time.Time
+			}
+			type AuditEvent struct {
+				ID        uint      `gorm:"primary_key"`
+				CreatedAt time.Time `gorm:"index"`
+				Action    string    `gorm:"size:50;index"`
+				ActorID   *uint     `gorm:"index"`
+				ActorName string    `gorm:"size:191;index"`
+				Target    string
+				IP        string
+				Detail    string
+			}
+			return tx.AutoMigrate(&User{}, &Snippet{}, &AuditEvent{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("audit_events", "snippets", "users")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "collections",
+		Up: func(tx *gorm.DB) error {
+			type Collection struct {
+				ID          uint `gorm:"primary_key"`
+				UserID      uint `gorm:"index"`
+				Title       string
+				Description string
+				Visibility  string `gorm:"size:20;default:public"`
+				CreatedAt   time.Time
+				UpdatedAt   time.Time
+			}
+			type CollectionSnippet struct {
+				CollectionID uint   `gorm:"primaryKey;autoIncrement:false"`
+				SnippetID    string `gorm:"primaryKey;size:191;index"`
+				Position     int
+				CreatedAt    time.Time
+			}
+			return tx.AutoMigrate(&Collection{}, &CollectionSnippet{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("collection_snippets", "collections")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "organizations",
+		Up: func(tx *gorm.DB) error {
+			type Organization struct {
+				ID          uint   `gorm:"primary_key"`
+				Slug        string `gorm:"size:191;unique"`
+				Name        string
+				Description string
+				CreatedAt   time.Time
+				UpdatedAt   time.Time
+			}
+			type OrganizationMember struct {
+				OrganizationID uint   `gorm:"primaryKey;autoIncrement:false"`
+				UserID         uint   `gorm:"primaryKey;autoIncrement:false;index"`
+				Role           string `gorm:"size:20;default:member"`
+				CreatedAt      time.Time
+			}
+			// Existing snippets stay personal and public
+			type Snippet struct {
+				OrganizationID *uint  `gorm:"index"`
+				Visibility     string `gorm:"size:20;default:public"`
+			}
+			if err := tx.AutoMigrate(&Organization{}, &OrganizationMember{}); err != nil {
+				return err
+			}
+			for _, field := range []string{"OrganizationID", "Visibility"} {
+				if err := tx.Migrator().AddColumn(&Snippet{}, field); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().CreateIndex(&Snippet{}, "OrganizationID")
+		},
+		Down: func(tx *gorm.DB) error {
+			type Snippet struct {
+				OrganizationID *uint  `gorm:"index"`
+				Visibility     string `gorm:"size:20;default:public"`
+			}
+			if err := tx.Migrator().DropIndex(&Snippet{}, "OrganizationID"); err != nil {
+				return err
+			}
+			for _, field := range []string{"Visibility", "OrganizationID"} {
+				if err := tx.Migrator().DropColumn(&Snippet{}, field); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable("organization_members", "organizations")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "snippet_collaborators",
+		Up: func(tx *gorm.DB) error {
+			type SnippetCollaborator struct {
+				SnippetID string `gorm:"primaryKey;size:191"`
+				UserID    uint   `gorm:"primaryKey;autoIncrement:false;index"`
+				Role      string `gorm:"size:20"`
+				CreatedAt time.Time
+			}
+			type Snippet struct {
+				UpdatedByID *uint
+			}
+			if err := tx.AutoMigrate(&SnippetCollaborator{}); err != nil {
+				return err
+			}
+			return tx.Migrator().AddColumn(&Snippet{}, "UpdatedByID")
+		},
+		Down: func(tx *gorm.DB) error {
+			type Snippet struct {
+				UpdatedByID *uint
+			}
+			if err := tx.Migrator().DropColumn(&Snippet{}, "UpdatedByID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("snippet_collaborators")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "snippet_revisions",
+		Up: func(tx *gorm.DB) error {
+			type SnippetRevision struct {
+				ID        uint   `gorm:"primary_key"`
+				SnippetID string `gorm:"size:191;index"`
+				EditorID  *uint  `gorm:"index"`
+				CreatedAt time.Time
+			}
+			type Snippet struct {
+				UpdatedByID *uint
+			}
+			if err := tx.AutoMigrate(&SnippetRevision{}); err != nil {
+				return err
+			}
+			// The last edit by someone other than the author is the only
+			// history there is so far
+			err := tx.Exec("INSERT INTO snippet_revisions (snippet_id, editor_id, created_at) " +
+				"SELECT id, updated_by_id, updated_at FROM snippets WHERE updated_by_id IS NOT NULL").Error
+			if err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&Snippet{}, "UpdatedByID")
+		},
+		Down: func(tx *gorm.DB) error {
+			type Snippet struct {
+				UpdatedByID *uint
+			}
+			if err := tx.Migrator().AddColumn(&Snippet{}, "UpdatedByID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("snippet_revisions")
+		},
+	},
+}
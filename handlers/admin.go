@@ -0,0 +1,204 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+    "snipetty.com/main/database"
+    "snipetty.com/main/middleware"
+    "snipetty.com/main/repositories"
+    "snipetty.com/main/services"
+)
+
+type AdminHandler struct {
+    service AdminService
+    audit   AuditService
+}
+
+func NewAdminHandler(service AdminService, audit AuditService) *AdminHandler {
+    return &AdminHandler{service: service, audit: audit}
+}
+
+func (h *AdminHandler) Dashboard(c *gin.Context) {
+    h.render(c, http.StatusOK, "")
+}
+
+func (h *AdminHandler) SetRole(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        h.render(c, http.StatusBadRequest, "Invalid user ID")
+        return
+    }
+    role := repositories.Role(c.PostForm("role"))
+    if err := h.service.SetRole(auditContext(c), middleware.CurrentUser(c), uint(id), role); err != nil {
+        h.renderError(c, err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, "/admin")
+}
+
+func (h *AdminHandler) SetDisabled(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        h.render(c, http.StatusBadRequest, "Invalid user ID")
+        return
+    }
+    disabled := c.PostForm("disabled") == "true"
+    if err := h.service.SetDisabled(auditContext(c), middleware.CurrentUser(c), uint(id), disabled); err != nil {
+        h.renderError(c, err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, "/admin")
+}
+
+func (h *AdminHandler) RemoveSnippet(c *gin.Context) {
+    if err := h.service.RemoveSnippet(auditContext(c), middleware.CurrentUser(c), c.Param("id")); err != nil {
+        h.renderError(c, err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, "/admin")
+}
+
+// CreateBackup writes a backup of the database to the backup directory.
+func (h *AdminHandler) CreateBackup(c *gin.Context) {
+    if _, err := h.service.CreateBackup(auditContext(c), middleware.CurrentUser(c)); err != nil {
+        h.renderError(c, err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, "/admin")
+}
+
+// DownloadBackup sends a backup file.
+func (h *AdminHandler) DownloadBackup(c *gin.Context) {
+    name := c.Param("name")
+    path, err := h.service.BackupPath(auditContext(c), middleware.CurrentUser(c), name)
+    if err != nil {
+        h.renderError(c, err)
+        return
+    }
+    c.FileAttachment(path, name)
+}
+
+func (h *AdminHandler) renderError(c *gin.Context, err error) {
+    switch {
+    case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, database.ErrBackupNotFound):
+        h.render(c, http.StatusNotFound, "Not found")
+    case errors.Is(err, services.ErrInvalidRole), errors.Is(err, services.ErrSelfAction), errors.Is(err, database.ErrBackupUnsupported):
+        h.render(c, http.StatusBadRequest, err.Error())
+    default:
+        h.render(c, http.StatusInternalServerError, err.Error())
+    }
+}
+
+// render shows the admin panel with an optional error message.
+func (h *AdminHandler) render(c *gin.Context, status int, message string) {
+    dashboard, err := h.service.Dashboard(c.Request.Context())
+    if err != nil {
+        renderHTML(c, http.StatusInternalServerError, "home.html", gin.H{
+            "Error": err.Error(),
+        })
+        return
+    }
+    current := middleware.CurrentUser(c)
+    data := gin.H{
+        "Error": message,
+        "Users": dashboard.Users,
+        "Snippets": dashboard.Snippets,
+        "Roles": repositories.Roles,
+        "CurrentUserID": current.ID,
+        "IsAdmin": current.HasRole(repositories.RoleAdmin),
+    }
+    if current.HasRole(repositories.RoleAdmin) {
+        backups, err := h.service.Backups(c.Request.Context())
+        switch {
+        case errors.Is(err, database.ErrBackupUnsupported):
+        case err != nil:
+            data["BackupError"] = err.Error()
+            data["BackupsEnabled"] = true
+        default:
+            data["Backups"] = backups
+            data["BackupsEnabled"] = true
+        }
+    }
+    renderHTML(c, status, "admin.html", data)
+}
+
+// AuditLog shows audit events, filtered by the actor, from and to query params.
+func (h *AdminHandler) AuditLog(c *gin.Context) {
+    data := gin.H{
+        "Actor": c.Query("actor"),
+        "From": c.Query("from"),
+        "To": c.Query("to"),
+    }
+    filter, err := auditFilter(c)
+    if err != nil {
+        data["Error"] = err.Error()
+        c.HTML(http.StatusBadRequest, "audit.html", data)
+        return
+    }
+    filter.Limit = 500
+
+    events, err := h.audit.Find(c.Request.Context(), filter)
+    if err != nil {
+        data["Error"] = err.Error()
+        renderHTML(c, http.StatusInternalServerError, "audit.html", data)
+        return
+    }
+    data["Events"] = events
+    data["ExportQuery"] = c.Request.URL.RawQuery
+    c.HTML(http.StatusOK, "audit.html", data)
+}
+
+// ExportAudit downloads every matching audit event as JSON.
+func (h *AdminHandler) ExportAudit(c *gin.Context) {
+    filter, err := auditFilter(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    events, err := h.audit.Find(c.Request.Context(), filter)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    filename := fmt.Sprintf("audit-%s.json", time.Now().UTC().Format("20060102-150405"))
+    c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+    c.JSON(http.StatusOK, events)
+}
+
+// auditFilter parses the actor, from and to query params. Dates may be given
+// as YYYY-MM-DD (to is inclusive) or RFC 3339 timestamps.
+func auditFilter(c *gin.Context) (repositories.AuditFilter, error) {
+    filter := repositories.AuditFilter{Actor: c.Query("actor")}
+    if from := c.Query("from"); from != "" {
+        t, _, err := parseAuditTime(from)
+        if err != nil {
+            return filter, fmt.Errorf("invalid from date %q", from)
+        }
+        filter.Since = t
+    }
+    if to := c.Query("to"); to != "" {
+        t, dateOnly, err := parseAuditTime(to)
+        if err != nil {
+            return filter, fmt.Errorf("invalid to date %q", to)
+        }
+        if dateOnly {
+            t = t.AddDate(0, 0, 1)
+        }
+        filter.Until = t
+    }
+    return filter, nil
+}
+
+func parseAuditTime(value string) (time.Time, bool, error) {
+    if t, err := time.Parse("2006-01-02", value); err == nil {
+        return t, true, nil
+    }
+    t, err := time.Parse(time.RFC3339, value)
+    return t, false, err
+}
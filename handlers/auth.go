@@ -1,23 +1,39 @@
 package handlers
 
 import (
-	"snipetty.com/main/database"
 	"snipetty.com/main/repositories"
+	"snipetty.com/main/services"
+	"context"
+	"errors"
 	"net/http"
-	"os"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
+	"snipetty.com/main/middleware"
+	"snipetty.com/main/tracing"
 )
 
+// sessionTTL is how long a session token is valid, whether it is kept in the
+// cookie or by an API client.
+const sessionTTL = 24 * time.Hour
+
 func Home(c *gin.Context) {
         c.HTML(http.StatusOK, "home.html", nil)
 }
 
-func CreateUser(c *gin.Context) {
+type UserHandler struct {
+	service UserService
+	auth    *middleware.Auth
+	// oidcProvider labels the SSO button on the login page; empty hides it
+	oidcProvider string
+}
+
+func NewUserHandler(service UserService, auth *middleware.Auth, oidcProvider string) *UserHandler {
+	return &UserHandler{service: service, auth: auth, oidcProvider: oidcProvider}
+}
+
+func (h *UserHandler) CreateUser(c *gin.Context) {
     if c.Request.Method == http.MethodGet {
         c.HTML(http.StatusOK, "register.html", nil)
         return
@@ -30,69 +46,83 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
-	var userFound repositories.User
-	database.GetDB().Where("username=?", authInput.Username).Find(&userFound)
+	if _, err := h.service.Register(auditContext(c), authInput); err != nil {
+        c.HTML(http.StatusOK, "register.html", gin.H{"Error": err.Error()})
+		return
+	}
+
+	renderLogin(c, h.oidcProvider, gin.H{"Success": "User created successfully"})
+}
+
+func (h *UserHandler) Login(c *gin.Context) {
+    if c.Request.Method == http.MethodGet {
+        renderLogin(c, h.oidcProvider, nil)
+        return
+    }
+	var authInput repositories.AuthInput
 
-	if userFound.ID != 0 {
-        c.HTML(http.StatusOK, "register.html", gin.H{"Error": "Username already used"})
+	if err := c.ShouldBind(&authInput); err != nil {
+        renderLogin(c, h.oidcProvider, gin.H{"Error": err.Error()})
 		return
 	}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(authInput.Password), bcrypt.DefaultCost)
+	user, err := h.service.Authenticate(auditContext(c), authInput)
+	if errors.Is(err, services.ErrAccountDisabled) {
+        renderLogin(c, h.oidcProvider, gin.H{"Error": err.Error()})
+		return
+	}
 	if err != nil {
-        c.HTML(http.StatusOK, "register.html", gin.H{"Error": "Failed to hash password"})
+		if !errors.Is(err, services.ErrInvalidCredentials) {
+			slog.ErrorContext(c.Request.Context(), "login failed", "error", err)
+		}
+        renderLogin(c, h.oidcProvider, gin.H{"Error": "Invalid username or password"})
 		return
 	}
 
-	user := repositories.User{
-		Username: authInput.Username,
-		Password: string(passwordHash),
+	if err := setSessionCookie(c, h.auth, user); err != nil {
+        renderLogin(c, h.oidcProvider, gin.H{"Error": "Error generating token"})
+		return
 	}
 
-	database.GetDB().Create(&user)
-
-	c.HTML(http.StatusOK, "login.html", gin.H{"Success": "User created successfully"})
+    c.Redirect(http.StatusSeeOther, "/")
 }
 
-func Login(c *gin.Context) {
-    if c.Request.Method == http.MethodGet {
-        c.HTML(http.StatusOK, "login.html", nil)
-        return
-    }
+// APIToken exchanges a JSON username and password for a session token, for
+// API clients outside a browser to send as "Authorization: Bearer <token>".
+func (h *UserHandler) APIToken(c *gin.Context) {
 	var authInput repositories.AuthInput
-
-	if err := c.ShouldBind(&authInput); err != nil {
-        c.HTML(http.StatusOK, "login.html", gin.H{"Error": err.Error()})
-		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+	if err := c.ShouldBindJSON(&authInput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var userFound repositories.User
-	database.GetDB().Where("username = ?", authInput.Username).First(&userFound)
-	log.Println("authInput.Username:", authInput.Username)
-	if userFound.ID == 0 {
-        c.HTML(http.StatusOK, "login.html", gin.H{"Error": "Invalid username or password"})
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid username or password"})
+	user, err := h.service.Authenticate(auditContext(c), authInput)
+	if errors.Is(err, services.ErrAccountDisabled) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		return
 	}
-
-	if err := bcrypt.CompareHashAndPassword([]byte(userFound.Password), []byte(authInput.Password)); err != nil {
-        c.HTML(http.StatusOK, "login.html", gin.H{"Error": "Invalid username or password"})
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid username or password"})
+	if err != nil {
+		if !errors.Is(err, services.ErrInvalidCredentials) {
+			slog.ErrorContext(c.Request.Context(), "login failed", "error", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
 
-	log.Println("userFound.id:", userFound.ID)
-	generateToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  userFound.ID,
-		"username": userFound.Username,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
-	})
+	expiresAt := time.Now().Add(sessionTTL)
+	token, err := h.auth.SignToken(user.ID, user.Username, sessionTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": token, "expires_at": expiresAt.UTC()})
+}
 
-	token, err := generateToken.SignedString([]byte(os.Getenv("SECRET")))
+// setSessionCookie signs a JWT for user and stores it in the Authorization cookie.
+func setSessionCookie(c *gin.Context, auth *middleware.Auth, user *repositories.User) error {
+	token, err := auth.SignToken(user.ID, user.Username, sessionTTL)
 	if err != nil {
-        c.HTML(http.StatusOK, "login.html", gin.H{"Error": "Error generating token"})
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Error generating token"})
+		return err
 	}
     // Set token in cookie
 	c.SetSameSite(http.SameSiteLaxMode)
@@ -105,11 +135,36 @@ func Login(c *gin.Context) {
         false,           // secure
         false,            // httpOnly
     )
+	return nil
+}
 
-    c.Redirect(http.StatusSeeOther, "/")
+// auditContext carries the client IP into service calls that write audit events.
+func auditContext(c *gin.Context) context.Context {
+	return services.WithClientIP(c.Request.Context(), c.ClientIP())
+}
+
+// renderHTML renders a page. Server errors also show the trace ID so users
+// can quote it when reporting the problem.
+func renderHTML(c *gin.Context, status int, name string, data gin.H) {
+	if status >= http.StatusInternalServerError {
+		if data == nil {
+			data = gin.H{}
+		}
+		data["TraceID"] = tracing.TraceID(c.Request.Context())
+	}
+	c.HTML(status, name, data)
+}
+
+// renderLogin renders login.html, adding the SSO button when oidcProvider is set.
+func renderLogin(c *gin.Context, oidcProvider string, data gin.H) {
+	if data == nil {
+		data = gin.H{}
+	}
+	data["OIDCProvider"] = oidcProvider
+	c.HTML(http.StatusOK, "login.html", data)
 }
 
-func Logout(c *gin.Context) {
+func (h *UserHandler) Logout(c *gin.Context) {
 	c.SetCookie(
 		"Authorization",
 		"", // value
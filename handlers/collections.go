@@ -0,0 +1,348 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+    "snipetty.com/main/middleware"
+    "snipetty.com/main/repositories"
+    "snipetty.com/main/services"
+)
+
+type CollectionHandler struct {
+    service CollectionService
+}
+
+func NewCollectionHandler(service CollectionService) *CollectionHandler {
+    return &CollectionHandler{service: service}
+}
+
+// List shows the current user's collections and the form for a new one.
+func (h *CollectionHandler) List(c *gin.Context) {
+    h.renderList(c, http.StatusOK, nil)
+}
+
+func (h *CollectionHandler) Create(c *gin.Context) {
+    var input repositories.CollectionInput
+    if err := c.ShouldBind(&input); err != nil {
+        h.renderList(c, http.StatusBadRequest, err)
+        return
+    }
+    collection, err := h.service.Create(auditContext(c), middleware.CurrentUser(c), input)
+    if err != nil {
+        h.renderList(c, errorStatus(err), err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, fmt.Sprintf("/collections/%d", collection.ID))
+}
+
+func (h *CollectionHandler) renderList(c *gin.Context, status int, err error) {
+    data := gin.H{}
+    if err != nil {
+        data["Error"] = err.Error()
+    }
+    collections, listErr := h.service.ListByUser(c.Request.Context(), middleware.CurrentUser(c).ID)
+    if listErr != nil {
+        data["Error"] = listErr.Error()
+        status = http.StatusInternalServerError
+    }
+    data["Collections"] = collections
+    renderHTML(c, status, "collections.html", data)
+}
+
+// View shows a collection. Private collections are only shown to their owner.
+func (h *CollectionHandler) View(c *gin.Context) {
+    id, ok := collectionID(c)
+    if !ok {
+        return
+    }
+    viewerID, _ := currentUserID(c)
+    collection, err := h.service.Get(c.Request.Context(), viewerID, id)
+    if err != nil {
+        renderError(c, err)
+        return
+    }
+    c.HTML(http.StatusOK, "collection.html", gin.H{
+        "Collection": collection,
+        "IsOwner": viewerID == collection.UserID,
+    })
+}
+
+func (h *CollectionHandler) Edit(c *gin.Context) {
+    id, ok := collectionID(c)
+    if !ok {
+        return
+    }
+    actor := middleware.CurrentUser(c)
+    if c.Request.Method == http.MethodGet {
+        collection, err := h.service.Get(c.Request.Context(), actor.ID, id)
+        if err == nil && collection.UserID != actor.ID {
+            err = services.ErrNotCollectionOwner
+        }
+        if err != nil {
+            renderError(c, err)
+            return
+        }
+        c.HTML(http.StatusOK, "collection_edit.html", gin.H{
+            "ID": collection.ID,
+            "Input": repositories.CollectionInput{Title: collection.Title, Description: collection.Description, Visibility: collection.Visibility},
+        })
+        return
+    }
+
+    var input repositories.CollectionInput
+    status := http.StatusBadRequest
+    err := c.ShouldBind(&input)
+    if err == nil {
+        err = h.service.Update(auditContext(c), actor, id, input)
+        status = errorStatus(err)
+    }
+    if err != nil {
+        renderHTML(c, status, "collection_edit.html", gin.H{
+            "ID": id,
+            "Input": input,
+            "Error": err.Error(),
+        })
+        return
+    }
+    c.Redirect(http.StatusSeeOther, fmt.Sprintf("/collections/%d", id))
+}
+
+func (h *CollectionHandler) Delete(c *gin.Context) {
+    id, ok := collectionID(c)
+    if !ok {
+        return
+    }
+    if err := h.service.Delete(auditContext(c), middleware.CurrentUser(c), id); err != nil {
+        renderError(c, err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, "/collections")
+}
+
+// AddSnippet adds the snippet in the :id param to the collection in the
+// collection_id form value, from the snippet's page.
+func (h *CollectionHandler) AddSnippet(c *gin.Context) {
+    snippetID := c.Param("id")
+    id, err := strconv.ParseUint(c.PostForm("collection_id"), 10, 64)
+    if err == nil {
+        err = h.service.AddSnippet(auditContext(c), middleware.CurrentUser(c), uint(id), snippetID)
+    } else {
+        err = gorm.ErrRecordNotFound
+    }
+    if err != nil {
+        renderError(c, err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, "/snippets/"+snippetID)
+}
+
+func (h *CollectionHandler) RemoveSnippet(c *gin.Context) {
+    id, ok := collectionID(c)
+    if !ok {
+        return
+    }
+    snippetID := c.Param("snippetID")
+    if err := h.service.RemoveSnippet(auditContext(c), middleware.CurrentUser(c), id, snippetID); err != nil {
+        renderError(c, err)
+        return
+    }
+    // The snippet page removes with from=snippet to come back to it
+    if c.PostForm("from") == "snippet" {
+        c.Redirect(http.StatusSeeOther, "/snippets/"+snippetID)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, fmt.Sprintf("/collections/%d", id))
+}
+
+// MoveSnippet moves a snippet one place up or down, per the direction form value.
+func (h *CollectionHandler) MoveSnippet(c *gin.Context) {
+    id, ok := collectionID(c)
+    if !ok {
+        return
+    }
+    offset := 1
+    if c.PostForm("direction") == "up" {
+        offset = -1
+    }
+    if err := h.service.MoveSnippet(auditContext(c), middleware.CurrentUser(c), id, c.Param("snippetID"), offset); err != nil {
+        renderError(c, err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, fmt.Sprintf("/collections/%d", id))
+}
+
+// The JSON API. Collections are returned as collectionJSON rather than the
+// model, which would expose the owner's account.
+
+type collectionJSON struct {
+    ID           uint                 `json:"id"`
+    Owner        string               `json:"owner"`
+    Title        string               `json:"title"`
+    Description  string               `json:"description"`
+    Visibility   string               `json:"visibility"`
+    SnippetCount int                  `json:"snippet_count"`
+    Snippets     []collectionItemJSON `json:"snippets,omitempty"` // Only for a single collection
+    CreatedAt    time.Time            `json:"created_at"`
+    UpdatedAt    time.Time            `json:"updated_at"`
+}
+
+type collectionItemJSON struct {
+    ID       string `json:"id"`
+    Title    string `json:"title"`
+    Language string `json:"language"`
+    Author   string `json:"author"`
+}
+
+func newCollectionJSON(collection *repositories.Collection, withSnippets bool) collectionJSON {
+    out := collectionJSON{
+        ID: collection.ID,
+        Owner: collection.User.Username,
+        Title: collection.Title,
+        Description: collection.Description,
+        Visibility: collection.Visibility,
+        SnippetCount: len(collection.Items),
+        CreatedAt: collection.CreatedAt,
+        UpdatedAt: collection.UpdatedAt,
+    }
+    if withSnippets {
+        out.Snippets = make([]collectionItemJSON, 0, len(collection.Items))
+        for _, item := range collection.Items {
+            out.Snippets = append(out.Snippets, collectionItemJSON{
+                ID: item.Snippet.ID,
+                Title: item.Snippet.Title,
+                Language: item.Snippet.Language,
+                Author: item.Snippet.User.Username,
+            })
+        }
+    }
+    return out
+}
+
+// APIList returns the current user's collections, without their snippets.
+func (h *CollectionHandler) APIList(c *gin.Context) {
+    collections, err := h.service.ListByUser(c.Request.Context(), middleware.CurrentUser(c).ID)
+    if err != nil {
+        renderJSONError(c, err)
+        return
+    }
+    out := make([]collectionJSON, 0, len(collections))
+    for i := range collections {
+        out = append(out, newCollectionJSON(&collections[i], false))
+    }
+    c.JSON(http.StatusOK, out)
+}
+
+// APIGet returns a collection with the snippets the caller may see.
+func (h *CollectionHandler) APIGet(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        renderJSONError(c, gorm.ErrRecordNotFound)
+        return
+    }
+    viewerID, _ := currentUserID(c)
+    collection, err := h.service.Get(c.Request.Context(), viewerID, uint(id))
+    if err != nil {
+        renderJSONError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, newCollectionJSON(collection, true))
+}
+
+// APICreate creates a collection from a JSON CollectionInput.
+func (h *CollectionHandler) APICreate(c *gin.Context) {
+    var input repositories.CollectionInput
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    actor := middleware.CurrentUser(c)
+    collection, err := h.service.Create(auditContext(c), actor, input)
+    if err != nil {
+        renderJSONError(c, err)
+        return
+    }
+    collection.User = *actor
+    c.JSON(http.StatusCreated, newCollectionJSON(collection, true))
+}
+
+// APIReorder sets the order of a collection's snippets and returns the
+// collection.
+func (h *CollectionHandler) APIReorder(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        renderJSONError(c, gorm.ErrRecordNotFound)
+        return
+    }
+    var input repositories.CollectionOrderInput
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    actor := middleware.CurrentUser(c)
+    if err := h.service.Reorder(auditContext(c), actor, uint(id), input.SnippetIDs); err != nil {
+        renderJSONError(c, err)
+        return
+    }
+    collection, err := h.service.Get(c.Request.Context(), actor.ID, uint(id))
+    if err != nil {
+        renderJSONError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, newCollectionJSON(collection, true))
+}
+
+// collectionID parses the :id param, answering 404 when it is not a number.
+func collectionID(c *gin.Context) (uint, bool) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.HTML(http.StatusNotFound, "home.html", gin.H{
+            "Error": "Collection not found",
+        })
+        return 0, false
+    }
+    return uint(id), true
+}
+
+func errorStatus(err error) int {
+    switch {
+    case errors.Is(err, gorm.ErrRecordNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, services.ErrNotCollectionOwner),
+        errors.Is(err, services.ErrNotOrgMember), errors.Is(err, services.ErrNotOrgAdmin), errors.Is(err, services.ErrNotOrgOwner):
+        return http.StatusForbidden
+    case errors.Is(err, services.ErrInvalidVisibility), errors.Is(err, repositories.ErrAlreadyInCollection), errors.Is(err, services.ErrInvalidOrder),
+        errors.Is(err, services.ErrInvalidSlug), errors.Is(err, services.ErrSlugTaken), errors.Is(err, services.ErrInvalidOrgRole),
+        errors.Is(err, services.ErrLastOwner), errors.Is(err, services.ErrUnknownMember), errors.Is(err, repositories.ErrAlreadyMember):
+        return http.StatusBadRequest
+    default:
+        return http.StatusInternalServerError
+    }
+}
+
+// renderJSONError answers an API request with err and the matching status.
+func renderJSONError(c *gin.Context, err error) {
+    status := errorStatus(err)
+    message := err.Error()
+    if status == http.StatusNotFound {
+        message = "Not found"
+    }
+    c.JSON(status, gin.H{"error": message})
+}
+
+// renderError shows err on the home page with the matching status.
+func renderError(c *gin.Context, err error) {
+    status := errorStatus(err)
+    message := err.Error()
+    if status == http.StatusNotFound {
+        message = "Not found"
+    }
+    renderHTML(c, status, "home.html", gin.H{
+        "Error": message,
+    })
+}
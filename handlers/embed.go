@@ -0,0 +1,134 @@
+package handlers
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+    "snipetty.com/main/repositories"
+)
+
+// embedThemes maps the theme query parameter to a highlight.js style.
+var embedThemes = map[string]string{
+    "light": "github",
+    "dark":  "github-dark",
+}
+
+// embedScript replaces its own script tag with an iframe of the snippet's
+// embed page, and resizes the iframe to the height the page reports.
+const embedScript = `(function () {
+  var script = document.currentScript;
+  var origin = new URL(script.src).origin;
+  var id = SNIPPET_ID;
+  var theme = script.getAttribute("data-theme") || "light";
+  var frame = document.createElement("iframe");
+  frame.src = origin + "/snippets/" + encodeURIComponent(id) + "/embed?theme=" + encodeURIComponent(theme);
+  frame.title = "Code snippet";
+  frame.loading = "lazy";
+  frame.style.cssText = "width:100%;height:200px;border:0";
+  window.addEventListener("message", function (event) {
+    if (event.origin === origin && event.source === frame.contentWindow &&
+        event.data && event.data.snippetEmbed === id) {
+      frame.style.height = event.data.height + "px";
+    }
+  });
+  script.parentNode.insertBefore(frame, script);
+})();
+`
+
+// EmbedHandler serves snippets for other sites to embed: a page meant for an
+// iframe and a script that inserts that iframe. Embeds are rendered as for a
+// guest, so only public snippets can be embedded.
+type EmbedHandler struct {
+    service        SnippetService
+    frameAncestors string
+}
+
+// NewEmbedHandler allows the embed page in frames of frameAncestors, or of
+// any site when it is empty.
+func NewEmbedHandler(service SnippetService, frameAncestors []string) *EmbedHandler {
+    ancestors := "*"
+    if len(frameAncestors) > 0 {
+        ancestors = strings.Join(frameAncestors, " ")
+    }
+    return &EmbedHandler{service: service, frameAncestors: ancestors}
+}
+
+// Page renders the snippet for an iframe.
+func (h *EmbedHandler) Page(c *gin.Context) {
+    // Replace the headers that deny framing
+    c.Header("X-Frame-Options", "")
+    c.Header("Content-Security-Policy", "frame-ancestors "+h.frameAncestors)
+
+    theme := c.DefaultQuery("theme", "light")
+    if _, ok := embedThemes[theme]; !ok {
+        theme = "light"
+    }
+    snippet, status, err := h.snippet(c)
+    if err != nil {
+        renderHTML(c, status, "embed.html", gin.H{
+            "Error": err.Error(),
+            "Theme": theme,
+            "Style": embedThemes[theme],
+        })
+        return
+    }
+    renderHTML(c, http.StatusOK, "embed.html", gin.H{
+        "ID": snippet.ID,
+        "Title": snippet.Title,
+        "Username": snippet.User.Username,
+        "Language": snippet.Language,
+        "Class": "language-" + strings.ToLower(snippet.Language),
+        "Code": snippet.Content,
+        "Theme": theme,
+        "Style": embedThemes[theme],
+    })
+}
+
+// Script serves the JavaScript that embeds the snippet where it is included.
+func (h *EmbedHandler) Script(c *gin.Context) {
+    c.Header("Access-Control-Allow-Origin", "*")
+    c.Header("Cross-Origin-Resource-Policy", "cross-origin")
+
+    snippet, status, err := h.snippet(c)
+    if err != nil {
+        c.Data(status, "text/javascript; charset=utf-8", []byte("console.error(\"Snippet could not be embedded\");\n"))
+        return
+    }
+    id, err := json.Marshal(snippet.ID)
+    if err != nil {
+        c.Status(http.StatusInternalServerError)
+        return
+    }
+    script := strings.Replace(embedScript, "SNIPPET_ID", string(id), 1)
+    c.Data(http.StatusOK, "text/javascript; charset=utf-8", []byte(script))
+}
+
+// snippet loads the snippet as a guest sees it; hidden and missing snippets
+// are both not found.
+func (h *EmbedHandler) snippet(c *gin.Context) (*repositories.Snippet, int, error) {
+    snippet, err := h.service.GetSnippetByID(c.Request.Context(), 0, c.Param("id"))
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, http.StatusNotFound, errors.New("Snippet not found")
+    }
+    if err != nil {
+        return nil, http.StatusInternalServerError, err
+    }
+    return snippet, http.StatusOK, nil
+}
+
+// siteURL is the scheme and host the request was made to, for links that
+// must be absolute. Behind a TLS-terminating proxy it believes
+// X-Forwarded-Proto, but only from a trusted proxy: gin takes the client IP
+// from X-Forwarded-For for those alone, so it then differs from the peer.
+func siteURL(c *gin.Context) string {
+    scheme := "http"
+    forwarded := c.ClientIP() != c.RemoteIP()
+    if c.Request.TLS != nil || (forwarded && c.GetHeader("X-Forwarded-Proto") == "https") {
+        scheme = "https"
+    }
+    return scheme + "://" + c.Request.Host
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+    "encoding/xml"
+    "errors"
+    "html"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+    "snipetty.com/main/repositories"
+)
+
+type atomFeed struct {
+    XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+    ID      string      `xml:"id"`
+    Title   string      `xml:"title"`
+    Updated string      `xml:"updated"`
+    Links   []atomLink  `xml:"link"`
+    Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+    Rel  string `xml:"rel,attr"`
+    Type string `xml:"type,attr,omitempty"`
+    Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+    ID        string      `xml:"id"`
+    Title     string      `xml:"title"`
+    Published string      `xml:"published"`
+    Updated   string      `xml:"updated"`
+    Author    atomAuthor  `xml:"author"`
+    Link      atomLink    `xml:"link"`
+    Category  *atomTerm   `xml:"category,omitempty"`
+    Summary   string      `xml:"summary,omitempty"`
+    Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+    Name string `xml:"name"`
+    URI  string `xml:"uri"`
+}
+
+type atomTerm struct {
+    Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+    Type string `xml:"type,attr"`
+    Body string `xml:",chardata"`
+}
+
+// FeedHandler serves Atom feeds of the newest public snippets, overall, of a
+// language or of a user.
+type FeedHandler struct {
+    service SnippetService
+}
+
+func NewFeedHandler(service SnippetService) *FeedHandler {
+    return &FeedHandler{service: service}
+}
+
+// Recent is the feed of everyone's snippets.
+func (h *FeedHandler) Recent(c *gin.Context) {
+    h.serve(c, repositories.SnippetFilter{}, "Recent snippets", "/snippets")
+}
+
+// Language is the feed of snippets in one of the listed languages, named in
+// any case.
+func (h *FeedHandler) Language(c *gin.Context) {
+    language, ok := canonicalLanguage(c.Param("language"))
+    if !ok {
+        c.String(http.StatusNotFound, "Unknown language")
+        return
+    }
+    h.serve(c, repositories.SnippetFilter{Language: language}, language+" snippets", "/snippets")
+}
+
+// User is the feed of one user's snippets.
+func (h *FeedHandler) User(c *gin.Context) {
+    username := c.Param("username")
+    h.serve(c, repositories.SnippetFilter{Username: username}, "Snippets by "+username, "/snippets/user/"+url.PathEscape(username))
+}
+
+func (h *FeedHandler) serve(c *gin.Context, filter repositories.SnippetFilter, title, page string) {
+    snippets, err := h.service.Feed(c.Request.Context(), filter)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        c.String(http.StatusNotFound, "User not found")
+        return
+    }
+    if err != nil {
+        slog.ErrorContext(c.Request.Context(), "snippet feed failed", "error", err)
+        c.String(http.StatusInternalServerError, "Feed unavailable")
+        return
+    }
+
+    site := siteURL(c)
+    self := site + c.Request.URL.Path
+    feed := atomFeed{
+        ID:    self,
+        Title: "Snippety: " + title,
+        Links: []atomLink{
+            {Rel: "self", Type: "application/atom+xml", Href: self},
+            {Rel: "alternate", Type: "text/html", Href: site + page},
+        },
+    }
+    // The feed changed when its newest entry did; an empty one reports the epoch
+    var updated time.Time
+    for _, snippet := range snippets {
+        feed.Entries = append(feed.Entries, feedEntry(site, snippet))
+        if snippet.UpdatedAt.After(updated) {
+            updated = snippet.UpdatedAt
+        }
+    }
+    feed.Updated = updated.UTC().Format(time.RFC3339)
+
+    body, err := xml.MarshalIndent(feed, "", "  ")
+    if err != nil {
+        slog.ErrorContext(c.Request.Context(), "snippet feed failed", "error", err)
+        c.String(http.StatusInternalServerError, "Feed unavailable")
+        return
+    }
+    c.Data(http.StatusOK, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+func feedEntry(site string, snippet repositories.Snippet) atomEntry {
+    link := site + "/snippets/" + url.PathEscape(snippet.ID)
+    entry := atomEntry{
+        ID:        link,
+        Title:     snippet.Title,
+        Published: snippet.CreatedAt.UTC().Format(time.RFC3339),
+        Updated:   snippet.UpdatedAt.UTC().Format(time.RFC3339),
+        Author: atomAuthor{
+            Name: snippet.User.Username,
+            URI:  site + "/snippets/user/" + url.PathEscape(snippet.User.Username),
+        },
+        Link:    atomLink{Rel: "alternate", Type: "text/html", Href: link},
+        Summary: snippet.Description,
+    }
+    if snippet.Language != "" {
+        entry.Category = &atomTerm{Term: snippet.Language}
+    }
+
+    var content strings.Builder
+    if snippet.Description != "" {
+        content.WriteString("<p>" + html.EscapeString(snippet.Description) + "</p>")
+    }
+    content.WriteString("<pre><code>" + html.EscapeString(snippet.Content) + "</code></pre>")
+    entry.Content = atomContent{Type: "html", Body: content.String()}
+    return entry
+}
+
+// canonicalLanguage returns the listed language that name matches, ignoring
+// case.
+func canonicalLanguage(name string) (string, bool) {
+    for _, language := range languages {
+        if strings.EqualFold(language, name) {
+            return language, true
+        }
+    }
+    return "", false
+}
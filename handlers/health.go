@@ -0,0 +1,64 @@
+package handlers
+
+import (
+    "context"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "snipetty.com/main/buildinfo"
+)
+
+// checkTimeout bounds each readiness check so a hung database cannot stall
+// the probe.
+const checkTimeout = 2 * time.Second
+
+// HealthCheck is one component that must be healthy for the instance to
+// receive traffic.
+type HealthCheck struct {
+    Name  string
+    Check func(ctx context.Context) error
+}
+
+type componentStatus struct {
+    Status string `json:"status"`
+    Error  string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+    Status string `json:"status"`
+    buildinfo.Info
+    Checks map[string]componentStatus `json:"checks,omitempty"`
+}
+
+type HealthHandler struct {
+    checks []HealthCheck
+}
+
+func NewHealthHandler(checks ...HealthCheck) *HealthHandler {
+    return &HealthHandler{checks: checks}
+}
+
+// Live reports that the process is up and serving requests.
+func (h *HealthHandler) Live(c *gin.Context) {
+    c.JSON(http.StatusOK, healthResponse{Status: "ok", Info: buildinfo.Get()})
+}
+
+// Ready runs every check and answers 503 unless all of them pass.
+func (h *HealthHandler) Ready(c *gin.Context) {
+    res := healthResponse{Status: "ok", Info: buildinfo.Get(), Checks: map[string]componentStatus{}}
+    code := http.StatusOK
+    for _, check := range h.checks {
+        ctx, cancel := context.WithTimeout(c.Request.Context(), checkTimeout)
+        err := check.Check(ctx)
+        cancel()
+        if err != nil {
+            res.Checks[check.Name] = componentStatus{Status: "error", Error: err.Error()}
+            res.Status = "unavailable"
+            code = http.StatusServiceUnavailable
+            continue
+        }
+        res.Checks[check.Name] = componentStatus{Status: "ok"}
+    }
+    c.JSON(code, res)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+    "context"
+    "io"
+
+    "snipetty.com/main/database"
+    "snipetty.com/main/repositories"
+    "snipetty.com/main/services"
+)
+
+// Handlers depend on these interfaces so routes can be tested with fakes.
+
+type SnippetService interface {
+    CreateSnippet(ctx context.Context, actor *repositories.User, input *repositories.CreateSnippetRequest) (string, error)
+    GetSnippetByID(ctx context.Context, viewerID uint, id string) (*repositories.Snippet, error)
+    Access(ctx context.Context, viewerID uint, snippet *repositories.Snippet) (services.SnippetAccess, error)
+    UpdateSnippet(ctx context.Context, actor *repositories.User, id string, input repositories.CreateSnippetRequest) error
+    Revisions(ctx context.Context, viewerID uint, id string) ([]repositories.SnippetRevision, error)
+    GetSnippetsByLanguage(ctx context.Context, languages []string) ([]services.LanguageSnippets, error)
+    GetSnippetsByUsername(ctx context.Context, username string) ([]repositories.Snippet, error)
+    Feed(ctx context.Context, filter repositories.SnippetFilter) ([]repositories.Snippet, error)
+    DeleteSnippet(ctx context.Context, actor *repositories.User, id string) error
+    ExportSnippets(ctx context.Context, actor *repositories.User, w io.Writer) error
+    ImportSnippets(ctx context.Context, actor *repositories.User, r io.ReaderAt, size int64) (*services.ImportReport, error)
+    Collaborators(ctx context.Context, actor *repositories.User, id string) ([]repositories.SnippetCollaborator, error)
+    SetCollaborator(ctx context.Context, actor *repositories.User, id string, input repositories.CollaboratorInput) error
+    RemoveCollaborator(ctx context.Context, actor *repositories.User, id string, userID uint) error
+    HasPreviews() bool
+    PreviewImage(ctx context.Context, id string) (string, error)
+}
+
+type CollectionService interface {
+    Create(ctx context.Context, actor *repositories.User, input repositories.CollectionInput) (*repositories.Collection, error)
+    Get(ctx context.Context, viewerID uint, id uint) (*repositories.Collection, error)
+    ListByUser(ctx context.Context, userID uint) ([]repositories.Collection, error)
+    Update(ctx context.Context, actor *repositories.User, id uint, input repositories.CollectionInput) error
+    Delete(ctx context.Context, actor *repositories.User, id uint) error
+    AddSnippet(ctx context.Context, actor *repositories.User, id uint, snippetID string) error
+    RemoveSnippet(ctx context.Context, actor *repositories.User, id uint, snippetID string) error
+    MoveSnippet(ctx context.Context, actor *repositories.User, id uint, snippetID string, offset int) error
+    Reorder(ctx context.Context, actor *repositories.User, id uint, snippetIDs []string) error
+}
+
+type OrganizationService interface {
+    Create(ctx context.Context, actor *repositories.User, input repositories.OrganizationInput) (*repositories.Organization, error)
+    List(ctx context.Context) ([]repositories.Organization, error)
+    ListByUser(ctx context.Context, userID uint) ([]repositories.Organization, error)
+    Get(ctx context.Context, viewerID uint, slug string) (*services.OrganizationPage, error)
+    AddMember(ctx context.Context, actor *repositories.User, slug string, input repositories.MemberInput) error
+    SetMemberRole(ctx context.Context, actor *repositories.User, slug string, userID uint, role string) error
+    RemoveMember(ctx context.Context, actor *repositories.User, slug string, userID uint) error
+}
+
+type UserService interface {
+    Register(ctx context.Context, input repositories.AuthInput) (*repositories.User, error)
+    Authenticate(ctx context.Context, input repositories.AuthInput) (*repositories.User, error)
+    GetUserByID(ctx context.Context, id uint) (*repositories.User, error)
+    GetProfile(ctx context.Context, username string) (*services.Profile, error)
+    UpdateProfile(ctx context.Context, id uint, input repositories.ProfileInput) (*repositories.User, error)
+    DeleteAccount(ctx context.Context, id uint, input repositories.DeleteAccountInput) error
+}
+
+type AdminService interface {
+    Dashboard(ctx context.Context) (*services.Dashboard, error)
+    SetRole(ctx context.Context, actor *repositories.User, userID uint, role repositories.Role) error
+    SetDisabled(ctx context.Context, actor *repositories.User, userID uint, disabled bool) error
+    RemoveSnippet(ctx context.Context, actor *repositories.User, snippetID string) error
+    Backups(ctx context.Context) ([]database.BackupFile, error)
+    CreateBackup(ctx context.Context, actor *repositories.User) (*database.BackupFile, error)
+    BackupPath(ctx context.Context, actor *repositories.User, name string) (string, error)
+}
+
+type AuditService interface {
+    Find(ctx context.Context, filter repositories.AuditFilter) ([]repositories.AuditEvent, error)
+}
+
+type OIDCService interface {
+    AuthCodeURL(state, nonce string) string
+    Authenticate(ctx context.Context, code, nonce string) (*repositories.User, error)
+    Link(ctx context.Context, userID uint, code, nonce string) (*repositories.User, error)
+}
+
+var (
+    _ SnippetService      = (*services.SnippetService)(nil)
+    _ CollectionService   = (*services.CollectionService)(nil)
+    _ OrganizationService = (*services.OrganizationService)(nil)
+    _ UserService         = (*services.UserService)(nil)
+    _ AdminService        = (*services.AdminService)(nil)
+    _ AuditService        = (*services.AuditService)(nil)
+    _ OIDCService         = (*services.OIDCService)(nil)
+)
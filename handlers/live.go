@@ -0,0 +1,190 @@
+package handlers
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "net/http"
+    "net/url"
+
+    "github.com/gin-gonic/gin"
+    "golang.org/x/net/websocket"
+    "snipetty.com/main/collab"
+    "snipetty.com/main/middleware"
+    "snipetty.com/main/repositories"
+)
+
+// maxLiveMessage bounds one WebSocket message; an operation may insert a
+// whole document of multi-byte characters.
+const maxLiveMessage = 4*collab.MaxDocumentLength + 64<<10
+
+var errLiveConflict = errors.New("The code was changed outside the live editor since this session started; copy your changes and reload once everyone has left")
+
+// LiveHandler lets everyone who may edit a snippet edit it together over a
+// WebSocket. The content is saved through the snippet service when an
+// editor asks for it and when the last editor leaves, which the hub makes
+// everyone do on shutdown.
+type LiveHandler struct {
+    service SnippetService
+    hub     *collab.Hub
+}
+
+func NewLiveHandler(service SnippetService, hub *collab.Hub) *LiveHandler {
+    return &LiveHandler{service: service, hub: hub}
+}
+
+// Page shows the live editor.
+func (h *LiveHandler) Page(c *gin.Context) {
+    snippet, ok := h.editable(c)
+    if !ok {
+        return
+    }
+    renderHTML(c, http.StatusOK, "live.html", gin.H{
+        "ID": snippet.ID,
+        "Title": snippet.Title,
+        "Username": middleware.CurrentUser(c).Username,
+    })
+}
+
+// Connect upgrades to a WebSocket and joins the snippet's session.
+func (h *LiveHandler) Connect(c *gin.Context) {
+    snippet, ok := h.editable(c)
+    if !ok {
+        return
+    }
+    actor := middleware.CurrentUser(c)
+    ctx := context.WithoutCancel(auditContext(c))
+
+    server := websocket.Server{
+        Handshake: sameOrigin,
+        Handler: func(conn *websocket.Conn) {
+            conn.MaxPayloadBytes = maxLiveMessage
+            h.edit(ctx, conn, actor, snippet.ID, collab.Base{Content: snippet.Content, UpdatedAt: snippet.UpdatedAt})
+        },
+    }
+    server.ServeHTTP(c.Writer, c.Request)
+}
+
+// edit relays messages between one editor and the session until either
+// side closes the connection.
+func (h *LiveHandler) edit(ctx context.Context, conn *websocket.Conn, actor *repositories.User, id string, base collab.Base) {
+    session, client, err := h.hub.Join(id, base, actor.Username)
+    if err != nil {
+        websocket.JSON.Send(conn, collab.Message{Type: collab.TypeError, Error: err.Error()})
+        return
+    }
+    store := func(doc string, base collab.Base) (collab.Base, error) {
+        return h.save(ctx, actor, id, doc, base)
+    }
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for m := range client.Send {
+            if err := websocket.JSON.Send(conn, m); err != nil {
+                break
+            }
+        }
+        // Unblock the reader if the session dropped this editor
+        conn.Close()
+    }()
+    // Deferred so that leaving and the final save also happen on a panic
+    defer func() {
+        if err := h.hub.Leave(id, session, client, store); err != nil {
+            slog.ErrorContext(ctx, "save live snippet", "snippet", id, "error", err)
+        }
+        <-done
+    }()
+
+    for {
+        var m collab.Message
+        if err := websocket.JSON.Receive(conn, &m); err != nil {
+            break
+        }
+        switch m.Type {
+        case collab.TypeOp:
+            if err := session.Submit(client, m.Rev, m.Op); err != nil {
+                session.Reject(client, err)
+            }
+        case collab.TypeSave:
+            rev, err := session.Save(store)
+            if err != nil {
+                session.Reject(client, err)
+                continue
+            }
+            session.Saved(rev, actor.Username)
+        default:
+            session.Reject(client, errors.New("Unknown message type"))
+        }
+    }
+}
+
+// save stores content as the snippet's code, keeping its other fields, and
+// returns the new base. It refuses when the code stored now is neither the
+// session's base nor content, so a save from the edit form is not
+// overwritten; changes to the other fields are kept.
+func (h *LiveHandler) save(ctx context.Context, actor *repositories.User, id, content string, base collab.Base) (collab.Base, error) {
+    snippet, err := h.service.GetSnippetByID(ctx, actor.ID, id)
+    if err != nil {
+        return base, err
+    }
+    if !snippet.UpdatedAt.Equal(base.UpdatedAt) && snippet.Content != base.Content && snippet.Content != content {
+        return base, errLiveConflict
+    }
+    err = h.service.UpdateSnippet(ctx, actor, id, repositories.CreateSnippetRequest{
+        Title: snippet.Title,
+        Content: content,
+        Description: snippet.Description,
+        Language: snippet.Language,
+        Visibility: snippet.Visibility,
+    })
+    if err != nil {
+        return base, err
+    }
+    if snippet, err = h.service.GetSnippetByID(ctx, actor.ID, id); err != nil {
+        return base, err
+    }
+    return collab.Base{Content: snippet.Content, UpdatedAt: snippet.UpdatedAt}, nil
+}
+
+// editable returns the snippet if the current user may edit it, and
+// otherwise renders the error.
+func (h *LiveHandler) editable(c *gin.Context) (*repositories.Snippet, bool) {
+    viewerID, ok := currentUserID(c)
+    if !ok {
+        c.Redirect(http.StatusSeeOther, "/login")
+        return nil, false
+    }
+    snippet, err := h.service.GetSnippetByID(c.Request.Context(), viewerID, c.Param("id"))
+    if err != nil {
+        renderHTML(c, http.StatusInternalServerError, "home.html", gin.H{
+            "Error": err.Error(),
+        })
+        return nil, false
+    }
+    access, err := h.service.Access(c.Request.Context(), viewerID, snippet)
+    if err != nil {
+        renderHTML(c, http.StatusInternalServerError, "home.html", gin.H{
+            "Error": err.Error(),
+        })
+        return nil, false
+    }
+    if !access.Edit {
+        c.HTML(http.StatusForbidden, "home.html", gin.H{
+            "Error": "Not authorized to edit this snippet",
+        })
+        return nil, false
+    }
+    return snippet, true
+}
+
+// sameOrigin accepts WebSocket handshakes only from pages of this site, as
+// browsers send the session cookie from any page that opens the socket.
+func sameOrigin(config *websocket.Config, req *http.Request) error {
+    origin, err := url.ParseRequestURI(req.Header.Get("Origin"))
+    if err != nil || origin.Host != req.Host {
+        return errors.New("cross-origin WebSocket")
+    }
+    config.Origin = origin
+    return nil
+}
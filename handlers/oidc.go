@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"snipetty.com/main/middleware"
+	"snipetty.com/main/services"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcNonceCookie = "oidc_nonce"
+)
+
+type OIDCHandler struct {
+	service      OIDCService
+	auth         *middleware.Auth
+	providerName string
+}
+
+func NewOIDCHandler(service OIDCService, auth *middleware.Auth, providerName string) *OIDCHandler {
+	return &OIDCHandler{service: service, auth: auth, providerName: providerName}
+}
+
+// Login redirects the browser to the identity provider. A signed in user
+// comes back with the provider account linked to theirs.
+func (h *OIDCHandler) Login(c *gin.Context) {
+	state, err := randomToken()
+	if err != nil {
+		renderLogin(c, h.providerName, gin.H{"Error": "Failed to start login"})
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		renderLogin(c, h.providerName, gin.H{"Error": "Failed to start login"})
+		return
+	}
+
+	// Short-lived cookies tie the callback to this browser
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, 600, "/auth/oidc", "", false, true)
+	c.SetCookie(oidcNonceCookie, nonce, 600, "/auth/oidc", "", false, true)
+
+	c.Redirect(http.StatusFound, h.service.AuthCodeURL(state, nonce))
+}
+
+// Callback handles the redirect back from the identity provider.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	state, err := c.Cookie(oidcStateCookie)
+	if err != nil || state == "" || c.Query("state") != state {
+		renderLogin(c, h.providerName, gin.H{"Error": "Invalid login state, please try again"})
+		return
+	}
+	nonce, err := c.Cookie(oidcNonceCookie)
+	if err != nil {
+		renderLogin(c, h.providerName, gin.H{"Error": "Invalid login state, please try again"})
+		return
+	}
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", false, true)
+	c.SetCookie(oidcNonceCookie, "", -1, "/auth/oidc", "", false, true)
+
+	if errParam := c.Query("error"); errParam != "" {
+		renderLogin(c, h.providerName, gin.H{"Error": "Login was cancelled: " + errParam})
+		return
+	}
+
+	if id, ok := currentUserID(c); ok {
+		h.link(c, id, nonce)
+		return
+	}
+
+	user, err := h.service.Authenticate(auditContext(c), c.Query("code"), nonce)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "oidc login failed", "error", err)
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			renderLogin(c, h.providerName, gin.H{"Error": "Your account has no verified email address"})
+			return
+		}
+		if errors.Is(err, services.ErrAccountDisabled) {
+			renderLogin(c, h.providerName, gin.H{"Error": err.Error()})
+			return
+		}
+		renderLogin(c, h.providerName, gin.H{"Error": "Login with " + h.providerName + " failed"})
+		return
+	}
+
+	if err := setSessionCookie(c, h.auth, user); err != nil {
+		renderLogin(c, h.providerName, gin.H{"Error": "Error generating token"})
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+// link attaches the provider account to the signed in user.
+func (h *OIDCHandler) link(c *gin.Context, userID uint, nonce string) {
+	user, err := h.service.Link(auditContext(c), userID, c.Query("code"), nonce)
+	if errors.Is(err, services.ErrOIDCAccountTaken) {
+		c.HTML(http.StatusConflict, "home.html", gin.H{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "oidc link failed", "error", err)
+		renderHTML(c, http.StatusInternalServerError, "home.html", gin.H{
+			"Error": "Linking your " + h.providerName + " account failed",
+		})
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/users/"+user.Username)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
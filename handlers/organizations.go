@@ -0,0 +1,148 @@
+package handlers
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "snipetty.com/main/middleware"
+    "snipetty.com/main/repositories"
+)
+
+type OrganizationHandler struct {
+    service OrganizationService
+}
+
+func NewOrganizationHandler(service OrganizationService) *OrganizationHandler {
+    return &OrganizationHandler{service: service}
+}
+
+// List shows every organization, the viewer's own first, and the form for a
+// new one when logged in.
+func (h *OrganizationHandler) List(c *gin.Context) {
+    h.renderList(c, http.StatusOK, nil)
+}
+
+func (h *OrganizationHandler) Create(c *gin.Context) {
+    var input repositories.OrganizationInput
+    if err := c.ShouldBind(&input); err != nil {
+        h.renderList(c, http.StatusBadRequest, err)
+        return
+    }
+    org, err := h.service.Create(auditContext(c), middleware.CurrentUser(c), input)
+    if err != nil {
+        h.renderList(c, errorStatus(err), err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, "/orgs/"+org.Slug)
+}
+
+func (h *OrganizationHandler) renderList(c *gin.Context, status int, err error) {
+    data := gin.H{}
+    if err != nil {
+        data["Error"] = err.Error()
+    }
+    all, listErr := h.service.List(c.Request.Context())
+    if listErr != nil {
+        data["Error"] = listErr.Error()
+        status = http.StatusInternalServerError
+    }
+    if viewerID, ok := currentUserID(c); ok {
+        mine, listErr := h.service.ListByUser(c.Request.Context(), viewerID)
+        if listErr != nil {
+            data["Error"] = listErr.Error()
+            status = http.StatusInternalServerError
+        }
+        data["LoggedIn"] = true
+        data["Mine"] = mine
+    }
+    data["Organizations"] = all
+    renderHTML(c, status, "orgs.html", data)
+}
+
+// View shows an organization's members and snippets. Members-only snippets
+// are listed for members alone.
+func (h *OrganizationHandler) View(c *gin.Context) {
+    h.renderOrg(c, http.StatusOK, nil)
+}
+
+func (h *OrganizationHandler) AddMember(c *gin.Context) {
+    var input repositories.MemberInput
+    if err := c.ShouldBind(&input); err != nil {
+        h.renderOrg(c, http.StatusBadRequest, err)
+        return
+    }
+    slug := c.Param("slug")
+    if err := h.service.AddMember(auditContext(c), middleware.CurrentUser(c), slug, input); err != nil {
+        h.renderOrg(c, errorStatus(err), err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, "/orgs/"+slug)
+}
+
+func (h *OrganizationHandler) SetMemberRole(c *gin.Context) {
+    userID, ok := memberID(c)
+    if !ok {
+        return
+    }
+    slug := c.Param("slug")
+    if err := h.service.SetMemberRole(auditContext(c), middleware.CurrentUser(c), slug, userID, c.PostForm("role")); err != nil {
+        h.renderOrg(c, errorStatus(err), err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, "/orgs/"+slug)
+}
+
+// RemoveMember removes a member, or lets the current user leave.
+func (h *OrganizationHandler) RemoveMember(c *gin.Context) {
+    userID, ok := memberID(c)
+    if !ok {
+        return
+    }
+    actor := middleware.CurrentUser(c)
+    slug := c.Param("slug")
+    if err := h.service.RemoveMember(auditContext(c), actor, slug, userID); err != nil {
+        h.renderOrg(c, errorStatus(err), err)
+        return
+    }
+    if userID == actor.ID {
+        c.Redirect(http.StatusSeeOther, "/orgs")
+        return
+    }
+    c.Redirect(http.StatusSeeOther, "/orgs/"+slug)
+}
+
+// renderOrg shows the organization page, with err if there is one.
+func (h *OrganizationHandler) renderOrg(c *gin.Context, status int, err error) {
+    viewerID, _ := currentUserID(c)
+    page, getErr := h.service.Get(c.Request.Context(), viewerID, c.Param("slug"))
+    if getErr != nil {
+        renderError(c, getErr)
+        return
+    }
+    data := gin.H{
+        "Organization": page.Organization,
+        "Snippets": page.Snippets,
+        "Role": page.Role,
+        "CanManage": page.Role == repositories.OrgRoleOwner || page.Role == repositories.OrgRoleAdmin,
+        "IsOwner": page.Role == repositories.OrgRoleOwner,
+        "CurrentUserID": viewerID,
+        "Roles": repositories.OrgRoles,
+    }
+    if err != nil {
+        data["Error"] = err.Error()
+    }
+    renderHTML(c, status, "org.html", data)
+}
+
+func memberID(c *gin.Context) (uint, bool) {
+    id, err := strconv.ParseUint(c.Param("userID"), 10, 64)
+    if err != nil {
+        c.HTML(http.StatusNotFound, "home.html", gin.H{
+            "Error": fmt.Sprintf("Member %q not found", c.Param("userID")),
+        })
+        return 0, false
+    }
+    return uint(id), true
+}
@@ -1,16 +1,25 @@
 package handlers
 
 import (
+    "errors"
     "fmt"
+    "log/slog"
     "net/http"
+    "net/url"
+    "strconv"
+    "time"
+
     "github.com/gin-gonic/gin"
-    "snipetty.com/main/services"
+    "gorm.io/gorm"
     "snipetty.com/main/middleware"
     "snipetty.com/main/repositories"
+    "snipetty.com/main/services"
 )
 
 type SnippetHandler struct {
-    service *services.SnippetService
+    service       SnippetService
+    collections   CollectionService
+    organizations OrganizationService
 }
 
 type LanguageSnippets struct {
@@ -18,52 +27,65 @@ type LanguageSnippets struct {
     Snippets []repositories.Snippet // The list of snippets for this language.
 }
 
-func NewSnippetHandler(service *services.SnippetService) *SnippetHandler {
-    return &SnippetHandler{service: service}
+func NewSnippetHandler(service SnippetService, collections CollectionService, organizations OrganizationService) *SnippetHandler {
+    return &SnippetHandler{service: service, collections: collections, organizations: organizations}
 }
 
 func (h *SnippetHandler) CreateSnippet(c *gin.Context) {
+    actor := middleware.CurrentUser(c)
+    if actor == nil {
+        c.HTML(http.StatusUnauthorized, "create.html", gin.H{
+            "Error": "Unauthorized",
+        })
+        return
+    }
+
     if c.Request.Method == http.MethodGet {
-        c.HTML(http.StatusOK, "create.html", nil)
+        h.renderCreate(c, http.StatusOK, actor, nil)
         return
     }
 
     var snippet repositories.CreateSnippetRequest
     if err := c.ShouldBind(&snippet); err != nil {
-        c.HTML(http.StatusBadRequest, "create.html", gin.H{
-            "Error": err.Error(),
-        })
+        h.renderCreate(c, http.StatusBadRequest, actor, err)
         return
     }
 
-    claims := middleware.JwtClaims(c)
-    if claims["id"] == nil {
-        c.HTML(http.StatusUnauthorized, "create.html", gin.H{
-            "Error": "Unauthorized",
-        })
-        return
-    }
-    idFloat, ok := claims["id"].(float64)
-    if !ok {
-        c.HTML(http.StatusUnauthorized, "create.html", gin.H{
-            "Error": "Unauthorized",
-        })
-        return
-    }
-    snippet.UID = fmt.Sprintf("%d", uint(idFloat))
-    snippetID, err := h.service.CreateSnippet(&snippet)
+    snippetID, err := h.service.CreateSnippet(auditContext(c), actor, &snippet)
     if err != nil {
-        c.HTML(http.StatusInternalServerError, "create.html", gin.H{
-            "Error": err.Error(),
-        })
+        status := http.StatusInternalServerError
+        switch {
+        case errors.Is(err, services.ErrNotOrgMember):
+            status = http.StatusForbidden
+        case errors.Is(err, services.ErrSnippetVisibility):
+            status = http.StatusBadRequest
+        }
+        h.renderCreate(c, status, actor, err)
         return
     }
     
     c.Redirect(http.StatusSeeOther, fmt.Sprintf("/snippets/%s", snippetID))
 }
 
+// renderCreate shows the snippet form with the organizations the snippet
+// may be created in.
+func (h *SnippetHandler) renderCreate(c *gin.Context, status int, actor *repositories.User, err error) {
+    data := gin.H{}
+    if err != nil {
+        data["Error"] = err.Error()
+    }
+    orgs, listErr := h.organizations.ListByUser(c.Request.Context(), actor.ID)
+    if listErr != nil {
+        data["Error"] = listErr.Error()
+        status = http.StatusInternalServerError
+    }
+    data["Organizations"] = orgs
+    renderHTML(c, status, "create.html", data)
+}
+
 func (h *SnippetHandler) GetSnippetsByUsername(c *gin.Context) {
     username := c.Param("username")
+    own := username == ""
 
     // If username empty, get username from jwt claims
     if username == "" {
@@ -84,32 +106,131 @@ func (h *SnippetHandler) GetSnippetsByUsername(c *gin.Context) {
         }
     }
 
-    snippets, err := h.service.GetSnippetsByUsername(username)
+    snippets, err := h.service.GetSnippetsByUsername(c.Request.Context(), username)
     if err != nil {
-        c.HTML(http.StatusInternalServerError, "mylist.html", gin.H{
+        renderHTML(c, http.StatusInternalServerError, "mylist.html", gin.H{
             "Error": err.Error(),
         })
         return
     }
     c.HTML(http.StatusOK, "mylist.html", gin.H{
         "snippets": snippets,
+        "Own": own,
+        "Feed": "/snippets/user/" + url.PathEscape(username) + "/feed",
     })
 }
 
-func (h *SnippetHandler) GetSnippetsByLanguage(c *gin.Context) {
-    languages := []string{"Python", "Javascript", "Go", "Rust", "Typescript"}
+// ExportSnippets streams a zip of the current user's snippets.
+func (h *SnippetHandler) ExportSnippets(c *gin.Context) {
+    actor := middleware.CurrentUser(c)
+    if actor == nil {
+        c.HTML(http.StatusUnauthorized, "mylist.html", gin.H{
+            "Error": "Unauthorized",
+        })
+        return
+    }
+
+    if err := h.export(c, actor); err != nil {
+        renderHTML(c, http.StatusInternalServerError, "mylist.html", gin.H{
+            "Error": err.Error(),
+        })
+    }
+}
+
+// APIExport is ExportSnippets for the JSON API.
+func (h *SnippetHandler) APIExport(c *gin.Context) {
+    if err := h.export(c, middleware.CurrentUser(c)); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    }
+}
 
+// export streams actor's snippets as a zip. It returns an error only while
+// nothing has been written, so the caller can still answer with an error.
+func (h *SnippetHandler) export(c *gin.Context, actor *repositories.User) error {
+    // Large archives take longer than the server's write timeout
+    if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+        return err
+    }
+    c.Header("Content-Type", "application/zip")
+    c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", actor.Username+"-snippets.zip"))
+    err := h.service.ExportSnippets(auditContext(c), actor, c.Writer)
+    if err == nil {
+        return nil
+    }
+    if c.Writer.Written() {
+        // Too late for an error page; the client gets a truncated archive
+        slog.ErrorContext(c.Request.Context(), "snippet export failed", "error", err)
+        return nil
+    }
+    c.Writer.Header().Del("Content-Type")
+    c.Writer.Header().Del("Content-Disposition")
+    return err
+}
+
+// ImportSnippets shows the import form and imports the uploaded archive.
+func (h *SnippetHandler) ImportSnippets(c *gin.Context) {
+    if c.Request.Method == http.MethodGet {
+        c.HTML(http.StatusOK, "import.html", nil)
+        return
+    }
+
+    actor := middleware.CurrentUser(c)
+    if actor == nil {
+        c.HTML(http.StatusUnauthorized, "import.html", gin.H{
+            "Error": "Unauthorized",
+        })
+        return
+    }
+    header, err := c.FormFile("archive")
+    if err != nil {
+        c.HTML(http.StatusBadRequest, "import.html", gin.H{
+            "Error": "Choose a file to import",
+        })
+        return
+    }
+    file, err := header.Open()
+    if err != nil {
+        renderHTML(c, http.StatusInternalServerError, "import.html", gin.H{
+            "Error": err.Error(),
+        })
+        return
+    }
+    defer file.Close()
+
+    report, err := h.service.ImportSnippets(auditContext(c), actor, file, header.Size)
+    switch {
+    case errors.Is(err, services.ErrUnsupportedImport), errors.Is(err, services.ErrInvalidImport), errors.Is(err, services.ErrImportRejected):
+        c.HTML(http.StatusBadRequest, "import.html", gin.H{
+            "Error": err.Error(),
+            "Report": report,
+        })
+    case err != nil:
+        renderHTML(c, http.StatusInternalServerError, "import.html", gin.H{
+            "Error": err.Error(),
+        })
+    default:
+        c.HTML(http.StatusOK, "import.html", gin.H{
+            "Report": report,
+        })
+    }
+}
+
+// languages are the languages snippets are listed and followed by.
+var languages = []string{"Python", "Javascript", "Go", "Rust", "Typescript"}
+
+func (h *SnippetHandler) GetSnippetsByLanguage(c *gin.Context) {
     // Call the service to get the snippets grouped by language
-    groupedSnippets, err := h.service.GetSnippetsByLanguage(languages)
+    groupedSnippets, err := h.service.GetSnippetsByLanguage(c.Request.Context(), languages)
     if err != nil {
         // Handle error by showing it on the page
-        c.HTML(http.StatusInternalServerError, "list.html", gin.H{"error": err.Error()})
+        renderHTML(c, http.StatusInternalServerError, "list.html", gin.H{"error": err.Error()})
         return
     }
 
     // Pass the grouped snippets to the template
     c.HTML(http.StatusOK, "list.html", gin.H{
         "groupedSnippets": groupedSnippets,
+        "Feed": "/snippets/feed",
     })
 }
 
@@ -121,21 +242,27 @@ func (h *SnippetHandler) GetSnippetByID(c *gin.Context) {
         })
         return
     }
-    snippet, err := h.service.GetSnippetByID(id)
+    viewerID, _ := currentUserID(c)
+    snippet, err := h.service.GetSnippetByID(c.Request.Context(), viewerID, id)
+    if err != nil {
+        renderLookupError(c, "home.html", err)
+        return
+    }
+    access, err := h.service.Access(c.Request.Context(), viewerID, snippet)
     if err != nil {
-        c.HTML(http.StatusInternalServerError, "home.html", gin.H{
+        renderHTML(c, http.StatusInternalServerError, "home.html", gin.H{
             "Error": err.Error(),
         })
         return
     }
-    var currentUserID uint
-    claims := middleware.JwtClaims(c)
-    if claims != nil {
-        if idFloat, ok := claims["id"].(float64); ok {
-            currentUserID = uint(idFloat)
-        }
+    revisions, err := h.service.Revisions(c.Request.Context(), viewerID, snippet.ID)
+    if err != nil {
+        renderHTML(c, http.StatusInternalServerError, "home.html", gin.H{
+            "Error": err.Error(),
+        })
+        return
     }
-    c.HTML(http.StatusOK, "viewsnippet.html", gin.H{
+    data := gin.H{
         "Title": snippet.Title,
         "Username": snippet.User.Username,
         "Language": snippet.Language,
@@ -143,47 +270,84 @@ func (h *SnippetHandler) GetSnippetByID(c *gin.Context) {
         "Code": snippet.Content,
         "CreatedAt": snippet.CreatedAt,
         "ID": snippet.ID,
-        "IsOwner": currentUserID == snippet.UserID,
-    })
-}
-
-func (h *SnippetHandler) UpdateSnippet(c *gin.Context) {
-    id := c.Param("id")
-
-    // Show edit form for GET requests
-    if c.Request.Method == http.MethodGet {
-        snippet, err := h.service.GetSnippetByID(id)
+        "Organization": snippet.Organization,
+        "Revisions": revisions,
+        "Visibility": snippet.Visibility,
+        "CanEdit": access.Edit,
+        "CanDelete": access.Delete,
+    }
+    if snippet.Visibility != repositories.VisibilityMembers {
+        data["EmbedURL"] = fmt.Sprintf("%s/snippets/%s/embed.js", siteURL(c), snippet.ID)
+        data["OpenGraph"] = h.openGraph(c, snippet)
+    }
+    if viewerID != 0 {
+        // The viewer's collections, split by whether they hold this snippet
+        collections, err := h.collections.ListByUser(c.Request.Context(), viewerID)
         if err != nil {
-            c.HTML(http.StatusInternalServerError, "edit.html", gin.H{
+            renderHTML(c, http.StatusInternalServerError, "home.html", gin.H{
                 "Error": err.Error(),
             })
             return
         }
-
-        // Check if user owns this snippet
-        claims := middleware.JwtClaims(c)
-        if claims == nil {
-            c.Redirect(http.StatusSeeOther, "/login")
-            return
-        }
-        
-        if idFloat, ok := claims["id"].(float64); ok {
-            currentUserID := uint(idFloat)
-            if currentUserID != snippet.UserID {
-                c.HTML(http.StatusForbidden, "edit.html", gin.H{
-                    "Error": "Not authorized to edit this snippet",
-                })
-                return
+        var in, notIn []repositories.Collection
+        for _, collection := range collections {
+            if collectionHas(collection, snippet.ID) {
+                in = append(in, collection)
+            } else {
+                notIn = append(notIn, collection)
             }
         }
+        data["LoggedIn"] = true
+        data["InCollections"] = in
+        data["OtherCollections"] = notIn
+    }
+    c.HTML(http.StatusOK, "viewsnippet.html", data)
+}
 
-        c.HTML(http.StatusOK, "edit.html", gin.H{
-            "ID": snippet.ID,
-            "Title": snippet.Title,
-            "Description": snippet.Description,
-            "Language": snippet.Language,
-            "Content": snippet.Content,
-        })
+// openGraph describes a public snippet for link previews; header.html
+// renders it as Open Graph and Twitter card tags.
+func (h *SnippetHandler) openGraph(c *gin.Context, snippet *repositories.Snippet) gin.H {
+    description := snippet.Description
+    if description == "" {
+        description = fmt.Sprintf("A %s snippet by %s", snippet.Language, snippet.User.Username)
+    }
+    if runes := []rune(description); len(runes) > 200 {
+        description = string(runes[:199]) + "…"
+    }
+    og := gin.H{
+        "Title": snippet.Title,
+        "Description": description,
+        "URL": fmt.Sprintf("%s/snippets/%s", siteURL(c), snippet.ID),
+    }
+    if h.service.HasPreviews() {
+        // The version makes chat apps fetch the image again after an edit
+        og["Image"] = fmt.Sprintf("%s/snippets/%s/preview.png?v=%d", siteURL(c), snippet.ID, snippet.UpdatedAt.Unix())
+    }
+    return og
+}
+
+// PreviewImage serves the PNG shown in link previews of a public snippet.
+func (h *SnippetHandler) PreviewImage(c *gin.Context) {
+    path, err := h.service.PreviewImage(c.Request.Context(), c.Param("id"))
+    switch {
+    case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, services.ErrPreviewsDisabled):
+        c.Status(http.StatusNotFound)
+        return
+    case err != nil:
+        slog.ErrorContext(c.Request.Context(), "snippet preview failed", "error", err)
+        c.Status(http.StatusInternalServerError)
+        return
+    }
+    c.Header("Cache-Control", "public, max-age=3600")
+    c.File(path)
+}
+
+func (h *SnippetHandler) UpdateSnippet(c *gin.Context) {
+    id := c.Param("id")
+
+    // Show edit form for GET requests
+    if c.Request.Method == http.MethodGet {
+        h.renderEdit(c, http.StatusOK, nil)
         return
     }
 
@@ -200,8 +364,17 @@ func (h *SnippetHandler) UpdateSnippet(c *gin.Context) {
         return
     }
 
-    if err := h.service.UpdateSnippet(id, updatedSnippet); err != nil {
-        c.HTML(http.StatusInternalServerError, "edit.html", gin.H{
+    if err := h.service.UpdateSnippet(auditContext(c), middleware.CurrentUser(c), id, updatedSnippet); err != nil {
+        status := http.StatusInternalServerError
+        switch {
+        case errors.Is(err, services.ErrNotSnippetOwner):
+            status = http.StatusForbidden
+        case errors.Is(err, gorm.ErrRecordNotFound):
+            status = http.StatusNotFound
+        case errors.Is(err, services.ErrSnippetVisibility):
+            status = http.StatusBadRequest
+        }
+        renderHTML(c, status, "edit.html", gin.H{
             "Error": err.Error(),
             "ID": id,
             "Title": updatedSnippet.Title,
@@ -215,47 +388,179 @@ func (h *SnippetHandler) UpdateSnippet(c *gin.Context) {
     c.Redirect(http.StatusSeeOther, fmt.Sprintf("/snippets/%s", id))
 }
 
+// renderEdit shows the edit form of the snippet, and its collaborators to
+// those who manage them.
+func (h *SnippetHandler) renderEdit(c *gin.Context, status int, err error) {
+    viewerID, ok := currentUserID(c)
+    if !ok {
+        c.Redirect(http.StatusSeeOther, "/login")
+        return
+    }
+    snippet, getErr := h.service.GetSnippetByID(c.Request.Context(), viewerID, c.Param("id"))
+    if getErr != nil {
+        renderLookupError(c, "edit.html", getErr)
+        return
+    }
+
+    // Check if user may edit this snippet
+    access, getErr := h.service.Access(c.Request.Context(), viewerID, snippet)
+    if getErr != nil {
+        renderHTML(c, http.StatusInternalServerError, "edit.html", gin.H{
+            "Error": getErr.Error(),
+        })
+        return
+    }
+    if !access.Edit {
+        c.HTML(http.StatusForbidden, "edit.html", gin.H{
+            "Error": "Not authorized to edit this snippet",
+        })
+        return
+    }
+
+    data := gin.H{
+        "ID": snippet.ID,
+        "Title": snippet.Title,
+        "Description": snippet.Description,
+        "Language": snippet.Language,
+        "Content": snippet.Content,
+        "InOrganization": snippet.OrganizationID != nil,
+        "Visibility": snippet.Visibility,
+    }
+    if access.Delete {
+        collaborators, getErr := h.service.Collaborators(c.Request.Context(), middleware.CurrentUser(c), snippet.ID)
+        if getErr != nil {
+            renderHTML(c, http.StatusInternalServerError, "edit.html", gin.H{
+                "Error": getErr.Error(),
+            })
+            return
+        }
+        data["CanShare"] = true
+        data["Collaborators"] = collaborators
+        data["CollaboratorRoles"] = repositories.CollaboratorRoles
+        if snippet.OrganizationID == nil {
+            data["CollaboratorRoles"] = []string{repositories.CollaboratorEditor}
+        }
+    }
+    if err != nil {
+        data["Error"] = err.Error()
+    }
+    renderHTML(c, status, "edit.html", data)
+}
+
+// SetCollaborator grants a user viewer or editor access to the snippet.
+func (h *SnippetHandler) SetCollaborator(c *gin.Context) {
+    var input repositories.CollaboratorInput
+    if err := c.ShouldBind(&input); err != nil {
+        h.renderEdit(c, http.StatusBadRequest, err)
+        return
+    }
+    id := c.Param("id")
+    if err := h.service.SetCollaborator(auditContext(c), middleware.CurrentUser(c), id, input); err != nil {
+        h.renderEdit(c, collaboratorStatus(err), err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, fmt.Sprintf("/snippets/%s/edit", id))
+}
+
+func (h *SnippetHandler) RemoveCollaborator(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.Param("userID"), 10, 64)
+    if err != nil {
+        h.renderEdit(c, http.StatusNotFound, errors.New("Collaborator not found"))
+        return
+    }
+    id := c.Param("id")
+    if err := h.service.RemoveCollaborator(auditContext(c), middleware.CurrentUser(c), id, uint(userID)); err != nil {
+        h.renderEdit(c, collaboratorStatus(err), err)
+        return
+    }
+    c.Redirect(http.StatusSeeOther, fmt.Sprintf("/snippets/%s/edit", id))
+}
+
+// renderLookupError renders tmpl for a snippet that could not be loaded.
+// Hidden and missing snippets are both not found.
+func renderLookupError(c *gin.Context, tmpl string, err error) {
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        c.HTML(http.StatusNotFound, tmpl, gin.H{
+            "Error": "Snippet not found",
+        })
+        return
+    }
+    renderHTML(c, http.StatusInternalServerError, tmpl, gin.H{
+        "Error": err.Error(),
+    })
+}
+
+func collaboratorStatus(err error) int {
+    switch {
+    case errors.Is(err, services.ErrNotSnippetOwner):
+        return http.StatusForbidden
+    case errors.Is(err, gorm.ErrRecordNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, services.ErrInvalidCollaboratorRole), errors.Is(err, services.ErrCollaboratorIsAuthor), errors.Is(err, services.ErrUnknownMember),
+        errors.Is(err, services.ErrViewerNeedsOrganization):
+        return http.StatusBadRequest
+    default:
+        return http.StatusInternalServerError
+    }
+}
+
 func (h *SnippetHandler) DeleteSnippet(c *gin.Context) {
     id := c.Param("id")
 
     // Show delete confirmation for GET requests
     if c.Request.Method == http.MethodGet {
-        snippet, err := h.service.GetSnippetByID(id)
+        viewerID, ok := currentUserID(c)
+        if !ok {
+            c.Redirect(http.StatusSeeOther, "/login")
+            return
+        }
+        snippet, err := h.service.GetSnippetByID(c.Request.Context(), viewerID, id)
         if err != nil {
-            c.HTML(http.StatusInternalServerError, "mylist.html", gin.H{
-                "Error": err.Error(),
-            })
+            renderLookupError(c, "mylist.html", err)
             return
         }
 
-        // Check if user owns this snippet
-        claims := middleware.JwtClaims(c)
-        if claims == nil {
-            c.Redirect(http.StatusSeeOther, "/login")
+        // Check if user may delete this snippet
+        access, err := h.service.Access(c.Request.Context(), viewerID, snippet)
+        if err != nil {
+            renderHTML(c, http.StatusInternalServerError, "mylist.html", gin.H{
+                "Error": err.Error(),
+            })
             return
         }
-
-        if idFloat, ok := claims["id"].(float64); ok {
-            currentUserID := uint(idFloat)
-            if currentUserID != snippet.UserID {
-                c.HTML(http.StatusForbidden, "mylist.html", gin.H{
-                    "Error": "Not authorized to delete this snippet",
-                })
-                return
-            }
+        if !access.Delete {
+            c.HTML(http.StatusForbidden, "mylist.html", gin.H{
+                "Error": "Not authorized to delete this snippet",
+            })
+            return
         }
 
-        c.Redirect(http.StatusOK, "/snippets/listed")
+        c.Redirect(http.StatusSeeOther, "/snippets/my")
         return
     }
 
     // Handle DELETE request
-    if err := h.service.DeleteSnippet(id); err != nil {
-        c.HTML(http.StatusInternalServerError, "mylist.html", gin.H{
+    if err := h.service.DeleteSnippet(auditContext(c), middleware.CurrentUser(c), id); err != nil {
+        status := http.StatusInternalServerError
+        if errors.Is(err, services.ErrNotSnippetOwner) {
+            status = http.StatusForbidden
+        } else if errors.Is(err, gorm.ErrRecordNotFound) {
+            status = http.StatusNotFound
+        }
+        renderHTML(c, status, "mylist.html", gin.H{
             "Error": err.Error(),
         })
         return
     }
 
-    c.Redirect(http.StatusSeeOther, "/snippets/listed")
-}
\ No newline at end of file
+    c.Redirect(http.StatusSeeOther, "/snippets/my")
+}
+
+func collectionHas(collection repositories.Collection, snippetID string) bool {
+    for _, item := range collection.Items {
+        if item.SnippetID == snippetID {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+    "snipetty.com/main/middleware"
+    "snipetty.com/main/repositories"
+    "snipetty.com/main/services"
+)
+
+// currentUserID returns the id of the logged in user from the JWT claims.
+func currentUserID(c *gin.Context) (uint, bool) {
+    claims := middleware.JwtClaims(c)
+    if claims == nil {
+        return 0, false
+    }
+    idFloat, ok := claims["id"].(float64)
+    if !ok {
+        return 0, false
+    }
+    return uint(idFloat), true
+}
+
+// Profile shows a user's public page with their snippets and stats.
+func (h *UserHandler) Profile(c *gin.Context) {
+    profile, err := h.service.GetProfile(c.Request.Context(), c.Param("username"))
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        c.HTML(http.StatusNotFound, "home.html", gin.H{
+            "Error": "User not found",
+        })
+        return
+    }
+    if err != nil {
+        renderHTML(c, http.StatusInternalServerError, "home.html", gin.H{
+            "Error": err.Error(),
+        })
+        return
+    }
+
+    currentID, _ := currentUserID(c)
+    c.HTML(http.StatusOK, "profile.html", gin.H{
+        "User": profile.User,
+        "Snippets": profile.Snippets,
+        "Stats": profile.Stats,
+        "IsSelf": currentID == profile.User.ID,
+        "CanModerate": currentID == profile.User.ID && profile.User.HasRole(repositories.RoleModerator),
+        "Feed": "/snippets/user/" + url.PathEscape(profile.User.Username) + "/feed",
+    })
+}
+
+func (h *UserHandler) EditProfile(c *gin.Context) {
+    id, ok := currentUserID(c)
+    if !ok {
+        c.Redirect(http.StatusSeeOther, "/login")
+        return
+    }
+
+    if c.Request.Method == http.MethodGet {
+        user, err := h.service.GetUserByID(c.Request.Context(), id)
+        if err != nil {
+            renderHTML(c, http.StatusInternalServerError, "profile_edit.html", gin.H{
+                "Error": err.Error(),
+            })
+            return
+        }
+        c.HTML(http.StatusOK, "profile_edit.html", gin.H{
+            "DisplayName": user.DisplayName,
+            "Bio": user.Bio,
+            "AvatarURL": user.AvatarURL,
+            "OIDCProvider": h.oidcProvider,
+            "OIDCLinked": user.OIDCSubject != nil,
+        })
+        return
+    }
+
+    var input repositories.ProfileInput
+    if err := c.ShouldBind(&input); err != nil {
+        c.HTML(http.StatusBadRequest, "profile_edit.html", gin.H{
+            "Error": err.Error(),
+            "DisplayName": input.DisplayName,
+            "Bio": input.Bio,
+            "AvatarURL": input.AvatarURL,
+        })
+        return
+    }
+
+    user, err := h.service.UpdateProfile(c.Request.Context(), id, input)
+    if err != nil {
+        renderHTML(c, http.StatusInternalServerError, "profile_edit.html", gin.H{
+            "Error": err.Error(),
+            "DisplayName": input.DisplayName,
+            "Bio": input.Bio,
+            "AvatarURL": input.AvatarURL,
+        })
+        return
+    }
+
+    c.Redirect(http.StatusSeeOther, fmt.Sprintf("/users/%s", user.Username))
+}
+
+// DeleteAccount shows the confirmation form and deletes the account on POST.
+func (h *UserHandler) DeleteAccount(c *gin.Context) {
+    id, ok := currentUserID(c)
+    if !ok {
+        c.Redirect(http.StatusSeeOther, "/login")
+        return
+    }
+    user, err := h.service.GetUserByID(c.Request.Context(), id)
+    if err != nil {
+        renderHTML(c, http.StatusInternalServerError, "account_delete.html", gin.H{
+            "Error": err.Error(),
+        })
+        return
+    }
+    data := gin.H{
+        "Username": user.Username,
+        "HasPassword": user.Password != "",
+        "Mode": "delete",
+    }
+
+    if c.Request.Method == http.MethodGet {
+        c.HTML(http.StatusOK, "account_delete.html", data)
+        return
+    }
+
+    var input repositories.DeleteAccountInput
+    if err := c.ShouldBind(&input); err != nil {
+        data["Error"] = err.Error()
+        c.HTML(http.StatusBadRequest, "account_delete.html", data)
+        return
+    }
+    data["Mode"] = input.Mode
+    data["TransferTo"] = input.TransferTo
+
+    if err := h.service.DeleteAccount(auditContext(c), id, input); err != nil {
+        status := http.StatusInternalServerError
+        if errors.Is(err, services.ErrInvalidCredentials) || errors.Is(err, services.ErrInvalidTransfer) || errors.Is(err, services.ErrLastOwner) {
+            status = http.StatusBadRequest
+        }
+        data["Error"] = err.Error()
+        renderHTML(c, status, "account_delete.html", data)
+        return
+    }
+
+    h.Logout(c)
+}
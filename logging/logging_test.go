@@ -0,0 +1,77 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+	"snipetty.com/main/config"
+	"snipetty.com/main/logging"
+)
+
+func TestLoggerAddsRequestIDAndRedacts(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, config.Log{Level: slog.LevelInfo, Format: "json"})
+
+	ctx := logging.WithRequestID(context.Background(), "req-42")
+	logger.InfoContext(ctx, "login",
+		"username", "alice",
+		"password", "hunter2",
+		"Authorization", "Bearer abc",
+		"client_secret", "s3cret",
+		"database_dsn", "user:pw@tcp(db)/app",
+	)
+	logger.Debug("hidden")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected exactly one JSON record, got %q: %v", buf.String(), err)
+	}
+
+	want := map[string]any{
+		"msg":           "login",
+		"request_id":    "req-42",
+		"username":      "alice",
+		"password":      logging.Redacted,
+		"Authorization": logging.Redacted,
+		"client_secret": logging.Redacted,
+		"database_dsn":  logging.Redacted,
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("%s = %v, want %v", key, record[key], value)
+		}
+	}
+}
+
+func TestGormLoggerOmitsParameters(t *testing.T) {
+	l := logging.NewGormLogger(slog.Default())
+	sql, params := l.ParamsFilter(context.Background(), "INSERT INTO users (password) VALUES (?)", "$2a$10$hash")
+	if params != nil || sql != "INSERT INTO users (password) VALUES (?)" {
+		t.Errorf("ParamsFilter = %q, %v; want the SQL without parameters", sql, params)
+	}
+}
+
+func TestLoggerAddsTraceContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, config.Log{Level: slog.LevelInfo, Format: "json"})
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+	logger.InfoContext(ctx, "traced")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("invalid record %q: %v", buf.String(), err)
+	}
+	if record["trace_id"] != traceID.String() || record["span_id"] != spanID.String() {
+		t.Errorf("trace_id = %v, span_id = %v; want %s, %s", record["trace_id"], record["span_id"], traceID, spanID)
+	}
+}
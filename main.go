@@ -1,85 +1,82 @@
 package main
 
 import (
-	"gorm.io/gorm"
-    "log"
+	"context"
+	"errors"
+	"flag"
+    "fmt"
+    "log/slog"
+    "os"
 
-    "snipetty.com/main/services"
-    "snipetty.com/main/database"
-    "snipetty.com/main/middleware"
-    "snipetty.com/main/handlers"
-    "snipetty.com/main/repositories"
     "github.com/gin-gonic/gin"
+    "snipetty.com/main/app"
+    "snipetty.com/main/config"
+    "snipetty.com/main/database"
+    "snipetty.com/main/logging"
+    "snipetty.com/main/tracing"
 )
 
-var db *gorm.DB
-
-func init() {
-    database.LoadEnvs()
-    database.InitializeDatabaseLayer()
-    
-    // Check if tables exist first
-    if !database.TablesExist() {
-        log.Println("Tables do not exist. Running migrations...")
-        if err := database.Migrate(); err != nil {
-            log.Fatalf("Failed to migrate database: %v", err)
-        }
-        log.Println("Migrations completed successfully")
-    } else {
-        log.Println("Tables already exist. Skipping migrations")
+func main() {
+    cfg, args, err := config.Load(os.Args[1:])
+    if errors.Is(err, flag.ErrHelp) {
+        return
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+        os.Exit(1)
     }
-    db = database.GetDB()
-}
 
-func main() {
-    // Create repository
-    snippetRepo := repositories.NewSnippetRepository(db)
+    // Everything, including the standard library log package, goes through slog
+    slog.SetDefault(logging.New(os.Stderr, cfg.Log))
+    if cfg.Log.Level > slog.LevelDebug {
+        gin.SetMode(gin.ReleaseMode)
+    }
 
-    // Create service
-    snippetService := services.NewSnippetService(snippetRepo)
+    // `restore <backup>` swaps the database file, so it runs before opening it
+    if len(args) > 0 && args[0] == "restore" {
+        if err := runRestore(cfg.Database, args[1:]); err != nil {
+            fatal("restore failed", "error", err)
+        }
+        return
+    }
 
-    // Create handler
-    snippetHandler := handlers.NewSnippetHandler(snippetService)
+    if err := database.InitializeDatabaseLayer(cfg.Database); err != nil {
+        fatal("failed to open database", "driver", cfg.Database.Driver, "error", err)
+    }
+    db := database.GetDB()
 
-    // setup gin router
-    router := gin.Default()
-    router.Use(gin.Logger())
+    // `migrate up|down|status` manages the schema without starting the server
+    if len(args) > 0 && args[0] == "migrate" {
+        if err := runMigrate(db, args[1:]); err != nil {
+            fatal("migrate failed", "error", err)
+        }
+        return
+    }
 
-    // Load HTML templates
-    router.LoadHTMLGlob("templates/*")
+    // Apply pending migrations before serving
+    if err := database.Migrate(); err != nil {
+        fatal("failed to migrate database", "error", err)
+    }
 
-    // Auth routes
-    auth := router.Group("/")
-    {
-        auth.GET("", handlers.Home)
-        auth.GET("/login", handlers.Login)
-        auth.GET("/logout", handlers.Logout)
-        auth.GET("/register", handlers.CreateUser)
-        auth.POST("/login", handlers.Login)
-        auth.POST("/register", handlers.CreateUser)
+    shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+    if err != nil {
+        fatal("failed to set up tracing", "error", err)
     }
+    // Flush buffered spans once the server has stopped
+    defer shutdownTracing(context.Background())
 
-    // Snippet routes
-    snip := router.Group("/snippets")
-    {
-        // Guest routes
-        snip.GET("", snippetHandler.GetSnippetsByLanguage)
-        snip.GET("/user/:username", snippetHandler.GetSnippetsByUsername)
-        snip.GET("/:id", snippetHandler.GetSnippetByID)
-        
-        // Authenticated routes
-        snip.GET("/my", middleware.CheckAuth, snippetHandler.GetSnippetsByUsername)
-        snip.GET("/new", middleware.CheckAuth,snippetHandler.CreateSnippet)
-        snip.POST("/new", middleware.CheckAuth,snippetHandler.CreateSnippet)
-        snip.GET("/:id/edit",middleware.CheckAuth, snippetHandler.UpdateSnippet)
-        snip.POST("/:id/edit",middleware.CheckAuth, snippetHandler.UpdateSnippet)
-        snip.POST("/:id/delete", middleware.CheckAuth, snippetHandler.DeleteSnippet)
-        snip.GET("/:id/delete", middleware.CheckAuth, snippetHandler.DeleteSnippet)
+    application, err := app.New(cfg, db)
+    if err != nil {
+        fatal("failed to start", "error", err)
     }
 
     // start server
-    log.Println("starting server on :8080")
-    if err := router.Run(":8080"); err != nil {
-        log.Fatalf("failed to start server: %v", err)
+    if err := serve(application); err != nil {
+        fatal("server error", "error", err)
     }
+}
+
+func fatal(msg string, args ...any) {
+    slog.Error(msg, args...)
+    os.Exit(1)
 }
\ No newline at end of file
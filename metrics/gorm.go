@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"snipetty.com/main/database"
+)
+
+const startKey = "metrics:start"
+
+// gormPlugin times every statement through GORM callbacks.
+type gormPlugin struct {
+	m *Metrics
+}
+
+// GormPlugin returns a plugin that records statement durations; install it
+// with db.Use.
+func (m *Metrics) GormPlugin() gorm.Plugin {
+	return gormPlugin{m: m}
+}
+
+func (p gormPlugin) Name() string {
+	return "metrics"
+}
+
+func (p gormPlugin) Initialize(db *gorm.DB) error {
+	return database.RegisterHooks(db, "metrics", database.StatementHooks{
+		Before: func(string) func(*gorm.DB) { return start },
+		After:  p.observe,
+	})
+}
+
+func start(db *gorm.DB) {
+	db.InstanceSet(startKey, time.Now())
+}
+
+func (p gormPlugin) observe(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		p.m.queryDuration.WithLabelValues(operation, table).Observe(time.Since(value.(time.Time)).Seconds())
+	}
+}
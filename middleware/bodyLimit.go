@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaxBodySize caps the request body at limit bytes. Reading past the limit
+// fails, which gin's binding reports as a normal form error.
+func MaxBodySize(limit int64) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if c.Request.ContentLength > limit {
+            c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+            return
+        }
+        c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+        c.Next()
+    }
+}
@@ -2,33 +2,69 @@ package middleware
 
 import (
 	"net/http"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
 )
 
-func CheckAuth(c *gin.Context) {
-    if c.Request.URL.Path == "/login" || c.Request.URL.Path == "/register" {
-        c.Next()
-        return
-    }
+const claimsKey = "jwtClaims"
 
-    // Get token from cookie instead of header
-    token, err := c.Cookie("Authorization")
-    if err != nil {
-        c.Redirect(http.StatusSeeOther, "/login")
-        c.Abort()
+// Auth signs and verifies the JWT stored in the Authorization cookie.
+type Auth struct {
+    secret []byte
+}
+
+func NewAuth(secret string) *Auth {
+    return &Auth{secret: []byte(secret)}
+}
+
+// SignToken issues a token for the user that is valid for ttl.
+func (a *Auth) SignToken(id uint, username string, ttl time.Duration) (string, error) {
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+        "id":  id,
+        "username": username,
+        "exp": time.Now().Add(ttl).Unix(),
+    })
+    return token.SignedString(a.secret)
+}
+
+// Identify validates the session token, if any, and makes its claims
+// available through JwtClaims. API clients send the token in an
+// "Authorization: Bearer" header, browsers in the Authorization cookie. It
+// never aborts; use CheckAuth for that.
+func (a *Auth) Identify(c *gin.Context) {
+    token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+    if !ok {
+        token, _ = c.Cookie("Authorization")
+    }
+    if token == "" {
+        c.Next()
         return
     }
 
-    // Validate token
     claims := jwt.MapClaims{}
-    _, err = jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-        return []byte(os.Getenv("SECRET")), nil
+    _, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+            return nil, jwt.ErrSignatureInvalid
+        }
+        return a.secret, nil
     })
+    if err == nil {
+        c.Set(claimsKey, claims)
+    }
+    c.Next()
+}
+
+// CheckAuth redirects to the login page unless Identify found a valid token.
+func CheckAuth(c *gin.Context) {
+    if c.Request.URL.Path == "/login" || c.Request.URL.Path == "/register" {
+        c.Next()
+        return
+    }
 
-    if err != nil {
+    if JwtClaims(c) == nil {
         c.Redirect(http.StatusSeeOther, "/login")
         c.Abort()
         return
@@ -37,14 +73,10 @@ func CheckAuth(c *gin.Context) {
     c.Next()
 }
 
+// JwtClaims returns the claims of a valid session token, or nil.
 func JwtClaims(c *gin.Context) jwt.MapClaims {
-    token, err := c.Cookie("Authorization")
-    if err != nil {
-        return nil
+    if claims, ok := c.Get(claimsKey); ok {
+        return claims.(jwt.MapClaims)
     }
-    claims := jwt.MapClaims{}
-    jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-        return []byte(os.Getenv("SECRET")), nil
-    })
-    return claims
-}
\ No newline at end of file
+    return nil
+}
@@ -0,0 +1,103 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"snipetty.com/main/middleware"
+)
+
+const testSecret = "test-secret-that-is-long-enough-0123456789"
+
+func newAuthRouter(auth *middleware.Auth) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(auth.Identify)
+	protected := func(c *gin.Context) {
+		c.String(http.StatusOK, "hello %v", middleware.JwtClaims(c)["username"])
+	}
+	router.GET("/private", middleware.CheckAuth, protected)
+	router.GET("/login", middleware.CheckAuth, func(c *gin.Context) { c.String(http.StatusOK, "login") })
+	return router
+}
+
+func TestCheckAuth(t *testing.T) {
+	auth := middleware.NewAuth(testSecret)
+	valid, err := auth.SignToken(7, "alice", time.Hour)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	expired, _ := auth.SignToken(7, "alice", -time.Hour)
+	otherSecret, _ := middleware.NewAuth("another-secret-that-is-long-enough-0123").SignToken(7, "alice", time.Hour)
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"id": 7, "username": "alice", "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name     string
+		path     string
+		cookie   string
+		wantCode int
+		wantBody string
+	}{
+		{"valid token", "/private", valid, http.StatusOK, "hello alice"},
+		{"no cookie", "/private", "", http.StatusSeeOther, ""},
+		{"garbage", "/private", "not-a-jwt", http.StatusSeeOther, ""},
+		{"expired", "/private", expired, http.StatusSeeOther, ""},
+		{"wrong secret", "/private", otherSecret, http.StatusSeeOther, ""},
+		{"alg none", "/private", unsigned, http.StatusSeeOther, ""},
+		{"login is public", "/login", "", http.StatusOK, "login"},
+	}
+
+	router := newAuthRouter(auth)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "Authorization", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusSeeOther && w.Header().Get("Location") != "/login" {
+				t.Errorf("Location = %q, want /login", w.Header().Get("Location"))
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestIdentifyBearerToken(t *testing.T) {
+	auth := middleware.NewAuth(testSecret)
+	valid, _ := auth.SignToken(7, "alice", time.Hour)
+	router := newAuthRouter(auth)
+
+	for _, tt := range []struct {
+		name     string
+		header   string
+		wantCode int
+	}{
+		{"valid token", "Bearer " + valid, http.StatusOK},
+		{"invalid token", "Bearer not-a-jwt", http.StatusSeeOther},
+		{"other scheme", "Basic " + valid, http.StatusSeeOther},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/private", nil)
+			req.Header.Set("Authorization", tt.header)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
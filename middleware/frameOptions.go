@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// DenyFraming stops other sites from framing pages, against clickjacking.
+// Handlers meant to be framed, like snippet embeds, replace the headers.
+func DenyFraming() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        c.Header("X-Frame-Options", "DENY")
+        c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+        c.Next()
+    }
+}
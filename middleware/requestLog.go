@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"snipetty.com/main/logging"
+)
+
+// RequestIDHeader carries the request ID in requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+// Incoming IDs from a proxy are kept only if they are short and harmless.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID reuses the caller's X-Request-ID or generates one, echoes it in
+// the response and stores it in the request context for logging.
+func RequestID() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        id := c.GetHeader(RequestIDHeader)
+        if !validRequestID.MatchString(id) {
+            id = newRequestID()
+        }
+        c.Header(RequestIDHeader, id)
+        c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+        c.Next()
+    }
+}
+
+func newRequestID() string {
+    b := make([]byte, 8)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// RequestLogger logs one record per request after it completes. Use it after
+// RequestID. The query string is left out since it may carry tokens.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        start := time.Now()
+        c.Next()
+
+        status := c.Writer.Status()
+        level := slog.LevelInfo
+        switch {
+        case status >= 500:
+            level = slog.LevelError
+        case status >= 400:
+            level = slog.LevelWarn
+        }
+        attrs := []slog.Attr{
+            slog.String("method", c.Request.Method),
+            slog.String("path", c.Request.URL.Path),
+            slog.Int("status", status),
+            slog.Duration("duration", time.Since(start)),
+            slog.String("ip", c.ClientIP()),
+            slog.Int("bytes", c.Writer.Size()),
+        }
+        if len(c.Errors) > 0 {
+            attrs = append(attrs, slog.String("error", c.Errors.String()))
+        }
+        logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+    }
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"snipetty.com/main/repositories"
+)
+
+const currentUserKey = "currentUser"
+
+// UserLookup loads the account behind a session.
+type UserLookup interface {
+    GetUserByID(ctx context.Context, id uint) (*repositories.User, error)
+}
+
+// RequireRole loads the logged in user and aborts unless their account is
+// active and has at least the given role. Use it after CheckAuth; with
+// repositories.RoleUser it only rejects disabled accounts.
+func RequireRole(users UserLookup, role repositories.Role) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        claims := JwtClaims(c)
+        idFloat, ok := claims["id"].(float64)
+        if !ok {
+            c.Redirect(http.StatusSeeOther, "/login")
+            c.Abort()
+            return
+        }
+
+        user, err := users.GetUserByID(c.Request.Context(), uint(idFloat))
+        if err != nil || user.Disabled {
+            // Deleted or disabled accounts lose their session
+            c.SetCookie("Authorization", "", -1, "", "", false, false)
+            c.Redirect(http.StatusSeeOther, "/login")
+            c.Abort()
+            return
+        }
+
+        if !user.HasRole(role) {
+            c.HTML(http.StatusForbidden, "home.html", gin.H{
+                "Error": "You are not allowed to access this page",
+            })
+            c.Abort()
+            return
+        }
+
+        c.Set(currentUserKey, user)
+        c.Next()
+    }
+}
+
+// RequireAPIUser is RequireRole(users, repositories.RoleUser) for the JSON
+// API: it answers 401 with a JSON error instead of redirecting to the login
+// page. It does not need CheckAuth.
+func RequireAPIUser(users UserLookup) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        idFloat, ok := JwtClaims(c)["id"].(float64)
+        if !ok {
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+            return
+        }
+        user, err := users.GetUserByID(c.Request.Context(), uint(idFloat))
+        if err != nil || user.Disabled {
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+            return
+        }
+        c.Set(currentUserKey, user)
+        c.Next()
+    }
+}
+
+// CurrentUser returns the user loaded by RequireRole or RequireAPIUser, or nil.
+func CurrentUser(c *gin.Context) *repositories.User {
+    if user, ok := c.Get(currentUserKey); ok {
+        return user.(*repositories.User)
+    }
+    return nil
+}
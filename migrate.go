@@ -0,0 +1,54 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "text/tabwriter"
+
+    "gorm.io/gorm"
+    "snipetty.com/main/database"
+)
+
+const migrateUsage = "usage: migrate up|down|status"
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(db *gorm.DB, args []string) error {
+    if len(args) != 1 {
+        return errors.New(migrateUsage)
+    }
+
+    switch args[0] {
+    case "up":
+        if err := database.MigrateUp(db); err != nil {
+            return err
+        }
+        fmt.Println("Database is up to date")
+    case "down":
+        rolledBack, err := database.MigrateDown(db)
+        if err != nil {
+            return err
+        }
+        if !rolledBack {
+            fmt.Println("No migrations to roll back")
+        }
+    case "status":
+        statuses, err := database.Status(db)
+        if err != nil {
+            return err
+        }
+        w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+        for _, s := range statuses {
+            state := "pending"
+            if s.Applied {
+                state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+            }
+            fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, state)
+        }
+        return w.Flush()
+    default:
+        return errors.New(migrateUsage)
+    }
+    return nil
+}
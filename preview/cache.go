@@ -0,0 +1,88 @@
+package preview
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// Cache keeps rendered previews in a directory. A file is named after the
+// snippet and the time it was last updated, so a changed snippet never gets
+// an old image even if nobody invalidated it.
+type Cache struct {
+    dir string
+    mu  sync.Mutex // One render at a time
+}
+
+func NewCache(dir string) *Cache {
+    return &Cache{dir: dir}
+}
+
+// Image returns the file of the preview of snippet id as of version,
+// rendering card first if it is not cached. Older versions are removed.
+func (c *Cache) Image(id string, version time.Time, card Card) (string, error) {
+    path := filepath.Join(c.dir, fmt.Sprintf("%s-%d.png", key(id), version.UnixNano()))
+    if _, err := os.Stat(path); err == nil {
+        return path, nil
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if _, err := os.Stat(path); err == nil {
+        return path, nil
+    }
+    if err := os.MkdirAll(c.dir, 0o750); err != nil {
+        return "", err
+    }
+    if err := c.invalidate(id); err != nil {
+        return "", err
+    }
+    tmp, err := os.CreateTemp(c.dir, ".preview-*")
+    if err != nil {
+        return "", err
+    }
+    defer os.Remove(tmp.Name())
+    if err := Render(tmp, card); err != nil {
+        tmp.Close()
+        return "", err
+    }
+    if err := tmp.Close(); err != nil {
+        return "", err
+    }
+    if err := os.Rename(tmp.Name(), path); err != nil {
+        return "", err
+    }
+    return path, nil
+}
+
+// Invalidate removes every cached preview of snippet id.
+func (c *Cache) Invalidate(id string) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.invalidate(id)
+}
+
+func (c *Cache) invalidate(id string) error {
+    files, err := filepath.Glob(filepath.Join(c.dir, key(id)+"-*.png"))
+    if err != nil {
+        return err
+    }
+    for _, file := range files {
+        if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+            return err
+        }
+    }
+    return nil
+}
+
+// key names a snippet's files; IDs contain usernames, which are not safe
+// file names.
+func key(id string) string {
+    sum := sha256.Sum256([]byte(id))
+    return hex.EncodeToString(sum[:16])
+}
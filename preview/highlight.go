@@ -0,0 +1,145 @@
+package preview
+
+import (
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// kind classifies a token for colouring.
+type kind int
+
+const (
+    plain kind = iota
+    keyword
+    str
+    comment
+    number
+)
+
+type token struct {
+    text string
+    kind kind
+}
+
+// syntax is what the highlighter knows about a language.
+type syntax struct {
+    lineComment   string
+    blockComments bool   // C-style /* */
+    quotes        string // Characters that open a string
+    keywords      map[string]bool
+}
+
+func words(s string) map[string]bool {
+    m := map[string]bool{}
+    for _, w := range strings.Fields(s) {
+        m[w] = true
+    }
+    return m
+}
+
+var jsKeywords = "async await break case catch class const continue default delete do else export extends false finally for from function if import in instanceof let new null of return static super switch this throw true try typeof undefined var void while yield"
+
+// syntaxes are keyed by the lower-cased snippet language.
+var syntaxes = map[string]syntax{
+    "go": {"//", true, "\"'`", words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false iota")},
+    "python": {"#", false, "\"'", words("and as assert async await break class continue def del elif else except False finally for from global if import in is lambda None nonlocal not or pass raise return True try while with yield self")},
+    "javascript": {"//", true, "\"'`", words(jsKeywords)},
+    "typescript": {"//", true, "\"'`", words(jsKeywords + " abstract any boolean declare enum implements interface keyof namespace number private protected public readonly string type unknown")},
+    "rust": {"//", true, "\"", words("as async await break const continue crate else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while")},
+}
+
+// highlight splits each line into coloured tokens. It is a lexer, not a
+// parser: good enough for a thumbnail. Unknown languages are plain text.
+func highlight(language string, lines []string) [][]token {
+    lang, known := syntaxes[strings.ToLower(language)]
+    out := make([][]token, len(lines))
+    inBlock := false
+    for n, line := range lines {
+        if !known {
+            out[n] = []token{{line, plain}}
+            continue
+        }
+        var tokens []token
+        emit := func(text string, k kind) {
+            if last := len(tokens) - 1; last >= 0 && tokens[last].kind == k {
+                tokens[last].text += text
+                return
+            }
+            tokens = append(tokens, token{text, k})
+        }
+        for i := 0; i < len(line); {
+            rest := line[i:]
+            if inBlock {
+                end := strings.Index(rest, "*/")
+                if end < 0 {
+                    emit(rest, comment)
+                    break
+                }
+                emit(rest[:end+2], comment)
+                i += end + 2
+                inBlock = false
+                continue
+            }
+            r, size := utf8.DecodeRuneInString(rest)
+            switch {
+            case strings.HasPrefix(rest, lang.lineComment):
+                emit(rest, comment)
+                i = len(line)
+            case lang.blockComments && strings.HasPrefix(rest, "/*"):
+                emit("/*", comment)
+                i += 2
+                inBlock = true
+            case strings.ContainsRune(lang.quotes, r):
+                end := closingQuote(rest, r)
+                emit(rest[:end], str)
+                i += end
+            case unicode.IsDigit(r):
+                end := scan(rest, func(r rune) bool { return r == '.' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) })
+                emit(rest[:end], number)
+                i += end
+            case r == '_' || unicode.IsLetter(r):
+                end := scan(rest, func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) })
+                if lang.keywords[rest[:end]] {
+                    emit(rest[:end], keyword)
+                } else {
+                    emit(rest[:end], plain)
+                }
+                i += end
+            default:
+                emit(rest[:size], plain)
+                i += size
+            }
+        }
+        out[n] = tokens
+    }
+    return out
+}
+
+// closingQuote returns the length of the string literal at the start of s,
+// or of the rest of the line if it does not close.
+func closingQuote(s string, quote rune) int {
+    escaped := false
+    for i, r := range s {
+        switch {
+        case i == 0:
+        case escaped:
+            escaped = false
+        case r == '\\':
+            escaped = true
+        case r == quote:
+            return i + utf8.RuneLen(r)
+        }
+    }
+    return len(s)
+}
+
+// scan returns the length of the prefix of s whose runes satisfy ok.
+func scan(s string, ok func(rune) bool) int {
+    for i, r := range s {
+        if !ok(r) {
+            return i
+        }
+    }
+    return len(s)
+}
@@ -0,0 +1,88 @@
+package preview
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHighlight(t *testing.T) {
+	got := highlight("Go", []string{`func main() { // say "hi"`, `	s := "a \"b\"" + 42 /* note`, `still a comment */ x`})
+	want := [][]token{
+		{{"func", keyword}, {" main() { ", plain}, {`// say "hi"`, comment}},
+		{{"\ts := ", plain}, {`"a \"b\""`, str}, {" + ", plain}, {"42", number}, {" ", plain}, {"/* note", comment}},
+		{{"still a comment */", comment}, {" x", plain}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("highlight:\n got %v\nwant %v", got, want)
+	}
+	if got := highlight("Brainfuck", []string{"+[>+<-]"}); len(got[0]) != 1 || got[0][0].kind != plain {
+		t.Errorf("unknown language highlighted: %v", got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	var buf bytes.Buffer
+	card := Card{
+		Title:    strings.Repeat("A very long title ", 10),
+		Author:   "alice",
+		Language: "Python",
+		Code:     "def main():\n\tprint('héllo')  # greet\n" + strings.Repeat("x = 1\n", 50) + strings.Repeat("y", 1000),
+	}
+	if err := Render(&buf, card); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != Width || b.Dy() != Height {
+		t.Errorf("size %v, want %dx%d", b, Width, Height)
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewCache(dir)
+	card := Card{Title: "Hello", Author: "alice", Language: "Go", Code: "package main"}
+	v1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	first, err := cache.Image("alice-1", v1, card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := cache.Image("alice-1", v1, card); again != first {
+		t.Errorf("cached image moved from %s to %s", first, again)
+	}
+	if after, _ := os.Stat(first); !after.ModTime().Equal(info.ModTime()) {
+		t.Errorf("cached image rendered again")
+	}
+
+	// A newer version replaces the old file
+	second, err := cache.Image("alice-1", v1.Add(time.Second), card)
+	if err != nil || second == first {
+		t.Fatalf("new version: %s, %v", second, err)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("old version kept: %v", err)
+	}
+	other, _ := cache.Image("bob-1", v1, card)
+
+	if err := cache.Invalidate("alice-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(second); !os.IsNotExist(err) {
+		t.Errorf("invalidated image kept: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("another snippet's image removed: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Package preview draws the images shown when a link to a snippet is shared
+// in chat or on social sites, and caches them on disk.
+package preview
+
+import (
+    "image"
+    "image/color"
+    "image/draw"
+    "image/png"
+    "io"
+    "strings"
+    "sync"
+    "unicode/utf8"
+
+    "golang.org/x/image/font"
+    "golang.org/x/image/font/gofont/gobold"
+    "golang.org/x/image/font/gofont/gomono"
+    "golang.org/x/image/font/gofont/goregular"
+    "golang.org/x/image/font/opentype"
+    "golang.org/x/image/math/fixed"
+)
+
+// The image size recommended for Open Graph and Twitter large cards.
+const (
+    Width  = 1200
+    Height = 630
+)
+
+const (
+    margin    = 60
+    maxLines  = 12  // Lines of code shown
+    maxChars  = 200 // Bytes of a line measured; more never fit
+    tabWidth  = 4
+    codeSize  = 24
+    lineGap   = 34
+    titleSize = 52
+    metaSize  = 28
+)
+
+var (
+    background = color.RGBA{0x0d, 0x11, 0x17, 0xff}
+    codeBox    = color.RGBA{0x16, 0x1b, 0x22, 0xff}
+    muted      = color.RGBA{0x8b, 0x94, 0x9e, 0xff}
+    white      = color.RGBA{0xf0, 0xf6, 0xfc, 0xff}
+    colors     = map[kind]color.Color{
+        plain:   color.RGBA{0xc9, 0xd1, 0xd9, 0xff},
+        keyword: color.RGBA{0xff, 0x7b, 0x72, 0xff},
+        str:     color.RGBA{0xa5, 0xd6, 0xff, 0xff},
+        comment: muted,
+        number:  color.RGBA{0x79, 0xc0, 0xff, 0xff},
+    }
+)
+
+// Card is what the preview image shows.
+type Card struct {
+    Title    string
+    Author   string
+    Language string
+    Code     string
+}
+
+type faces struct {
+    title, meta, code font.Face
+}
+
+// loadFonts parses the Go fonts once. Faces are not safe for concurrent use,
+// so each render makes its own.
+var loadFonts = sync.OnceValues(func() ([]*opentype.Font, error) {
+    var fonts []*opentype.Font
+    for _, ttf := range [][]byte{gobold.TTF, goregular.TTF, gomono.TTF} {
+        f, err := opentype.Parse(ttf)
+        if err != nil {
+            return nil, err
+        }
+        fonts = append(fonts, f)
+    }
+    return fonts, nil
+})
+
+func newFaces() (*faces, error) {
+    fonts, err := loadFonts()
+    if err != nil {
+        return nil, err
+    }
+    face := func(i int, size float64) (font.Face, error) {
+        return opentype.NewFace(fonts[i], &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+    }
+    var fs faces
+    if fs.title, err = face(0, titleSize); err != nil {
+        return nil, err
+    }
+    if fs.meta, err = face(1, metaSize); err != nil {
+        return nil, err
+    }
+    if fs.code, err = face(2, codeSize); err != nil {
+        return nil, err
+    }
+    return &fs, nil
+}
+
+// Render draws the card as a PNG: the title, author and language above the
+// first lines of the highlighted code.
+func Render(w io.Writer, card Card) error {
+    fs, err := newFaces()
+    if err != nil {
+        return err
+    }
+    img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+    draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+    text := func(face font.Face, c color.Color, x, y int, s string) {
+        d := font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y)}
+        d.DrawString(s)
+    }
+    contentWidth := fixed.I(Width - 2*margin)
+
+    text(fs.title, white, margin, margin+titleSize, fit(fs.title, card.Title, contentWidth))
+    meta := card.Author
+    if card.Language != "" {
+        meta += " · " + card.Language
+    }
+    text(fs.meta, muted, margin, margin+titleSize+20+metaSize, fit(fs.meta, meta, contentWidth))
+
+    // Code, in a box down to the footer
+    top := margin + titleSize + metaSize + 60
+    box := image.Rect(margin, top, Width-margin, Height-margin-metaSize-20)
+    draw.Draw(img, box, image.NewUniform(codeBox), image.Point{}, draw.Src)
+    lines := codeLines(card.Code, (box.Dy()-20)/lineGap)
+    codeWidth := fixed.I(box.Dx() - 48)
+    for n, tokens := range highlight(card.Language, lines) {
+        d := font.Drawer{Dst: img, Face: fs.code, Dot: fixed.P(box.Min.X+24, box.Min.Y+16+codeSize+n*lineGap)}
+        end := d.Dot.X + codeWidth
+        for _, t := range tokens {
+            d.Src = image.NewUniform(colors[t.kind])
+            s := fit(fs.code, t.text, end-d.Dot.X)
+            d.DrawString(s)
+            if s != t.text {
+                break
+            }
+        }
+    }
+
+    text(fs.meta, muted, margin, Height-margin, "Snippety")
+    return png.Encode(w, img)
+}
+
+// codeLines returns up to limit lines of code, with tabs expanded.
+func codeLines(code string, limit int) []string {
+    limit = min(limit, maxLines)
+    lines := strings.Split(strings.ReplaceAll(code, "\r\n", "\n"), "\n")
+    for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+        lines = lines[:len(lines)-1]
+    }
+    if len(lines) > limit {
+        lines = lines[:limit]
+    }
+    for i, line := range lines {
+        lines[i] = strings.ReplaceAll(line, "\t", strings.Repeat(" ", tabWidth))
+    }
+    return lines
+}
+
+// fit shortens s with an ellipsis until it is at most width wide.
+func fit(face font.Face, s string, width fixed.Int26_6) string {
+    if len(s) > maxChars {
+        cut := maxChars
+        for cut > 0 && !utf8.RuneStart(s[cut]) {
+            cut--
+        }
+        s = s[:cut]
+    }
+    if font.MeasureString(face, s) <= width {
+        return s
+    }
+    for s != "" {
+        _, size := utf8.DecodeLastRuneInString(s)
+        s = s[:len(s)-size]
+        if font.MeasureString(face, s+"…") <= width {
+            return s + "…"
+        }
+    }
+    return ""
+}
@@ -0,0 +1,100 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "gorm.io/gorm"
+    "time"
+)
+
+const (
+    AuditLogin            = "login"
+    AuditLoginFailed      = "login_failed"
+    AuditRegister         = "register"
+    AuditLinkOIDC         = "link_oidc"
+    AuditDeleteAccount    = "delete_account"
+    AuditCreateSnippet    = "create_snippet"
+    AuditUpdateSnippet    = "update_snippet"
+    AuditDeleteSnippet    = "delete_snippet"
+    AuditExportSnippets   = "export_snippets"
+    AuditImportSnippets   = "import_snippets"
+    AuditCreateCollection = "create_collection"
+    AuditUpdateCollection = "update_collection"
+    AuditDeleteCollection = "delete_collection"
+    AuditCreateOrg        = "create_organization"
+    AuditAddOrgMember     = "add_organization_member"
+    AuditSetOrgRole       = "set_organization_role"
+    AuditRemoveOrgMember  = "remove_organization_member"
+    AuditShareSnippet     = "share_snippet"
+    AuditUnshareSnippet   = "unshare_snippet"
+    AuditSetRole          = "admin_set_role"
+    AuditDisableUser      = "admin_disable_user"
+    AuditEnableUser       = "admin_enable_user"
+    AuditRemoveSnippet    = "admin_remove_snippet"
+    AuditCreateBackup     = "admin_create_backup"
+    AuditDownloadBackup   = "admin_download_backup"
+)
+
+var ErrAuditAppendOnly = errors.New("audit events cannot be modified")
+
+// AuditEvent is an append-only record of a security or content event.
+// ActorName is stored alongside ActorID so events stay readable after the
+// account is deleted, and to record the username of failed logins.
+type AuditEvent struct {
+    ID        uint      `gorm:"primary_key" json:"id"`
+    CreatedAt time.Time `gorm:"index" json:"created_at"`
+    Action    string    `gorm:"size:50;index" json:"action"`
+    ActorID   *uint     `gorm:"index" json:"actor_id"`
+    ActorName string    `gorm:"size:191;index" json:"actor"`
+    Target    string    `json:"target"`       // Username or snippet ID the event applies to
+    IP        string    `json:"ip"`
+    Detail    string    `json:"detail"`
+}
+
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+    return ErrAuditAppendOnly
+}
+
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+    return ErrAuditAppendOnly
+}
+
+type AuditFilter struct {
+    Actor string    // Username, matched exactly
+    Since time.Time // Zero means unbounded
+    Until time.Time // Zero means unbounded
+    Limit int       // Zero means no limit
+}
+
+type AuditRepository struct {
+    db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+    return &AuditRepository{db: db}
+}
+
+func (r *AuditRepository) Create(ctx context.Context, event *AuditEvent) error {
+    return r.db.WithContext(ctx).Create(event).Error
+}
+
+// Find returns matching events, newest first.
+func (r *AuditRepository) Find(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+    query := r.db.WithContext(ctx).Order("created_at DESC, id DESC")
+    if filter.Actor != "" {
+        query = query.Where("actor_name = ?", filter.Actor)
+    }
+    if !filter.Since.IsZero() {
+        query = query.Where("created_at >= ?", filter.Since)
+    }
+    if !filter.Until.IsZero() {
+        query = query.Where("created_at < ?", filter.Until)
+    }
+    if filter.Limit > 0 {
+        query = query.Limit(filter.Limit)
+    }
+
+    var events []AuditEvent
+    err := query.Find(&events).Error
+    return events, err
+}
@@ -0,0 +1,89 @@
+package repositories
+
+import (
+    "context"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Collaborator roles. Viewers may see a members-only snippet; editors may
+// also change it. Neither may delete it.
+const (
+    CollaboratorViewer = "viewer"
+    CollaboratorEditor = "editor"
+)
+
+var CollaboratorRoles = []string{CollaboratorViewer, CollaboratorEditor}
+
+// SnippetCollaborator grants one user access to someone else's snippet.
+type SnippetCollaborator struct {
+    SnippetID string    `json:"snippet_id" gorm:"primaryKey;size:191"`
+    UserID    uint      `json:"user_id" gorm:"primaryKey;autoIncrement:false;index"`
+    User      User      `json:"user" gorm:"foreignKey:UserID"`
+    Role      string    `json:"role" gorm:"size:20"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+type CollaboratorInput struct {
+    Username string `form:"username" binding:"required"`
+    Role     string `form:"role" binding:"required"`
+}
+
+type CollaboratorRepository struct {
+    db *gorm.DB
+}
+
+func NewCollaboratorRepository(db *gorm.DB) *CollaboratorRepository {
+    return &CollaboratorRepository{db: db}
+}
+
+// FindBySnippet lists a snippet's collaborators in the order they were added.
+func (r *CollaboratorRepository) FindBySnippet(ctx context.Context, snippetID string) ([]SnippetCollaborator, error) {
+    var collaborators []SnippetCollaborator
+    err := r.db.WithContext(ctx).
+        Where("snippet_id = ?", snippetID).
+        Preload("User").
+        Order("created_at").
+        Find(&collaborators).Error
+    return collaborators, err
+}
+
+func (r *CollaboratorRepository) Find(ctx context.Context, snippetID string, userID uint) (*SnippetCollaborator, error) {
+    var collaborator SnippetCollaborator
+    err := r.db.WithContext(ctx).Where("snippet_id = ? AND user_id = ?", snippetID, userID).First(&collaborator).Error
+    if err != nil {
+        return nil, err
+    }
+    return &collaborator, nil
+}
+
+// Save grants a role on the snippet, replacing the user's previous role if
+// they already had one.
+func (r *CollaboratorRepository) Save(ctx context.Context, collaborator *SnippetCollaborator) error {
+    return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        where := "snippet_id = ? AND user_id = ?"
+        var count int64
+        err := tx.Model(&SnippetCollaborator{}).Where(where, collaborator.SnippetID, collaborator.UserID).Count(&count).Error
+        if err != nil {
+            return err
+        }
+        if count > 0 {
+            return tx.Model(&SnippetCollaborator{}).
+                Where(where, collaborator.SnippetID, collaborator.UserID).
+                Update("role", collaborator.Role).Error
+        }
+        return tx.Omit("User").Create(collaborator).Error
+    })
+}
+
+func (r *CollaboratorRepository) Remove(ctx context.Context, snippetID string, userID uint) error {
+    result := r.db.WithContext(ctx).Where("snippet_id = ? AND user_id = ?", snippetID, userID).Delete(&SnippetCollaborator{})
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return gorm.ErrRecordNotFound
+    }
+    return nil
+}
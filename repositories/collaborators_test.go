@@ -0,0 +1,62 @@
+package repositories_test
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+	"snipetty.com/main/repositories"
+)
+
+func TestCollaboratorRepository(t *testing.T) {
+	db := newTestDB(t)
+	users := repositories.NewUserRepository(db)
+	snippets := repositories.NewSnippetRepository(db)
+	collaborators := repositories.NewCollaboratorRepository(db)
+	alice := createUser(t, users, "alice")
+	bob := createUser(t, users, "bob")
+	id := createSnippet(t, snippets, alice, "shared", "Go")
+
+	// Saving twice changes the role rather than adding a row
+	for _, role := range []string{repositories.CollaboratorViewer, repositories.CollaboratorEditor, repositories.CollaboratorEditor} {
+		if err := collaborators.Save(ctx, &repositories.SnippetCollaborator{SnippetID: id, UserID: bob.ID, Role: role}); err != nil {
+			t.Fatalf("Save(%s): %v", role, err)
+		}
+	}
+	found, err := collaborators.FindBySnippet(ctx, id)
+	if err != nil {
+		t.Fatalf("FindBySnippet: %v", err)
+	}
+	if len(found) != 1 || found[0].Role != repositories.CollaboratorEditor || found[0].User.Username != "bob" {
+		t.Fatalf("collaborators = %+v, want bob as editor", found)
+	}
+
+	// Every edit is kept in the history under its editor, the author's too
+	for _, editor := range []*repositories.User{bob, alice} {
+		in := repositories.CreateSnippetRequest{Title: "edited", Content: "c", Language: "Go", Description: "d", EditorID: editor.ID}
+		if err := snippets.Update(ctx, id, &in); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+	revisions, err := snippets.FindRevisions(ctx, id)
+	if err != nil {
+		t.Fatalf("FindRevisions: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Editor.Username != "alice" || revisions[1].Editor.Username != "bob" {
+		t.Errorf("revisions = %+v, want alice's then bob's", revisions)
+	}
+
+	// Deleting the snippet removes its collaborators
+	if err := snippets.Delete(ctx, id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := collaborators.Find(ctx, id, bob.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Find after snippet delete: err = %v, want not found", err)
+	}
+	if err := collaborators.Remove(ctx, id, bob.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Remove missing: err = %v, want not found", err)
+	}
+	if revisions, _ := snippets.FindRevisions(ctx, id); len(revisions) != 0 {
+		t.Errorf("%d revisions left after snippet delete", len(revisions))
+	}
+}
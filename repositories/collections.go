@@ -0,0 +1,162 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Collection visibilities. Private collections are only shown to their owner.
+const (
+    VisibilityPublic  = "public"
+    VisibilityPrivate = "private"
+)
+
+var ErrAlreadyInCollection = errors.New("The snippet is already in this collection")
+
+// Collection is a user's ordered list of snippets.
+type Collection struct {
+    ID          uint                `json:"id" gorm:"primary_key"`
+    UserID      uint                `json:"user_id" gorm:"index"`
+    User        User                `json:"-" gorm:"foreignKey:UserID"`
+    Title       string              `json:"title"`
+    Description string              `json:"description"`
+    Visibility  string              `json:"visibility" gorm:"size:20;default:public"`
+    Items       []CollectionSnippet `json:"items" gorm:"foreignKey:CollectionID"`
+    CreatedAt   time.Time           `json:"created_at"`
+    UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// CollectionSnippet places a snippet in a collection. Items are ordered by
+// Position, ascending.
+type CollectionSnippet struct {
+    CollectionID uint      `json:"-" gorm:"primaryKey;autoIncrement:false"`
+    SnippetID    string    `json:"snippet_id" gorm:"primaryKey;size:191;index"`
+    Snippet      Snippet   `json:"snippet" gorm:"foreignKey:SnippetID"`
+    Position     int       `json:"position"`
+    CreatedAt    time.Time `json:"created_at"`
+}
+
+type CollectionInput struct {
+    Title       string `form:"title" binding:"required,max=200"`
+    Description string `form:"description" binding:"max=1000"`
+    Visibility  string `form:"visibility" binding:"required"`
+}
+
+// CollectionOrderInput lists snippets of a collection in their new order.
+type CollectionOrderInput struct {
+    SnippetIDs []string `json:"snippet_ids" binding:"required"`
+}
+
+type CollectionRepository struct {
+    db *gorm.DB
+}
+
+func NewCollectionRepository(db *gorm.DB) *CollectionRepository {
+    return &CollectionRepository{db: db}
+}
+
+func (r *CollectionRepository) Create(ctx context.Context, collection *Collection) error {
+    return r.db.WithContext(ctx).Omit("Items").Create(collection).Error
+}
+
+// FindByID loads a collection with its owner and its snippets in order.
+func (r *CollectionRepository) FindByID(ctx context.Context, id uint) (*Collection, error) {
+    var collection Collection
+    err := r.db.WithContext(ctx).
+        Preload("User").
+        Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("position, created_at") }).
+        Preload("Items.Snippet.User").
+        First(&collection, id).Error
+    if err != nil {
+        return nil, err
+    }
+    return &collection, nil
+}
+
+// FindByUserID lists a user's collections, newest first. Items are loaded
+// without their snippets, which is enough to tell membership.
+func (r *CollectionRepository) FindByUserID(ctx context.Context, userID uint) ([]Collection, error) {
+    var collections []Collection
+    err := r.db.WithContext(ctx).
+        Preload("User").
+        Preload("Items.Snippet").
+        Where("user_id = ?", userID).
+        Order("created_at DESC, id DESC").
+        Find(&collections).Error
+    return collections, err
+}
+
+func (r *CollectionRepository) Update(ctx context.Context, collection *Collection) error {
+    return r.db.WithContext(ctx).Model(collection).
+        Select("Title", "Description", "Visibility").
+        Updates(collection).Error
+}
+
+// Delete removes a collection and its items. The snippets are kept.
+func (r *CollectionRepository) Delete(ctx context.Context, id uint) error {
+    return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("collection_id = ?", id).Delete(&CollectionSnippet{}).Error; err != nil {
+            return err
+        }
+        result := tx.Delete(&Collection{}, id)
+        if result.Error != nil {
+            return result.Error
+        }
+        if result.RowsAffected == 0 {
+            return gorm.ErrRecordNotFound
+        }
+        return nil
+    })
+}
+
+// AddSnippet appends a snippet to the end of a collection.
+func (r *CollectionRepository) AddSnippet(ctx context.Context, collectionID uint, snippetID string) error {
+    return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        var count int64
+        if err := tx.Model(&CollectionSnippet{}).Where("collection_id = ? AND snippet_id = ?", collectionID, snippetID).Count(&count).Error; err != nil {
+            return err
+        }
+        if count > 0 {
+            return ErrAlreadyInCollection
+        }
+        var last struct{ Position *int }
+        if err := tx.Model(&CollectionSnippet{}).Select("MAX(position) AS position").Where("collection_id = ?", collectionID).Scan(&last).Error; err != nil {
+            return err
+        }
+        position := 0
+        if last.Position != nil {
+            position = *last.Position + 1
+        }
+        return tx.Create(&CollectionSnippet{CollectionID: collectionID, SnippetID: snippetID, Position: position}).Error
+    })
+}
+
+func (r *CollectionRepository) RemoveSnippet(ctx context.Context, collectionID uint, snippetID string) error {
+    result := r.db.WithContext(ctx).Where("collection_id = ? AND snippet_id = ?", collectionID, snippetID).Delete(&CollectionSnippet{})
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return gorm.ErrRecordNotFound
+    }
+    return nil
+}
+
+// Reorder sets the positions of a collection's items to the order of
+// snippetIDs.
+func (r *CollectionRepository) Reorder(ctx context.Context, collectionID uint, snippetIDs []string) error {
+    return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        for i, id := range snippetIDs {
+            err := tx.Model(&CollectionSnippet{}).
+                Where("collection_id = ? AND snippet_id = ?", collectionID, id).
+                Update("position", i).Error
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
@@ -0,0 +1,128 @@
+package repositories_test
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+	"snipetty.com/main/repositories"
+)
+
+func itemIDs(c *repositories.Collection) []string {
+	var ids []string
+	for _, item := range c.Items {
+		ids = append(ids, item.SnippetID)
+	}
+	return ids
+}
+
+func TestCollectionRepositoryMembership(t *testing.T) {
+	db := newTestDB(t)
+	users := repositories.NewUserRepository(db)
+	snippets := repositories.NewSnippetRepository(db)
+	collections := repositories.NewCollectionRepository(db)
+	alice := createUser(t, users, "alice")
+	a1 := createSnippet(t, snippets, alice, "a1", "Go")
+	a2 := createSnippet(t, snippets, alice, "a2", "Go")
+	a3 := createSnippet(t, snippets, alice, "a3", "Go")
+
+	collection := &repositories.Collection{UserID: alice.ID, Title: "Deploy scripts", Visibility: repositories.VisibilityPublic}
+	if err := collections.Create(ctx, collection); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, id := range []string{a2, a1, a3} {
+		if err := collections.AddSnippet(ctx, collection.ID, id); err != nil {
+			t.Fatalf("AddSnippet(%s): %v", id, err)
+		}
+	}
+	if err := collections.AddSnippet(ctx, collection.ID, a1); !errors.Is(err, repositories.ErrAlreadyInCollection) {
+		t.Errorf("AddSnippet(duplicate) error = %v, want ErrAlreadyInCollection", err)
+	}
+
+	assertOrder := func(want ...string) {
+		t.Helper()
+		found, err := collections.FindByID(ctx, collection.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		got := itemIDs(found)
+		if len(got) != len(want) {
+			t.Fatalf("items = %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("items = %v, want %v", got, want)
+			}
+		}
+		for _, item := range found.Items {
+			if item.Snippet.Title == "" || item.Snippet.User.Username != "alice" {
+				t.Errorf("item %s: snippet not preloaded", item.SnippetID)
+			}
+		}
+	}
+	assertOrder(a2, a1, a3)
+
+	if err := collections.Reorder(ctx, collection.ID, []string{a3, a2, a1}); err != nil {
+		t.Fatalf("Reorder: %v", err)
+	}
+	assertOrder(a3, a2, a1)
+
+	if err := collections.RemoveSnippet(ctx, collection.ID, a2); err != nil {
+		t.Fatalf("RemoveSnippet: %v", err)
+	}
+	if err := collections.RemoveSnippet(ctx, collection.ID, a2); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("RemoveSnippet(missing) error = %v, want ErrRecordNotFound", err)
+	}
+	assertOrder(a3, a1)
+
+	// Deleting a snippet takes it out of its collections
+	if err := snippets.Delete(ctx, a3); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	assertOrder(a1)
+
+	if err := collections.Delete(ctx, collection.ID); err != nil {
+		t.Fatalf("Delete collection: %v", err)
+	}
+	if _, err := collections.FindByID(ctx, collection.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("FindByID after delete error = %v, want ErrRecordNotFound", err)
+	}
+	if _, err := snippets.FindByID(ctx, a1); err != nil {
+		t.Errorf("snippet deleted along with its collection: %v", err)
+	}
+}
+
+func TestDeleteAccountRemovesCollections(t *testing.T) {
+	db := newTestDB(t)
+	users := repositories.NewUserRepository(db)
+	snippets := repositories.NewSnippetRepository(db)
+	collections := repositories.NewCollectionRepository(db)
+	alice := createUser(t, users, "alice")
+	bob := createUser(t, users, "bob")
+	aliceSnippet := createSnippet(t, snippets, alice, "a1", "Go")
+
+	own := &repositories.Collection{UserID: alice.ID, Title: "mine", Visibility: repositories.VisibilityPrivate}
+	bobs := &repositories.Collection{UserID: bob.ID, Title: "bob's", Visibility: repositories.VisibilityPublic}
+	for _, c := range []*repositories.Collection{own, bobs} {
+		if err := collections.Create(ctx, c); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if err := collections.AddSnippet(ctx, c.ID, aliceSnippet); err != nil {
+			t.Fatalf("AddSnippet: %v", err)
+		}
+	}
+
+	if err := users.DeleteAccount(ctx, alice.ID, 0); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	if _, err := collections.FindByID(ctx, own.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("alice's collection survived: %v", err)
+	}
+	remaining, err := collections.FindByID(ctx, bobs.ID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if len(remaining.Items) != 0 {
+		t.Errorf("bob's collection still lists deleted snippets %v", itemIDs(remaining))
+	}
+}
@@ -0,0 +1,91 @@
+package repositories_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"snipetty.com/main/database"
+	"snipetty.com/main/repositories"
+)
+
+var ctx = context.Background()
+
+// newTestDB returns a migrated database for one test. It uses an in-memory
+// SQLite database unless TEST_DB is set to postgres or mysql, in which case
+// TEST_DATABASE_DSN must point at a scratch database (see README).
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dialect := os.Getenv("TEST_DB")
+	if dialect == "" {
+		dialect = "sqlite"
+	}
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dialect == "sqlite" {
+		name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+		dsn = fmt.Sprintf("file:%s?mode=memory&cache=shared", name)
+	} else if dsn == "" {
+		t.Skipf("TEST_DATABASE_DSN is not set for TEST_DB=%s", dialect)
+	}
+
+	db, err := database.Open(dialect, dsn)
+	if err != nil {
+		t.Fatalf("open %s: %v", dialect, err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Silent)
+
+	// Shared servers keep tables between tests, start from a clean slate
+	if dialect != "sqlite" {
+		dropTables(t, db)
+	}
+	if err := database.MigrateUp(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if dialect != "sqlite" {
+			dropTables(t, db)
+		}
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func dropTables(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	err := db.Migrator().DropTable(&repositories.SnippetRevision{}, &repositories.SnippetCollaborator{}, &repositories.OrganizationMember{}, &repositories.Organization{}, &repositories.CollectionSnippet{}, &repositories.Collection{}, &repositories.AuditEvent{}, &repositories.Snippet{}, &repositories.User{}, &database.SchemaMigration{})
+	if err != nil {
+		t.Fatalf("drop tables: %v", err)
+	}
+}
+
+func createUser(t *testing.T, repo *repositories.UserRepository, username string) *repositories.User {
+	t.Helper()
+	user := &repositories.User{Username: username, Password: "hash", Role: repositories.RoleUser}
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("create user %s: %v", username, err)
+	}
+	return user
+}
+
+func createSnippet(t *testing.T, repo *repositories.SnippetRepository, user *repositories.User, title, language string) string {
+	t.Helper()
+	id, err := repo.Create(ctx, &repositories.CreateSnippetRequest{
+		UID:         fmt.Sprintf("%d", user.ID),
+		Title:       title,
+		Content:     "content of " + title,
+		Description: "description",
+		Language:    language,
+	})
+	if err != nil {
+		t.Fatalf("create snippet %s: %v", title, err)
+	}
+	return id
+}
@@ -0,0 +1,156 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Organization roles, from most to least privileged. Owners and admins manage
+// members and may delete any of the organization's snippets; members may
+// create and edit them.
+const (
+    OrgRoleOwner  = "owner"
+    OrgRoleAdmin  = "admin"
+    OrgRoleMember = "member"
+)
+
+var OrgRoles = []string{OrgRoleOwner, OrgRoleAdmin, OrgRoleMember}
+
+// VisibilityMembers hides an organization's snippet from everyone but its
+// members.
+const VisibilityMembers = "members"
+
+var ErrAlreadyMember = errors.New("The user is already a member of this organization")
+
+// Organization is a team that owns snippets together.
+type Organization struct {
+    ID          uint                 `json:"id" gorm:"primary_key"`
+    Slug        string               `json:"slug" gorm:"size:191;unique"`
+    Name        string               `json:"name"`
+    Description string               `json:"description"`
+    Members     []OrganizationMember `json:"members" gorm:"foreignKey:OrganizationID"`
+    CreatedAt   time.Time            `json:"created_at"`
+    UpdatedAt   time.Time            `json:"updated_at"`
+}
+
+type OrganizationMember struct {
+    OrganizationID uint      `json:"-" gorm:"primaryKey;autoIncrement:false"`
+    UserID         uint      `json:"user_id" gorm:"primaryKey;autoIncrement:false;index"`
+    User           User      `json:"user" gorm:"foreignKey:UserID"`
+    Role           string    `json:"role" gorm:"size:20;default:member"`
+    CreatedAt      time.Time `json:"created_at"`
+}
+
+type OrganizationInput struct {
+    Slug        string `form:"slug" binding:"required,max=50"`
+    Name        string `form:"name" binding:"required,max=100"`
+    Description string `form:"description" binding:"max=1000"`
+}
+
+type MemberInput struct {
+    Username string `form:"username" binding:"required"`
+    Role     string `form:"role" binding:"required"`
+}
+
+type OrganizationRepository struct {
+    db *gorm.DB
+}
+
+func NewOrganizationRepository(db *gorm.DB) *OrganizationRepository {
+    return &OrganizationRepository{db: db}
+}
+
+// Create stores the organization with ownerID as its first owner.
+func (r *OrganizationRepository) Create(ctx context.Context, org *Organization, ownerID uint) error {
+    return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        if err := tx.Omit("Members").Create(org).Error; err != nil {
+            return err
+        }
+        return tx.Create(&OrganizationMember{OrganizationID: org.ID, UserID: ownerID, Role: OrgRoleOwner}).Error
+    })
+}
+
+// FindBySlug loads an organization with its members, oldest first.
+func (r *OrganizationRepository) FindBySlug(ctx context.Context, slug string) (*Organization, error) {
+    var org Organization
+    err := r.db.WithContext(ctx).
+        Preload("Members", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+        Preload("Members.User").
+        Where("slug = ?", slug).
+        First(&org).Error
+    if err != nil {
+        return nil, err
+    }
+    return &org, nil
+}
+
+// FindAll lists every organization by name, with members but not their users.
+func (r *OrganizationRepository) FindAll(ctx context.Context) ([]Organization, error) {
+    var orgs []Organization
+    err := r.db.WithContext(ctx).Preload("Members").Order("name").Find(&orgs).Error
+    return orgs, err
+}
+
+// FindByUserID lists the organizations userID belongs to, by name.
+func (r *OrganizationRepository) FindByUserID(ctx context.Context, userID uint) ([]Organization, error) {
+    var orgs []Organization
+    err := r.db.WithContext(ctx).
+        Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+        Where("organization_members.user_id = ?", userID).
+        Preload("Members").
+        Order("organizations.name").
+        Find(&orgs).Error
+    return orgs, err
+}
+
+func (r *OrganizationRepository) FindMember(ctx context.Context, orgID, userID uint) (*OrganizationMember, error) {
+    var member OrganizationMember
+    err := r.db.WithContext(ctx).Where("organization_id = ? AND user_id = ?", orgID, userID).First(&member).Error
+    if err != nil {
+        return nil, err
+    }
+    return &member, nil
+}
+
+func (r *OrganizationRepository) AddMember(ctx context.Context, member *OrganizationMember) error {
+    return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        var count int64
+        err := tx.Model(&OrganizationMember{}).
+            Where("organization_id = ? AND user_id = ?", member.OrganizationID, member.UserID).
+            Count(&count).Error
+        if err != nil {
+            return err
+        }
+        if count > 0 {
+            return ErrAlreadyMember
+        }
+        return tx.Omit("User").Create(member).Error
+    })
+}
+
+func (r *OrganizationRepository) UpdateMember(ctx context.Context, member *OrganizationMember) error {
+    result := r.db.WithContext(ctx).Model(&OrganizationMember{}).
+        Where("organization_id = ? AND user_id = ?", member.OrganizationID, member.UserID).
+        Update("role", member.Role)
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return gorm.ErrRecordNotFound
+    }
+    return nil
+}
+
+func (r *OrganizationRepository) RemoveMember(ctx context.Context, orgID, userID uint) error {
+    result := r.db.WithContext(ctx).Where("organization_id = ? AND user_id = ?", orgID, userID).Delete(&OrganizationMember{})
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return gorm.ErrRecordNotFound
+    }
+    return nil
+}
@@ -1,15 +1,21 @@
 package repositories
 
 import (
+    "context"
     "fmt"
     "gorm.io/gorm"
 	"time"
 )
 
 type Snippet struct {
-    ID          string    `json:"id"`
+    ID          string    `json:"id" gorm:"size:191"`
     UserID      uint      `json:"user_id"`              // Foreign key field
     User        User      `gorm:"foreignKey:UserID"`    // Association
+    // OrganizationID is set when the snippet belongs to an organization;
+    // UserID is then the member who wrote it
+    OrganizationID *uint         `json:"organization_id" gorm:"index"`
+    Organization   *Organization `json:"-" gorm:"foreignKey:OrganizationID"`
+    Visibility     string        `json:"visibility" gorm:"size:20;default:public"`
     Title       string    `json:"title"`
     Content     string    `json:"content"`
     Language    string    `json:"language"`
@@ -18,12 +24,25 @@ type Snippet struct {
     UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// SnippetRevision records one save of a snippet: who made it and when.
+type SnippetRevision struct {
+    ID        uint      `json:"id" gorm:"primary_key"`
+    SnippetID string    `json:"snippet_id" gorm:"size:191;index"`
+    // EditorID is nil once the editor's account has been deleted
+    EditorID  *uint     `json:"editor_id" gorm:"index"`
+    Editor    *User     `json:"editor" gorm:"foreignKey:EditorID"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
 type CreateSnippetRequest struct {
     UID    string `form:"username"`
     Title       string `form:"title" binding:"required"`
     Content     string `form:"content" binding:"required"`
     Description string `form:"description" binding:"required"` 
     Language    string `form:"language" binding:"required"`
+    OrganizationID uint `form:"organization_id"`
+    Visibility  string `form:"visibility"`
+    EditorID    uint   `form:"-"` // Set by the service on update
 }
 
 type SnippetRepository struct {
@@ -34,19 +53,55 @@ func NewSnippetRepository(db *gorm.DB) *SnippetRepository {
     return &SnippetRepository{db: db}
 }
 
-func (r *SnippetRepository) Create(snippet *CreateSnippetRequest) (string, error) {
+func (r *SnippetRepository) Create(ctx context.Context, snippet *CreateSnippetRequest) (string, error) {
+    return createSnippet(r.db.WithContext(ctx), snippet)
+}
+
+// CreateMany creates the snippets in a single transaction, so either all of
+// them are stored or none are. The IDs are returned in the same order.
+func (r *SnippetRepository) CreateMany(ctx context.Context, snippets []CreateSnippetRequest) ([]string, error) {
+    ids := make([]string, 0, len(snippets))
+    err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        for i := range snippets {
+            id, err := createSnippet(tx, &snippets[i])
+            if err != nil {
+                return err
+            }
+            ids = append(ids, id)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return ids, nil
+}
+
+func createSnippet(db *gorm.DB, snippet *CreateSnippetRequest) (string, error) {
     // Get count of user's snippets to generate ID
     var count int64
-    if err := r.db.Model(&Snippet{}).Where("user_id = ?", snippet.UID).Count(&count).Error; err != nil {
+    if err := db.Model(&Snippet{}).Where("user_id = ?", snippet.UID).Count(&count).Error; err != nil {
         return "", err
     }
 
     // Fetch the user's username from the User model
     var user User
-    if err := r.db.Where("id = ?", snippet.UID).First(&user).Error; err != nil {
+    if err := db.Where("id = ?", snippet.UID).First(&user).Error; err != nil {
+        return "", err
+    }
+    id, err := nextSnippetID(db, user.Username, count+1)
+    if err != nil {
         return "", err
     }
-    id :=  fmt.Sprintf("%s-%d", user.Username, count+1)
+
+    visibility := snippet.Visibility
+    if visibility == "" {
+        visibility = VisibilityPublic
+    }
+    var orgID *uint
+    if snippet.OrganizationID != 0 {
+        orgID = &snippet.OrganizationID
+    }
 
     // Create a new snippet instance with ID format (username-snippetcount)
     newSnippet := Snippet{
@@ -56,36 +111,104 @@ func (r *SnippetRepository) Create(snippet *CreateSnippetRequest) (string, error
         Content:     snippet.Content,
         Description: snippet.Description,
         Language:    snippet.Language,
+        OrganizationID: orgID,
+        Visibility:  visibility,
         CreatedAt:   time.Now(),
         UpdatedAt:   time.Now(),
     }
-    return id, r.db.Create(&newSnippet).Error
+    return id, db.Create(&newSnippet).Error
+}
+
+// nextSnippetID returns the first free ID username-n from n on. The count of
+// the user's snippets is only a starting point: deleted snippets lower it,
+// and a deleted account with the same name may have left its snippets to
+// someone else.
+func nextSnippetID(db *gorm.DB, username string, n int64) (string, error) {
+    for ; ; n++ {
+        id := fmt.Sprintf("%s-%d", username, n)
+        var taken int64
+        if err := db.Model(&Snippet{}).Where("id = ?", id).Count(&taken).Error; err != nil {
+            return "", err
+        }
+        if taken == 0 {
+            return id, nil
+        }
+    }
+}
+
+// SnippetFilter narrows FindPublic; empty fields match everything.
+type SnippetFilter struct {
+    Username string
+    Language string
 }
 
-func (r *SnippetRepository) FindByLanguage(language string) ([]Snippet, error) {
+// FindPublic lists public snippets matching filter, newest first, at most
+// limit of them when limit is positive. Members-only organization snippets
+// are listed by FindByOrganization.
+func (r *SnippetRepository) FindPublic(ctx context.Context, filter SnippetFilter, limit int) ([]Snippet, error) {
     var snippets []Snippet
-    err := r.db.Where("language = ?", language).Preload("User").Find(&snippets).Error
+    query := r.db.WithContext(ctx).Where("snippets.visibility = ?", VisibilityPublic)
+    if filter.Username != "" {
+        query = query.Joins("JOIN users ON users.id = snippets.user_id").Where("users.username = ?", filter.Username)
+    }
+    if filter.Language != "" {
+        query = query.Where("snippets.language = ?", filter.Language)
+    }
+    if limit > 0 {
+        query = query.Limit(limit)
+    }
+    err := query.Preload("User").Order("snippets.created_at DESC").Find(&snippets).Error
     return snippets, err
 }
 
-func (r *SnippetRepository) FindByUsername(username string) ([]Snippet, error) {
+func (r *SnippetRepository) FindByLanguage(ctx context.Context, language string) ([]Snippet, error) {
+    return r.FindPublic(ctx, SnippetFilter{Language: language}, 0)
+}
+
+func (r *SnippetRepository) FindByUsername(ctx context.Context, username string) ([]Snippet, error) {
+    return r.FindPublic(ctx, SnippetFilter{Username: username}, 0)
+}
+
+// FindByOrganization lists an organization's snippets, newest first. Members-
+// only snippets are included when membersOnly is true.
+func (r *SnippetRepository) FindByOrganization(ctx context.Context, orgID uint, membersOnly bool) ([]Snippet, error) {
     var snippets []Snippet
-    err := r.db.
+    query := r.db.WithContext(ctx).Where("organization_id = ?", orgID)
+    if !membersOnly {
+        query = query.Where("visibility = ?", VisibilityPublic)
+    }
+    err := query.Preload("User").Order("created_at DESC").Find(&snippets).Error
+    return snippets, err
+}
+
+// FindByUsernameInBatches calls fn with the user's snippets and their
+// organizations, batchSize at a time in ID order, so large accounts are never
+// loaded at once. An error from fn stops the iteration and is returned.
+func (r *SnippetRepository) FindByUsernameInBatches(ctx context.Context, username string, batchSize int, fn func([]Snippet) error) error {
+    var batch []Snippet
+    return r.db.WithContext(ctx).
         Joins("JOIN users ON users.id = snippets.user_id").
         Where("users.username = ?", username).
-        Preload("User").
-        Find(&snippets).Error
+        Preload("Organization").
+        FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+            return fn(batch)
+        }).Error
+}
+
+func (r *SnippetRepository) FindRecent(ctx context.Context, limit int) ([]Snippet, error) {
+    var snippets []Snippet
+    err := r.db.WithContext(ctx).Preload("User").Order("created_at DESC").Limit(limit).Find(&snippets).Error
     return snippets, err
 }
 
-func (r *SnippetRepository) FindByID(id string) (*Snippet, error) {
+func (r *SnippetRepository) FindByID(ctx context.Context, id string) (*Snippet, error) {
     var snippet Snippet
-    err := r.db.Where("id = ?", id).Preload("User").First(&snippet).Error
+    err := r.db.WithContext(ctx).Where("id = ?", id).Preload("User").Preload("Organization").First(&snippet).Error
     return &snippet, err
 }
-func (r *SnippetRepository) Update(id string, snippet *CreateSnippetRequest) error {
+func (r *SnippetRepository) Update(ctx context.Context, id string, snippet *CreateSnippetRequest) error {
     var existingSnippet Snippet
-    if err := r.db.Where("id = ?", id).First(&existingSnippet).Error; err != nil {
+    if err := r.db.WithContext(ctx).Where("id = ?", id).First(&existingSnippet).Error; err != nil {
         return err
     }
 
@@ -94,10 +217,41 @@ func (r *SnippetRepository) Update(id string, snippet *CreateSnippetRequest) err
     existingSnippet.Language = snippet.Language
     existingSnippet.Content = snippet.Content
     existingSnippet.Description = snippet.Description
+    if snippet.Visibility != "" {
+        existingSnippet.Visibility = snippet.Visibility
+    }
+
+    return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        if err := tx.Save(&existingSnippet).Error; err != nil {
+            return err
+        }
+        if snippet.EditorID == 0 {
+            return nil
+        }
+        return tx.Create(&SnippetRevision{SnippetID: id, EditorID: &snippet.EditorID}).Error
+    })
+}
 
-    return r.db.Save(&existingSnippet).Error
+// FindRevisions lists who saved the snippet and when, newest first.
+func (r *SnippetRepository) FindRevisions(ctx context.Context, id string) ([]SnippetRevision, error) {
+    var revisions []SnippetRevision
+    err := r.db.WithContext(ctx).Preload("Editor").Where("snippet_id = ?", id).Order("created_at DESC, id DESC").Find(&revisions).Error
+    return revisions, err
 }
 
-func (r *SnippetRepository) Delete(id string) error {
-    return r.db.Where("id = ?", id).Delete(&Snippet{}).Error
+// Delete removes a snippet with its collaborators and revisions and takes it
+// out of every collection.
+func (r *SnippetRepository) Delete(ctx context.Context, id string) error {
+    return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("snippet_id = ?", id).Delete(&CollectionSnippet{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Where("snippet_id = ?", id).Delete(&SnippetCollaborator{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Where("snippet_id = ?", id).Delete(&SnippetRevision{}).Error; err != nil {
+            return err
+        }
+        return tx.Where("id = ?", id).Delete(&Snippet{}).Error
+    })
 }
\ No newline at end of file
@@ -0,0 +1,170 @@
+package repositories_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/gorm"
+	"snipetty.com/main/repositories"
+)
+
+func TestSnippetRepositoryCreateGeneratesIDs(t *testing.T) {
+	db := newTestDB(t)
+	users := repositories.NewUserRepository(db)
+	snippets := repositories.NewSnippetRepository(db)
+	alice := createUser(t, users, "alice")
+
+	for _, want := range []string{"alice-1", "alice-2"} {
+		if got := createSnippet(t, snippets, alice, want, "Go"); got != want {
+			t.Errorf("Create() id = %q, want %q", got, want)
+		}
+	}
+
+	snippet, err := snippets.FindByID(ctx, "alice-2")
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if snippet.User.Username != "alice" || snippet.UserID != alice.ID {
+		t.Errorf("FindByID owner = %q (%d), want alice (%d)", snippet.User.Username, snippet.UserID, alice.ID)
+	}
+}
+
+func TestSnippetRepositoryCreateSkipsTakenIDs(t *testing.T) {
+	db := newTestDB(t)
+	users := repositories.NewUserRepository(db)
+	snippets := repositories.NewSnippetRepository(db)
+	alice := createUser(t, users, "alice")
+	bob := createUser(t, users, "bob")
+	createSnippet(t, snippets, alice, "a1", "Go")
+	createSnippet(t, snippets, alice, "a2", "Go")
+
+	// alice leaves her snippets to bob and the name is registered again
+	if err := users.DeleteAccount(ctx, alice.ID, bob.ID); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	alice = createUser(t, users, "alice")
+	if got := createSnippet(t, snippets, alice, "new", "Go"); got != "alice-3" {
+		t.Errorf("Create() id = %q, want alice-3", got)
+	}
+
+	// A deleted snippet frees its ID, the others are still skipped
+	if err := snippets.Delete(ctx, "alice-3"); err != nil {
+		t.Fatal(err)
+	}
+	createSnippet(t, snippets, alice, "again", "Go")
+	if got := createSnippet(t, snippets, alice, "next", "Go"); got != "alice-4" {
+		t.Errorf("Create() id = %q, want alice-4", got)
+	}
+}
+
+func TestSnippetRepositoryFinders(t *testing.T) {
+	db := newTestDB(t)
+	users := repositories.NewUserRepository(db)
+	snippets := repositories.NewSnippetRepository(db)
+	alice := createUser(t, users, "alice")
+	bob := createUser(t, users, "bob")
+	createSnippet(t, snippets, alice, "a1", "Go")
+	createSnippet(t, snippets, alice, "a2", "Python")
+	createSnippet(t, snippets, bob, "b1", "Go")
+
+	tests := []struct {
+		name string
+		find func() ([]repositories.Snippet, error)
+		want int
+	}{
+		{"language Go", func() ([]repositories.Snippet, error) { return snippets.FindByLanguage(ctx, "Go") }, 2},
+		{"language Rust", func() ([]repositories.Snippet, error) { return snippets.FindByLanguage(ctx, "Rust") }, 0},
+		{"username alice", func() ([]repositories.Snippet, error) { return snippets.FindByUsername(ctx, "alice") }, 2},
+		{"username nobody", func() ([]repositories.Snippet, error) { return snippets.FindByUsername(ctx, "nobody") }, 0},
+		{"recent", func() ([]repositories.Snippet, error) { return snippets.FindRecent(ctx, 2) }, 2},
+		{"public Go by bob", func() ([]repositories.Snippet, error) {
+			return snippets.FindPublic(ctx, repositories.SnippetFilter{Username: "bob", Language: "Go"}, 0)
+		}, 1},
+		{"public limited", func() ([]repositories.Snippet, error) { return snippets.FindPublic(ctx, repositories.SnippetFilter{}, 2) }, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.find()
+			if err != nil {
+				t.Fatalf("find: %v", err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d snippets, want %d", len(got), tt.want)
+			}
+			for _, s := range got {
+				if s.User.Username == "" {
+					t.Errorf("snippet %s: User not preloaded", s.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestSnippetRepositoryUpdateAndDelete(t *testing.T) {
+	db := newTestDB(t)
+	users := repositories.NewUserRepository(db)
+	snippets := repositories.NewSnippetRepository(db)
+	alice := createUser(t, users, "alice")
+	id := createSnippet(t, snippets, alice, "before", "Go")
+
+	err := snippets.Update(ctx, id, &repositories.CreateSnippetRequest{
+		Title:       "after",
+		Content:     "new content",
+		Description: "new description",
+		Language:    "Rust",
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	snippet, err := snippets.FindByID(ctx, id)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if snippet.Title != "after" || snippet.Language != "Rust" || snippet.Content != "new content" {
+		t.Errorf("Update not applied: %+v", snippet)
+	}
+
+	if err := snippets.Update(ctx, "missing-1", &repositories.CreateSnippetRequest{}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Update(missing) error = %v, want ErrRecordNotFound", err)
+	}
+
+	if err := snippets.Delete(ctx, id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := snippets.FindByID(ctx, id); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("FindByID after delete error = %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestSnippetRepositoryCreateMany(t *testing.T) {
+	db := newTestDB(t)
+	users := repositories.NewUserRepository(db)
+	snippets := repositories.NewSnippetRepository(db)
+	alice := createUser(t, users, "alice")
+	createSnippet(t, snippets, alice, "existing", "Go")
+
+	request := func(uid uint, title string) repositories.CreateSnippetRequest {
+		return repositories.CreateSnippetRequest{UID: fmt.Sprint(uid), Title: title, Content: "c", Description: "d", Language: "Go"}
+	}
+	ids, err := snippets.CreateMany(ctx, []repositories.CreateSnippetRequest{request(alice.ID, "one"), request(alice.ID, "two")})
+	if err != nil {
+		t.Fatalf("CreateMany: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "alice-2" || ids[1] != "alice-3" {
+		t.Errorf("CreateMany ids = %v, want [alice-2 alice-3]", ids)
+	}
+
+	// The second request has no owner, so the first is rolled back too
+	_, err = snippets.CreateMany(ctx, []repositories.CreateSnippetRequest{request(alice.ID, "three"), request(999, "orphan")})
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("CreateMany error = %v, want ErrRecordNotFound", err)
+	}
+	all, err := snippets.FindByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatalf("FindByUsername: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("alice has %d snippets after a failed CreateMany, want 3", len(all))
+	}
+}
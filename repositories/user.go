@@ -1,24 +1,75 @@
 package repositories
 
 import (
+    "context"
+    "errors"
+    "fmt"
     "gorm.io/gorm"
 	"time"
 )
 
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Roles lists every role, lowest privilege first.
+var Roles = []Role{RoleUser, RoleModerator, RoleAdmin}
+
 type User struct {
 	ID        uint   `form:"id" gorm:"primary_key"`
-	Username  string `form:"username" gorm:"unique"`
+	Username  string `form:"username" gorm:"size:191;unique"`
 	Password  string `form:"password"`
+	Email     string `gorm:"size:191;index"`
+	DisplayName string
+	Bio         string
+	AvatarURL   string
+	Role        Role `gorm:"size:20;default:user"`
+	Disabled    bool
+	// OIDCSubject is the "sub" claim of a linked identity provider account.
+	// It is nil for users that only sign in with a local password.
+	OIDCSubject *string `gorm:"column:oidc_subject;size:191;uniqueIndex"`
     Snippets  []Snippet  `gorm:"foreignKey:UserID"` // Association
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// HasRole reports whether the user has at least the given role.
+func (u *User) HasRole(role Role) bool {
+	return roleRank(u.Role) >= roleRank(role)
+}
+
+func roleRank(role Role) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	// Unknown or empty roles are treated as a plain user
+	return 0
+}
+
 type AuthInput struct {
 	Username string `form:"username" binding:"required"`
 	Password string `form:"password" binding:"required"`
 }
 
+type ProfileInput struct {
+	DisplayName string `form:"display_name" binding:"max=100"`
+	Bio         string `form:"bio" binding:"max=1000"`
+	AvatarURL   string `form:"avatar_url" binding:"omitempty,url,max=500"`
+}
+
+type DeleteAccountInput struct {
+	Password   string `form:"password"`
+	Confirm    string `form:"confirm"` // Username, for accounts without a password
+	Mode       string `form:"mode" binding:"required,oneof=delete transfer"`
+	TransferTo string `form:"transfer_to"` // Username receiving the snippets
+}
+
 type UserRepository struct {
     db *gorm.DB
 }
@@ -27,26 +78,120 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
     return &UserRepository{db: db}
 }
 
-func (r *UserRepository) Create(user *User) error {
-    return r.db.Create(user).Error
+func (r *UserRepository) Create(ctx context.Context, user *User) error {
+    return r.db.WithContext(ctx).Create(user).Error
 }
 
-func (r *UserRepository) FindAll() ([]User, error) {
+func (r *UserRepository) FindAll(ctx context.Context) ([]User, error) {
     var user []User
-    err := r.db.Find(&user).Error
+    err := r.db.WithContext(ctx).Order("username").Find(&user).Error
     return user, err
 }
 
-func (r *UserRepository) FindByID(id uint) (*User, error) {
+func (r *UserRepository) CountByRole(ctx context.Context, role Role) (int64, error) {
+    var count int64
+    err := r.db.WithContext(ctx).Model(&User{}).Where("role = ?", role).Count(&count).Error
+    return count, err
+}
+
+func (r *UserRepository) FindByID(ctx context.Context, id uint) (*User, error) {
     var user User
-    err := r.db.First(&user, id).Error
+    err := r.db.WithContext(ctx).First(&user, id).Error
     return &user, err
 }
 
-func (r *UserRepository) Update(user *User) error {
-    return r.db.Save(user).Error
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*User, error) {
+    var user User
+    err := r.db.WithContext(ctx).Where("username = ?", username).First(&user).Error
+    return &user, err
+}
+
+func (r *UserRepository) FindByOIDCSubject(ctx context.Context, subject string) (*User, error) {
+    var user User
+    err := r.db.WithContext(ctx).Where("oidc_subject = ?", subject).First(&user).Error
+    return &user, err
+}
+
+func (r *UserRepository) Update(ctx context.Context, user *User) error {
+    return r.db.WithContext(ctx).Save(user).Error
 }
 
-func (r *UserRepository) Delete(id uint) error {
-    return r.db.Delete(&User{}, id).Error
-}
\ No newline at end of file
+func (r *UserRepository) Delete(ctx context.Context, id uint) error {
+    return r.db.WithContext(ctx).Delete(&User{}, id).Error
+}
+
+// DeleteAccount removes a user in a single transaction. When transferTo is
+// non-zero the user's personal snippets are reassigned to that user, otherwise
+// they are deleted along with the account. Organization snippets stay with
+// their organization and pass to its longest-standing other owner. The user's
+// collections are always deleted.
+func (r *UserRepository) DeleteAccount(ctx context.Context, id uint, transferTo uint) error {
+    return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        var orgIDs []uint
+        err := tx.Model(&Snippet{}).Distinct().Where("user_id = ? AND organization_id IS NOT NULL", id).Pluck("organization_id", &orgIDs).Error
+        if err != nil {
+            return err
+        }
+        for _, orgID := range orgIDs {
+            var owner OrganizationMember
+            err := tx.Where("organization_id = ? AND role = ? AND user_id <> ?", orgID, OrgRoleOwner, id).Order("created_at").First(&owner).Error
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return fmt.Errorf("organization %d has no other owner to take over its snippets", orgID)
+            }
+            if err != nil {
+                return err
+            }
+            err = tx.Model(&Snippet{}).Where("user_id = ? AND organization_id = ?", id, orgID).Update("user_id", owner.UserID).Error
+            if err != nil {
+                return err
+            }
+        }
+
+        personal := tx.Model(&Snippet{}).Where("user_id = ? AND organization_id IS NULL", id)
+        if transferTo != 0 {
+            err = personal.Update("user_id", transferTo).Error
+        } else {
+            owned := tx.Model(&Snippet{}).Select("id").Where("user_id = ? AND organization_id IS NULL", id)
+            err = tx.Where("snippet_id IN (?)", owned).Delete(&CollectionSnippet{}).Error
+            if err == nil {
+                err = tx.Where("snippet_id IN (?)", owned).Delete(&SnippetCollaborator{}).Error
+            }
+            if err == nil {
+                err = tx.Where("snippet_id IN (?)", owned).Delete(&SnippetRevision{}).Error
+            }
+            if err == nil {
+                err = tx.Where("user_id = ? AND organization_id IS NULL", id).Delete(&Snippet{}).Error
+            }
+        }
+        if err != nil {
+            return err
+        }
+
+        collections := tx.Model(&Collection{}).Select("id").Where("user_id = ?", id)
+        if err := tx.Where("collection_id IN (?)", collections).Delete(&CollectionSnippet{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Where("user_id = ?", id).Delete(&Collection{}).Error; err != nil {
+            return err
+        }
+
+        if err := tx.Where("user_id = ?", id).Delete(&OrganizationMember{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Where("user_id = ?", id).Delete(&SnippetCollaborator{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Model(&SnippetRevision{}).Where("editor_id = ?", id).Update("editor_id", nil).Error; err != nil {
+            return err
+        }
+
+        result := tx.Delete(&User{}, id)
+        if result.Error != nil {
+            return result.Error
+        }
+        if result.RowsAffected == 0 {
+            return gorm.ErrRecordNotFound
+        }
+        return nil
+    })
+}
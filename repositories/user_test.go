@@ -0,0 +1,130 @@
+package repositories_test
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+	"snipetty.com/main/repositories"
+)
+
+func TestUserRepositoryLookups(t *testing.T) {
+	db := newTestDB(t)
+	users := repositories.NewUserRepository(db)
+	alice := createUser(t, users, "alice")
+	subject := "sub-123"
+	alice.OIDCSubject = &subject
+	if err := users.Update(ctx, alice); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	if u, err := users.FindByUsername(ctx, "alice"); err != nil || u.ID != alice.ID {
+		t.Errorf("FindByUsername = %v, %v", u, err)
+	}
+	if u, err := users.FindByOIDCSubject(ctx, "sub-123"); err != nil || u.ID != alice.ID {
+		t.Errorf("FindByOIDCSubject = %v, %v", u, err)
+	}
+	if _, err := users.FindByUsername(ctx, "nobody"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("FindByUsername(nobody) error = %v, want ErrRecordNotFound", err)
+	}
+
+	duplicate := &repositories.User{Username: "alice"}
+	if err := users.Create(ctx, duplicate); err == nil {
+		t.Error("Create allowed a duplicate username")
+	}
+}
+
+func TestUserRepositoryDeleteAccount(t *testing.T) {
+	tests := []struct {
+		name         string
+		transfer     bool
+		wantBobCount int
+	}{
+		{"purge snippets", false, 1},
+		{"transfer snippets", true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			users := repositories.NewUserRepository(db)
+			snippets := repositories.NewSnippetRepository(db)
+			alice := createUser(t, users, "alice")
+			bob := createUser(t, users, "bob")
+			createSnippet(t, snippets, alice, "a1", "Go")
+			createSnippet(t, snippets, alice, "a2", "Go")
+			createSnippet(t, snippets, bob, "b1", "Go")
+
+			var transferTo uint
+			if tt.transfer {
+				transferTo = bob.ID
+			}
+			if err := users.DeleteAccount(ctx, alice.ID, transferTo); err != nil {
+				t.Fatalf("DeleteAccount: %v", err)
+			}
+
+			if _, err := users.FindByID(ctx, alice.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Errorf("user still exists: %v", err)
+			}
+			var orphans int64
+			db.Model(&repositories.Snippet{}).Where("user_id = ?", alice.ID).Count(&orphans)
+			if orphans != 0 {
+				t.Errorf("%d snippets still reference the deleted user", orphans)
+			}
+			got, err := snippets.FindByUsername(ctx, "bob")
+			if err != nil {
+				t.Fatalf("FindByUsername: %v", err)
+			}
+			if len(got) != tt.wantBobCount {
+				t.Errorf("bob has %d snippets, want %d", len(got), tt.wantBobCount)
+			}
+		})
+	}
+}
+
+func TestUserRepositoryDeleteAccountMissingUserRollsBack(t *testing.T) {
+	db := newTestDB(t)
+	users := repositories.NewUserRepository(db)
+	snippets := repositories.NewSnippetRepository(db)
+	bob := createUser(t, users, "bob")
+	createSnippet(t, snippets, bob, "b1", "Go")
+
+	if err := users.DeleteAccount(ctx, bob.ID+100, bob.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("DeleteAccount(missing) error = %v, want ErrRecordNotFound", err)
+	}
+	if got, _ := snippets.FindByUsername(ctx, "bob"); len(got) != 1 {
+		t.Errorf("bob has %d snippets after failed delete, want 1", len(got))
+	}
+}
+
+func TestUserRepositoryDeleteAccountKeepsOrganizationSnippets(t *testing.T) {
+	db := newTestDB(t)
+	users := repositories.NewUserRepository(db)
+	snippets := repositories.NewSnippetRepository(db)
+	orgs := repositories.NewOrganizationRepository(db)
+	alice := createUser(t, users, "alice")
+	bob := createUser(t, users, "bob")
+	org := &repositories.Organization{Slug: "team", Name: "Team"}
+	if err := orgs.Create(ctx, org, bob.ID); err != nil {
+		t.Fatalf("Create org: %v", err)
+	}
+	if err := orgs.AddMember(ctx, &repositories.OrganizationMember{OrganizationID: org.ID, UserID: alice.ID, Role: repositories.OrgRoleMember}); err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+	team := createSnippet(t, snippets, alice, "team", "Go")
+	db.Model(&repositories.Snippet{}).Where("id = ?", team).Update("organization_id", org.ID)
+	createSnippet(t, snippets, alice, "personal", "Go")
+
+	if err := users.DeleteAccount(ctx, alice.ID, 0); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	got, err := snippets.FindByID(ctx, team)
+	if err != nil {
+		t.Fatalf("organization snippet deleted with its author: %v", err)
+	}
+	if got.UserID != bob.ID {
+		t.Errorf("organization snippet passed to user %d, want the owner %d", got.UserID, bob.ID)
+	}
+	if mine, _ := snippets.FindByUsername(ctx, "bob"); len(mine) != 1 {
+		t.Errorf("bob has %d snippets, want only the organization's", len(mine))
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "snipetty.com/main/config"
+    "snipetty.com/main/database"
+)
+
+const restoreUsage = "usage: restore <backup file>"
+
+// runRestore implements the restore subcommand. It replaces the SQLite
+// database with a verified backup, so the server must be stopped first.
+func runRestore(cfg config.Database, args []string) error {
+    if len(args) != 1 {
+        return errors.New(restoreUsage)
+    }
+    if cfg.Driver != "sqlite" {
+        return database.ErrBackupUnsupported
+    }
+
+    previous, err := database.Restore(context.Background(), args[0], cfg.Path)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Restored %s from %s\n", cfg.Path, args[0])
+    if previous != "" {
+        fmt.Printf("The previous database was kept as %s\n", previous)
+    }
+    return nil
+}
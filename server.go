@@ -0,0 +1,66 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "net/http"
+    "os/signal"
+    "syscall"
+
+    "snipetty.com/main/app"
+)
+
+// serve runs the HTTP server until SIGINT or SIGTERM, then stops accepting
+// connections, waits for in-flight requests to finish, saves the live
+// editing sessions and closes the database.
+func serve(application *app.App) error {
+    cfg := application.Config
+    srv := &http.Server{
+        Addr:              ":" + cfg.Port,
+        Handler:           application.Router,
+        ReadTimeout:       cfg.Server.ReadTimeout,
+        ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+        WriteTimeout:      cfg.Server.WriteTimeout,
+        IdleTimeout:       cfg.Server.IdleTimeout,
+        MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+    defer stop()
+
+    serveErr := make(chan error, 1)
+    go func() {
+        if cfg.Server.TLS() {
+            slog.Info("starting server", "port", cfg.Port, "tls", true)
+            serveErr <- srv.ListenAndServeTLS(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+        } else {
+            slog.Info("starting server", "port", cfg.Port, "tls", false)
+            serveErr <- srv.ListenAndServe()
+        }
+    }()
+
+    select {
+    case err := <-serveErr:
+        application.Close()
+        return err
+    case <-ctx.Done():
+    }
+    stop()
+
+    slog.Info("shutting down, waiting for in-flight requests", "timeout", cfg.Server.ShutdownTimeout)
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+    defer cancel()
+    shutdownErr := srv.Shutdown(shutdownCtx)
+    if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+        slog.Error("server error during shutdown", "error", err)
+    }
+
+    // srv.Shutdown does not wait for hijacked connections, so live editing
+    // sessions are ended here
+    if err := application.Shutdown(shutdownCtx); err != nil {
+        slog.Error("failed to save live sessions or close database", "error", err)
+    }
+    slog.Info("server stopped")
+    return shutdownErr
+}
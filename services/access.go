@@ -0,0 +1,78 @@
+package services
+
+import (
+    "context"
+    "errors"
+
+    "gorm.io/gorm"
+    "snipetty.com/main/repositories"
+)
+
+// SnippetAccess says what a user may do with a snippet:
+//
+//   - authors may do anything, with organization snippets only while they
+//     are still members
+//   - members of the owning organization may view and edit, and its owners
+//     and admins may also delete
+//   - collaborators may view, and editors may also edit
+//
+// Everyone may view public snippets; members-only snippets are hidden from
+// the rest. Whoever may delete a snippet also manages its collaborators.
+type SnippetAccess struct {
+    View   bool
+    Edit   bool
+    Delete bool
+}
+
+// Grants are where access to other people's snippets comes from. A nil
+// repository grants nothing.
+type Grants struct {
+    Orgs          OrganizationRepository
+    Collaborators CollaboratorRepository
+}
+
+func (g Grants) access(ctx context.Context, viewerID uint, snippet *repositories.Snippet) (SnippetAccess, error) {
+    author := viewerID != 0 && snippet.UserID == viewerID
+    if author && snippet.OrganizationID == nil {
+        return SnippetAccess{View: true, Edit: true, Delete: true}, nil
+    }
+    access := SnippetAccess{View: snippet.Visibility != repositories.VisibilityMembers}
+    if viewerID == 0 {
+        return access, nil
+    }
+    if snippet.OrganizationID != nil {
+        // Organization snippets stay with the organization: authors who
+        // leave it keep no more access than anyone else
+        member, err := g.member(ctx, *snippet.OrganizationID, viewerID)
+        switch {
+        case err == nil:
+            access.View, access.Edit = true, true
+            access.Delete = author || member.Role != repositories.OrgRoleMember
+        case !errors.Is(err, ErrNotOrgMember):
+            return SnippetAccess{}, err
+        }
+    }
+    if g.Collaborators != nil {
+        collaborator, err := g.Collaborators.Find(ctx, snippet.ID, viewerID)
+        switch {
+        case err == nil:
+            access.View = true
+            access.Edit = access.Edit || collaborator.Role == repositories.CollaboratorEditor
+        case !errors.Is(err, gorm.ErrRecordNotFound):
+            return SnippetAccess{}, err
+        }
+    }
+    return access, nil
+}
+
+// member returns userID's membership of orgID, or ErrNotOrgMember.
+func (g Grants) member(ctx context.Context, orgID, userID uint) (*repositories.OrganizationMember, error) {
+    if g.Orgs == nil {
+        return nil, ErrNotOrgMember
+    }
+    member, err := g.Orgs.FindMember(ctx, orgID, userID)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, ErrNotOrgMember
+    }
+    return member, err
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "snipetty.com/main/database"
+    "snipetty.com/main/repositories"
+)
+
+var (
+    ErrInvalidRole = errors.New("Unknown role")
+    ErrSelfAction  = errors.New("You cannot change your own role or status")
+)
+
+type AdminService struct {
+    users    UserRepository
+    snippets SnippetRepository
+    audit    *AuditService
+    backups  BackupStore // Nil when the database does not support backups
+}
+
+// Dashboard is the data shown on the admin panel.
+type Dashboard struct {
+    Users    []repositories.User
+    Snippets []repositories.Snippet
+}
+
+func NewAdminService(users UserRepository, snippets SnippetRepository, audit *AuditService, backups BackupStore) *AdminService {
+    return &AdminService{users: users, snippets: snippets, audit: audit, backups: backups}
+}
+
+func (s *AdminService) Dashboard(ctx context.Context) (*Dashboard, error) {
+    users, err := s.users.FindAll(ctx)
+    if err != nil {
+        return nil, err
+    }
+    snippets, err := s.snippets.FindRecent(ctx, 50)
+    if err != nil {
+        return nil, err
+    }
+    return &Dashboard{Users: users, Snippets: snippets}, nil
+}
+
+func (s *AdminService) SetRole(ctx context.Context, actor *repositories.User, userID uint, role repositories.Role) error {
+    if !validRole(role) {
+        return ErrInvalidRole
+    }
+    if actor.ID == userID {
+        return ErrSelfAction
+    }
+    user, err := s.users.FindByID(ctx, userID)
+    if err != nil {
+        return err
+    }
+    previous := user.Role
+    user.Role = role
+    if err := s.users.Update(ctx, user); err != nil {
+        return err
+    }
+    s.audit.Record(ctx, repositories.AuditSetRole, actor, user.Username, fmt.Sprintf("%s -> %s", previous, role))
+    return nil
+}
+
+func (s *AdminService) SetDisabled(ctx context.Context, actor *repositories.User, userID uint, disabled bool) error {
+    if actor.ID == userID {
+        return ErrSelfAction
+    }
+    user, err := s.users.FindByID(ctx, userID)
+    if err != nil {
+        return err
+    }
+    user.Disabled = disabled
+    if err := s.users.Update(ctx, user); err != nil {
+        return err
+    }
+    action := repositories.AuditEnableUser
+    if disabled {
+        action = repositories.AuditDisableUser
+    }
+    s.audit.Record(ctx, action, actor, user.Username, "")
+    return nil
+}
+
+func (s *AdminService) RemoveSnippet(ctx context.Context, actor *repositories.User, snippetID string) error {
+    snippet, err := s.snippets.FindByID(ctx, snippetID)
+    if err != nil {
+        return err
+    }
+    if err := s.snippets.Delete(ctx, snippet.ID); err != nil {
+        return err
+    }
+    s.audit.Record(ctx, repositories.AuditRemoveSnippet, actor, snippet.ID, snippet.Title)
+    return nil
+}
+
+// Backups lists the database backups, newest first.
+func (s *AdminService) Backups(ctx context.Context) ([]database.BackupFile, error) {
+    if s.backups == nil {
+        return nil, database.ErrBackupUnsupported
+    }
+    return s.backups.List()
+}
+
+// CreateBackup backs up the database while it keeps serving requests.
+func (s *AdminService) CreateBackup(ctx context.Context, actor *repositories.User) (*database.BackupFile, error) {
+    if s.backups == nil {
+        return nil, database.ErrBackupUnsupported
+    }
+    backup, err := s.backups.Create(ctx)
+    if err != nil {
+        return nil, err
+    }
+    s.audit.Record(ctx, repositories.AuditCreateBackup, actor, backup.Name, fmt.Sprintf("%d bytes", backup.Size))
+    return backup, nil
+}
+
+// BackupPath returns the file of the named backup for download. The backup
+// holds every account's data, so downloads are audited.
+func (s *AdminService) BackupPath(ctx context.Context, actor *repositories.User, name string) (string, error) {
+    if s.backups == nil {
+        return "", database.ErrBackupUnsupported
+    }
+    path, err := s.backups.Path(name)
+    if err != nil {
+        return "", err
+    }
+    s.audit.Record(ctx, repositories.AuditDownloadBackup, actor, name, "")
+    return path, nil
+}
+
+func validRole(role repositories.Role) bool {
+    for _, r := range repositories.Roles {
+        if r == role {
+            return true
+        }
+    }
+    return false
+}
+
+// Bootstrap grants the admin role to username so a fresh install has
+// someone who can reach the admin panel, and reports whether it did. Once
+// any admin exists it does nothing: by then the name may belong to someone
+// else, such as a user who registered it after the first admin left.
+func (s *AdminService) Bootstrap(ctx context.Context, username string) (bool, error) {
+    admins, err := s.users.CountByRole(ctx, repositories.RoleAdmin)
+    if err != nil || admins > 0 {
+        return false, err
+    }
+    user, err := s.users.FindByUsername(ctx, username)
+    if err != nil {
+        return false, err
+    }
+    previous := user.Role
+    user.Role = repositories.RoleAdmin
+    if err := s.users.Update(ctx, user); err != nil {
+        return false, err
+    }
+    s.audit.Record(ctx, repositories.AuditSetRole, nil, user.Username, fmt.Sprintf("%s -> %s (ADMIN_USERNAME)", previous, user.Role))
+    return true, nil
+}
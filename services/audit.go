@@ -0,0 +1,67 @@
+package services
+
+import (
+    "context"
+    "log/slog"
+
+    "snipetty.com/main/repositories"
+)
+
+type clientIPKey struct{}
+
+// WithClientIP attaches the caller's IP address to ctx so audit events
+// written further down the service layer can record it.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+    return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func clientIP(ctx context.Context) string {
+    ip, _ := ctx.Value(clientIPKey{}).(string)
+    return ip
+}
+
+// AuditObserver is notified of every recorded event, e.g. to count logins
+// for metrics.
+type AuditObserver interface {
+    AuditRecorded(event *repositories.AuditEvent)
+}
+
+type AuditService struct {
+    repo      AuditRepository
+    observers []AuditObserver
+}
+
+func NewAuditService(repo AuditRepository, observers ...AuditObserver) *AuditService {
+    return &AuditService{repo: repo, observers: observers}
+}
+
+// Record appends an event. Failing to write the audit log is logged but does
+// not fail the operation being audited. A nil service records nothing.
+func (s *AuditService) Record(ctx context.Context, action string, actor *repositories.User, target, detail string) {
+    if s == nil || s.repo == nil {
+        return
+    }
+    event := &repositories.AuditEvent{
+        Action: action,
+        Target: target,
+        IP:     clientIP(ctx),
+        Detail: detail,
+    }
+    if actor != nil {
+        if actor.ID != 0 {
+            id := actor.ID
+            event.ActorID = &id
+        }
+        event.ActorName = actor.Username
+    }
+    for _, o := range s.observers {
+        o.AuditRecorded(event)
+    }
+    if err := s.repo.Create(ctx, event); err != nil {
+        slog.ErrorContext(ctx, "failed to write audit event", "action", action, "error", err)
+    }
+}
+
+func (s *AuditService) Find(ctx context.Context, filter repositories.AuditFilter) ([]repositories.AuditEvent, error) {
+    return s.repo.Find(ctx, filter)
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "go.opentelemetry.io/otel/attribute"
+    "gorm.io/gorm"
+    "snipetty.com/main/repositories"
+)
+
+// Collaborators lists who else has access to a snippet. Only those who may
+// delete the snippet may see and manage the list.
+func (s *SnippetService) Collaborators(ctx context.Context, actor *repositories.User, id string) ([]repositories.SnippetCollaborator, error) {
+    if _, err := s.authorize(ctx, actor, id, func(a SnippetAccess) bool { return a.Delete }); err != nil {
+        return nil, err
+    }
+    if s.grants.Collaborators == nil {
+        return nil, nil
+    }
+    return s.grants.Collaborators.FindBySnippet(ctx, id)
+}
+
+// SetCollaborator grants the named user a role on the snippet, replacing
+// the role they had. Viewers only make sense for organization snippets, as
+// everyone sees the rest.
+func (s *SnippetService) SetCollaborator(ctx context.Context, actor *repositories.User, id string, input repositories.CollaboratorInput) (err error) {
+    ctx, span := startSpan(ctx, "SnippetService.SetCollaborator", attribute.String("snippet.id", id))
+    defer func() { endSpan(span, err) }()
+
+    if input.Role != repositories.CollaboratorViewer && input.Role != repositories.CollaboratorEditor {
+        return ErrInvalidCollaboratorRole
+    }
+    snippet, err := s.authorize(ctx, actor, id, func(a SnippetAccess) bool { return a.Delete })
+    if err != nil {
+        return err
+    }
+    if input.Role == repositories.CollaboratorViewer && snippet.OrganizationID == nil {
+        return ErrViewerNeedsOrganization
+    }
+    if s.grants.Collaborators == nil {
+        return errors.New("collaborators are not available")
+    }
+    user, err := s.users.FindByUsername(ctx, input.Username)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return ErrUnknownMember
+    }
+    if err != nil {
+        return err
+    }
+    if user.ID == snippet.UserID {
+        return ErrCollaboratorIsAuthor
+    }
+    collaborator := &repositories.SnippetCollaborator{SnippetID: snippet.ID, UserID: user.ID, Role: input.Role}
+    if err := s.grants.Collaborators.Save(ctx, collaborator); err != nil {
+        return err
+    }
+    s.audit.Record(ctx, repositories.AuditShareSnippet, actor, snippet.ID, fmt.Sprintf("%s as %s", user.Username, input.Role))
+    return nil
+}
+
+func (s *SnippetService) RemoveCollaborator(ctx context.Context, actor *repositories.User, id string, userID uint) (err error) {
+    ctx, span := startSpan(ctx, "SnippetService.RemoveCollaborator", attribute.String("snippet.id", id))
+    defer func() { endSpan(span, err) }()
+
+    if _, err := s.authorize(ctx, actor, id, func(a SnippetAccess) bool { return a.Delete }); err != nil {
+        return err
+    }
+    if s.grants.Collaborators == nil {
+        return gorm.ErrRecordNotFound
+    }
+    if err := s.grants.Collaborators.Remove(ctx, id, userID); err != nil {
+        return err
+    }
+    detail := fmt.Sprintf("user %d", userID)
+    if user, err := s.users.FindByID(ctx, userID); err == nil {
+        detail = user.Username
+    }
+    s.audit.Record(ctx, repositories.AuditUnshareSnippet, actor, id, detail)
+    return nil
+}
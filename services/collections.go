@@ -0,0 +1,219 @@
+package services
+
+import (
+    "context"
+    "errors"
+
+    "gorm.io/gorm"
+    "snipetty.com/main/repositories"
+)
+
+var (
+    ErrNotCollectionOwner = errors.New("Not authorized to change this collection")
+    ErrInvalidVisibility  = errors.New("Visibility must be public or private")
+    ErrInvalidOrder       = errors.New("The order may only list snippets of the collection, each once")
+)
+
+type CollectionService struct {
+    repo     CollectionRepository
+    snippets SnippetRepository
+    grants   Grants
+    audit    *AuditService
+}
+
+func NewCollectionService(repo CollectionRepository, snippets SnippetRepository, grants Grants, audit *AuditService) *CollectionService {
+    return &CollectionService{repo: repo, snippets: snippets, grants: grants, audit: audit}
+}
+
+func (s *CollectionService) Create(ctx context.Context, actor *repositories.User, input repositories.CollectionInput) (*repositories.Collection, error) {
+    if !validVisibility(input.Visibility) {
+        return nil, ErrInvalidVisibility
+    }
+    collection := &repositories.Collection{
+        UserID:      actor.ID,
+        Title:       input.Title,
+        Description: input.Description,
+        Visibility:  input.Visibility,
+    }
+    if err := s.repo.Create(ctx, collection); err != nil {
+        return nil, err
+    }
+    s.audit.Record(ctx, repositories.AuditCreateCollection, actor, collection.Title, "")
+    return collection, nil
+}
+
+// Get returns a collection with the snippets viewerID may see. Private
+// collections are only found by their owner; anyone else gets
+// gorm.ErrRecordNotFound.
+func (s *CollectionService) Get(ctx context.Context, viewerID uint, id uint) (*repositories.Collection, error) {
+    collection, err := s.repo.FindByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if collection.Visibility == repositories.VisibilityPrivate && collection.UserID != viewerID {
+        return nil, gorm.ErrRecordNotFound
+    }
+    if err := s.dropHidden(ctx, viewerID, collection); err != nil {
+        return nil, err
+    }
+    return collection, nil
+}
+
+// ListByUser returns all of a user's collections, private ones included,
+// with the items the user may still see.
+func (s *CollectionService) ListByUser(ctx context.Context, userID uint) ([]repositories.Collection, error) {
+    collections, err := s.repo.FindByUserID(ctx, userID)
+    if err != nil {
+        return nil, err
+    }
+    for i := range collections {
+        if err := s.dropHidden(ctx, userID, &collections[i]); err != nil {
+            return nil, err
+        }
+    }
+    return collections, nil
+}
+
+// dropHidden removes the items whose snippet viewerID may not see, so that
+// neither they nor their number show.
+func (s *CollectionService) dropHidden(ctx context.Context, viewerID uint, collection *repositories.Collection) error {
+    items := collection.Items[:0]
+    for _, item := range collection.Items {
+        access, err := s.grants.access(ctx, viewerID, &item.Snippet)
+        if err != nil {
+            return err
+        }
+        if access.View {
+            items = append(items, item)
+        }
+    }
+    collection.Items = items
+    return nil
+}
+
+func (s *CollectionService) Update(ctx context.Context, actor *repositories.User, id uint, input repositories.CollectionInput) error {
+    if !validVisibility(input.Visibility) {
+        return ErrInvalidVisibility
+    }
+    collection, err := s.owned(ctx, actor, id)
+    if err != nil {
+        return err
+    }
+    collection.Title, collection.Description, collection.Visibility = input.Title, input.Description, input.Visibility
+    if err := s.repo.Update(ctx, collection); err != nil {
+        return err
+    }
+    s.audit.Record(ctx, repositories.AuditUpdateCollection, actor, collection.Title, "")
+    return nil
+}
+
+func (s *CollectionService) Delete(ctx context.Context, actor *repositories.User, id uint) error {
+    collection, err := s.owned(ctx, actor, id)
+    if err != nil {
+        return err
+    }
+    if err := s.repo.Delete(ctx, id); err != nil {
+        return err
+    }
+    s.audit.Record(ctx, repositories.AuditDeleteCollection, actor, collection.Title, "")
+    return nil
+}
+
+// AddSnippet appends any existing snippet, not only the actor's own, to one
+// of the actor's collections.
+func (s *CollectionService) AddSnippet(ctx context.Context, actor *repositories.User, id uint, snippetID string) error {
+    if _, err := s.owned(ctx, actor, id); err != nil {
+        return err
+    }
+    snippet, err := s.snippets.FindByID(ctx, snippetID)
+    if err != nil {
+        return err
+    }
+    access, err := s.grants.access(ctx, actor.ID, snippet)
+    if err != nil {
+        return err
+    }
+    if !access.View {
+        return gorm.ErrRecordNotFound
+    }
+    return s.repo.AddSnippet(ctx, id, snippetID)
+}
+
+func (s *CollectionService) RemoveSnippet(ctx context.Context, actor *repositories.User, id uint, snippetID string) error {
+    if _, err := s.owned(ctx, actor, id); err != nil {
+        return err
+    }
+    return s.repo.RemoveSnippet(ctx, id, snippetID)
+}
+
+// MoveSnippet moves a snippet offset places towards the end of the collection
+// (negative offsets move it towards the start), stopping at either end.
+func (s *CollectionService) MoveSnippet(ctx context.Context, actor *repositories.User, id uint, snippetID string, offset int) error {
+    collection, err := s.owned(ctx, actor, id)
+    if err != nil {
+        return err
+    }
+    order := make([]string, 0, len(collection.Items))
+    from := -1
+    for i, item := range collection.Items {
+        order = append(order, item.SnippetID)
+        if item.SnippetID == snippetID {
+            from = i
+        }
+    }
+    if from < 0 {
+        return gorm.ErrRecordNotFound
+    }
+    to := min(max(from+offset, 0), len(order)-1)
+    if to == from {
+        return nil
+    }
+    order = append(order[:from], order[from+1:]...)
+    order = append(order[:to], append([]string{snippetID}, order[to:]...)...)
+    return s.repo.Reorder(ctx, id, order)
+}
+
+// Reorder moves the listed snippets to the start of the collection in the
+// given order. Snippets left out follow them in their current order, so
+// items the owner can no longer see keep their place relative to each other.
+func (s *CollectionService) Reorder(ctx context.Context, actor *repositories.User, id uint, snippetIDs []string) error {
+    collection, err := s.owned(ctx, actor, id)
+    if err != nil {
+        return err
+    }
+    listed := make(map[string]bool, len(snippetIDs))
+    for _, snippetID := range snippetIDs {
+        listed[snippetID] = true
+    }
+    if len(listed) != len(snippetIDs) {
+        return ErrInvalidOrder
+    }
+    order := append(make([]string, 0, len(collection.Items)), snippetIDs...)
+    for _, item := range collection.Items {
+        if listed[item.SnippetID] {
+            delete(listed, item.SnippetID)
+        } else {
+            order = append(order, item.SnippetID)
+        }
+    }
+    if len(listed) > 0 {
+        return ErrInvalidOrder
+    }
+    return s.repo.Reorder(ctx, id, order)
+}
+
+// owned loads a collection and checks that actor owns it.
+func (s *CollectionService) owned(ctx context.Context, actor *repositories.User, id uint) (*repositories.Collection, error) {
+    collection, err := s.repo.FindByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if actor == nil || collection.UserID != actor.ID {
+        return nil, ErrNotCollectionOwner
+    }
+    return collection, nil
+}
+
+func validVisibility(visibility string) bool {
+    return visibility == repositories.VisibilityPublic || visibility == repositories.VisibilityPrivate
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+    "archive/zip"
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "strings"
+    "time"
+    "unicode"
+
+    "go.opentelemetry.io/otel/attribute"
+    "snipetty.com/main/repositories"
+)
+
+// ExportManifestName is the metadata file at the root of an export.
+const ExportManifestName = "manifest.json"
+
+// exportBatchSize is how many snippets are loaded at a time while exporting.
+const exportBatchSize = 100
+
+// languageExtensions maps the languages offered by the editor to file
+// extensions. Other languages are exported as .txt.
+var languageExtensions = map[string]string{
+    "Python":     ".py",
+    "Javascript": ".js",
+    "Go":         ".go",
+    "Rust":       ".rs",
+    "Typescript": ".ts",
+}
+
+// ExportManifest describes the snippets in an export archive. Content lives in
+// the file each entry points to.
+type ExportManifest struct {
+    Version    int               `json:"version"`
+    Username   string            `json:"username"`
+    ExportedAt time.Time         `json:"exported_at"`
+    Snippets   []ExportedSnippet `json:"snippets"`
+}
+
+type ExportedSnippet struct {
+    ID           string    `json:"id"`
+    Title        string    `json:"title"`
+    Description  string    `json:"description"`
+    Language     string    `json:"language"`
+    Visibility   string    `json:"visibility,omitempty"`
+    Organization string    `json:"organization,omitempty"` // Slug
+    File         string    `json:"file"` // Path inside the archive
+    CreatedAt    time.Time `json:"created_at"`
+    UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ExportSnippets writes a zip of the snippets actor wrote and can still see
+// to w: one file per snippet under snippets/ plus manifest.json. Snippets of
+// organizations actor has left are not included. Snippets are read in
+// batches and the archive is written as it goes, so w can be the HTTP
+// response.
+func (s *SnippetService) ExportSnippets(ctx context.Context, actor *repositories.User, w io.Writer) (err error) {
+    ctx, span := startSpan(ctx, "SnippetService.ExportSnippets", attribute.String("user.name", actor.Username))
+    defer func() { endSpan(span, err) }()
+
+    if s.repo == nil {
+        return errors.New("repository is nil")
+    }
+
+    archive := zip.NewWriter(w)
+    manifest := ExportManifest{Version: 1, Username: actor.Username, ExportedAt: time.Now().UTC(), Snippets: []ExportedSnippet{}}
+    err = s.repo.FindByUsernameInBatches(ctx, actor.Username, exportBatchSize, func(batch []repositories.Snippet) error {
+        for _, snippet := range batch {
+            access, err := s.Access(ctx, actor.ID, &snippet)
+            if err != nil {
+                return err
+            }
+            if !access.View {
+                continue
+            }
+            entry := ExportedSnippet{
+                ID:          snippet.ID,
+                Title:       snippet.Title,
+                Description: snippet.Description,
+                Language:    snippet.Language,
+                Visibility:  snippet.Visibility,
+                File:        "snippets/" + exportFileName(snippet),
+                CreatedAt:   snippet.CreatedAt,
+                UpdatedAt:   snippet.UpdatedAt,
+            }
+            if snippet.Organization != nil {
+                entry.Organization = snippet.Organization.Slug
+            }
+            f, err := archive.CreateHeader(&zip.FileHeader{Name: entry.File, Method: zip.Deflate, Modified: snippet.UpdatedAt})
+            if err != nil {
+                return err
+            }
+            if _, err := io.WriteString(f, snippet.Content); err != nil {
+                return err
+            }
+            manifest.Snippets = append(manifest.Snippets, entry)
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    f, err := archive.Create(ExportManifestName)
+    if err != nil {
+        return err
+    }
+    enc := json.NewEncoder(f)
+    enc.SetIndent("", "  ")
+    if err := enc.Encode(manifest); err != nil {
+        return err
+    }
+    if err := archive.Close(); err != nil {
+        return err
+    }
+
+    s.audit.Record(ctx, repositories.AuditExportSnippets, actor, actor.Username, "")
+    return nil
+}
+
+// exportFileName is the snippet ID, which is unique, followed by a slug of
+// the title and the language's extension.
+func exportFileName(snippet repositories.Snippet) string {
+    ext, ok := languageExtensions[snippet.Language]
+    if !ok {
+        ext = ".txt"
+    }
+    name := snippet.ID
+    if slug := slugify(snippet.Title); slug != "" {
+        name += "-" + slug
+    }
+    return name + ext
+}
+
+// slugify keeps letters and digits, lowercased, and joins the words with
+// dashes. The result is at most 50 characters.
+func slugify(s string) string {
+    var b strings.Builder
+    dash := false
+    n := 0 // Characters written, not bytes, so multi-byte letters are never split
+    for _, r := range strings.ToLower(s) {
+        if n >= 50 {
+            break
+        }
+        switch {
+        case unicode.IsLetter(r) || unicode.IsDigit(r):
+            if dash && n > 0 {
+                if n+1 >= 50 {
+                    return b.String()
+                }
+                b.WriteByte('-')
+                n++
+            }
+            dash = false
+            b.WriteRune(r)
+            n++
+        default:
+            dash = true
+        }
+    }
+    return b.String()
+}
@@ -0,0 +1,376 @@
+package services
+
+import (
+    "archive/zip"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "path"
+    "sort"
+    "strings"
+    "unicode/utf8"
+
+    "go.opentelemetry.io/otel/attribute"
+    "snipetty.com/main/repositories"
+)
+
+var (
+    ErrUnsupportedImport = errors.New("Unsupported file: upload a snippet export zip, a Gist zip or Gist JSON")
+    ErrInvalidImport     = errors.New("Invalid import file")
+    ErrImportRejected    = errors.New("Nothing was imported because some files could not be read")
+)
+
+const (
+    // maxImportFiles, maxImportFileBytes and maxImportBytes bound the work a
+    // single upload can cause. Content is counted once unpacked, so they hold
+    // whatever the archive's compression ratio.
+    maxImportFiles     = 1000
+    maxImportFileBytes = 1 << 20
+    maxImportBytes     = 16 << 20
+
+    importDescription = "Imported from GitHub Gist"
+    unknownLanguage   = "language can not be told from the file extension"
+)
+
+// importExtensions maps file extensions to languages. It is the inverse of
+// languageExtensions plus a few common variants.
+var importExtensions = map[string]string{
+    ".py":  "Python",
+    ".js":  "Javascript",
+    ".mjs": "Javascript",
+    ".cjs": "Javascript",
+    ".jsx": "Javascript",
+    ".go":  "Go",
+    ".rs":  "Rust",
+    ".ts":  "Typescript",
+    ".tsx": "Typescript",
+}
+
+// Statuses of an ImportItem.
+const (
+    ImportImported = "imported"
+    ImportSkipped  = "skipped"
+    ImportFailed   = "failed"
+)
+
+type ImportItem struct {
+    File     string // Name in the upload
+    Title    string
+    Language string
+    Status   string
+    ID       string // Set once imported
+    Reason   string // Why the file was skipped or failed
+}
+
+// ImportReport lists every file in an upload and what happened to it.
+type ImportReport struct {
+    Format   string
+    Items    []ImportItem
+    Imported int
+    Skipped  int
+    Failed   int
+}
+
+// ImportSnippets creates snippets owned by actor from an upload, which may be
+// a zip written by ExportSnippets, a Gist zip download or a Gist as returned
+// by the GitHub API (a single object or an array). Files whose language can
+// not be told from the extension are skipped. If any file fails, or storing
+// fails, nothing is imported; the report says which files were at fault.
+func (s *SnippetService) ImportSnippets(ctx context.Context, actor *repositories.User, r io.ReaderAt, size int64) (_ *ImportReport, err error) {
+    ctx, span := startSpan(ctx, "SnippetService.ImportSnippets", attribute.String("user.name", actor.Username))
+    defer func() { endSpan(span, err) }()
+
+    if s.repo == nil {
+        return nil, errors.New("repository is nil")
+    }
+
+    var report *ImportReport
+    var requests []repositories.CreateSnippetRequest
+    magic := make([]byte, 4)
+    if _, err := r.ReadAt(magic, 0); err != nil && err != io.EOF {
+        return nil, err
+    }
+    switch {
+    case bytes.Equal(magic, []byte("PK\x03\x04")):
+        archive, err := zip.NewReader(r, size)
+        if err != nil {
+            return nil, ErrUnsupportedImport
+        }
+        report, requests, err = readImportArchive(archive)
+        if err != nil {
+            return nil, err
+        }
+    case bytes.HasPrefix(bytes.TrimLeft(magic, " \t\r\n"), []byte("{")) || bytes.HasPrefix(bytes.TrimLeft(magic, " \t\r\n"), []byte("[")):
+        report, requests, err = readGistJSON(io.NewSectionReader(r, 0, size))
+        if err != nil {
+            return nil, err
+        }
+    default:
+        return nil, ErrUnsupportedImport
+    }
+
+    if report.Failed > 0 {
+        return report, ErrImportRejected
+    }
+    if len(requests) == 0 {
+        return report, nil
+    }
+    for i := range requests {
+        requests[i].UID = fmt.Sprint(actor.ID)
+    }
+    ids, err := s.repo.CreateMany(ctx, requests)
+    if err != nil {
+        return nil, err
+    }
+
+    next := 0
+    for i := range report.Items {
+        if report.Items[i].Status == ImportImported {
+            report.Items[i].ID = ids[next]
+            next++
+        }
+    }
+    s.audit.Record(ctx, repositories.AuditImportSnippets, actor, actor.Username, fmt.Sprintf("%d snippets from %s", report.Imported, report.Format))
+    return report, nil
+}
+
+// importBudget is how many bytes of content an upload may still unpack.
+type importBudget int64
+
+// spend takes n bytes from the budget, and fails the upload once it is
+// overdrawn.
+func (b *importBudget) spend(n int) error {
+    *b -= importBudget(n)
+    if *b < 0 {
+        return fmt.Errorf("%w: the files add up to more than %d MiB; import them in parts", ErrInvalidImport, maxImportBytes>>20)
+    }
+    return nil
+}
+
+// add records an item and, when it is importable, the snippet to create.
+func (r *ImportReport) add(item ImportItem, request *repositories.CreateSnippetRequest, requests *[]repositories.CreateSnippetRequest) {
+    switch {
+    case item.Status == ImportSkipped:
+        r.Skipped++
+    case item.Reason != "":
+        item.Status = ImportFailed
+        r.Failed++
+    default:
+        item.Status = ImportImported
+        r.Imported++
+        *requests = append(*requests, *request)
+    }
+    r.Items = append(r.Items, item)
+}
+
+func readImportArchive(archive *zip.Reader) (*ImportReport, []repositories.CreateSnippetRequest, error) {
+    files := map[string]*zip.File{}
+    for _, f := range archive.File {
+        if !f.FileInfo().IsDir() {
+            files[f.Name] = f
+        }
+    }
+    if len(files) > maxImportFiles {
+        return nil, nil, fmt.Errorf("%w: the archive has %d files; at most %d can be imported at once", ErrInvalidImport, len(files), maxImportFiles)
+    }
+    budget := importBudget(maxImportBytes)
+    if manifest, ok := files[ExportManifestName]; ok {
+        return readExportArchive(manifest, files, &budget)
+    }
+
+    report := &ImportReport{Format: "Gist zip"}
+    var requests []repositories.CreateSnippetRequest
+    for _, f := range archive.File {
+        name := path.Base(f.Name)
+        if f.FileInfo().IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(f.Name, "__MACOSX/") {
+            continue
+        }
+        item := ImportItem{File: f.Name, Title: name}
+        request := repositories.CreateSnippetRequest{Title: name, Description: importDescription}
+        if language, ok := languageFromName(name); ok {
+            item.Language, request.Language = language, language
+            request.Content, item.Reason = readImportFile(f)
+            if err := budget.spend(len(request.Content)); err != nil {
+                return nil, nil, err
+            }
+        } else {
+            item.Status, item.Reason = ImportSkipped, unknownLanguage
+        }
+        report.add(item, &request, &requests)
+    }
+    return report, requests, nil
+}
+
+func readExportArchive(manifestFile *zip.File, files map[string]*zip.File, budget *importBudget) (*ImportReport, []repositories.CreateSnippetRequest, error) {
+    rc, err := manifestFile.Open()
+    if err != nil {
+        return nil, nil, ErrUnsupportedImport
+    }
+    defer rc.Close()
+    var manifest ExportManifest
+    if err := json.NewDecoder(io.LimitReader(rc, maxImportFileBytes)).Decode(&manifest); err != nil {
+        return nil, nil, fmt.Errorf("%w: %s: %v", ErrInvalidImport, ExportManifestName, err)
+    }
+    if len(manifest.Snippets) > maxImportFiles {
+        return nil, nil, fmt.Errorf("%w: the manifest lists %d snippets; at most %d can be imported at once", ErrInvalidImport, len(manifest.Snippets), maxImportFiles)
+    }
+
+    report := &ImportReport{Format: "snippet export"}
+    var requests []repositories.CreateSnippetRequest
+    for _, entry := range manifest.Snippets {
+        item := ImportItem{File: entry.File, Title: entry.Title, Language: entry.Language}
+        request := repositories.CreateSnippetRequest{Title: entry.Title, Description: entry.Description, Language: entry.Language}
+        if request.Description == "" {
+            request.Description = "Imported snippet"
+        }
+        f, ok := files[entry.File]
+        switch {
+        case entry.Visibility == repositories.VisibilityMembers:
+            // Imports are personal snippets, which are public
+            item.Status, item.Reason = ImportSkipped, "members-only snippets of "+entry.Organization+" are not imported"
+        case entry.Title == "" || entry.Language == "":
+            item.Reason = "title and language are required"
+        case !ok:
+            item.Reason = "file is missing from the archive"
+        default:
+            request.Content, item.Reason = readImportFile(f)
+            if err := budget.spend(len(request.Content)); err != nil {
+                return nil, nil, err
+            }
+        }
+        report.add(item, &request, &requests)
+    }
+    return report, requests, nil
+}
+
+// gist is the part of the GitHub API's gist object the importer reads.
+type gist struct {
+    Description string `json:"description"`
+    Files       map[string]struct {
+        Filename  string `json:"filename"`
+        Language  string `json:"language"`
+        Content   string `json:"content"`
+        Truncated bool   `json:"truncated"`
+    } `json:"files"`
+}
+
+func readGistJSON(r io.Reader) (*ImportReport, []repositories.CreateSnippetRequest, error) {
+    data, err := io.ReadAll(io.LimitReader(r, maxImportBytes+1))
+    if err != nil {
+        return nil, nil, err
+    }
+    budget := importBudget(maxImportBytes)
+    if err := budget.spend(len(data)); err != nil {
+        return nil, nil, err
+    }
+    var gists []gist
+    if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+        gists = make([]gist, 1)
+        err = json.Unmarshal(data, &gists[0])
+    } else {
+        err = json.Unmarshal(data, &gists)
+    }
+    if err != nil {
+        return nil, nil, fmt.Errorf("%w: Gist JSON: %v", ErrInvalidImport, err)
+    }
+
+    count := 0
+    for _, g := range gists {
+        count += len(g.Files)
+    }
+    if count > maxImportFiles {
+        return nil, nil, fmt.Errorf("%w: the gists have %d files; at most %d can be imported at once", ErrInvalidImport, count, maxImportFiles)
+    }
+
+    report := &ImportReport{Format: "Gist JSON"}
+    var requests []repositories.CreateSnippetRequest
+    for _, g := range gists {
+        description := g.Description
+        if description == "" {
+            description = importDescription
+        }
+        keys := make([]string, 0, len(g.Files))
+        for key := range g.Files {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        for _, key := range keys {
+            file := g.Files[key]
+            name := file.Filename
+            if name == "" {
+                name = key
+            }
+            item := ImportItem{File: name, Title: name}
+            request := repositories.CreateSnippetRequest{Title: name, Description: description, Content: file.Content}
+            language, ok := languageFromName(name)
+            if !ok {
+                // Fall back to the language GitHub detected
+                language, ok = knownLanguage(file.Language)
+            }
+            item.Language, request.Language = language, language
+            switch {
+            case !ok:
+                item.Status, item.Reason = ImportSkipped, unknownLanguage
+            case file.Truncated:
+                item.Reason = "content is truncated; download the gist as a zip instead"
+            default:
+                item.Reason = checkImportContent(file.Content)
+            }
+            report.add(item, &request, &requests)
+        }
+    }
+    return report, requests, nil
+}
+
+// languageFromName returns the language for a file name's extension.
+func languageFromName(name string) (string, bool) {
+    language, ok := importExtensions[strings.ToLower(path.Ext(name))]
+    return language, ok
+}
+
+// knownLanguage matches a language name such as GitHub's "JavaScript" to the
+// spelling used by the editor.
+func knownLanguage(name string) (string, bool) {
+    for language := range languageExtensions {
+        if strings.EqualFold(language, name) {
+            return language, true
+        }
+    }
+    return "", false
+}
+
+// readImportFile returns the content of f, or why it can not be imported.
+func readImportFile(f *zip.File) (string, string) {
+    if f.UncompressedSize64 > maxImportFileBytes {
+        return "", "file is larger than 1 MiB"
+    }
+    rc, err := f.Open()
+    if err != nil {
+        return "", err.Error()
+    }
+    defer rc.Close()
+    // The header's size can lie, so limit the read as well
+    data, err := io.ReadAll(io.LimitReader(rc, maxImportFileBytes+1))
+    if err != nil {
+        return "", err.Error()
+    }
+    if len(data) > maxImportFileBytes {
+        return "", "file is larger than 1 MiB"
+    }
+    return string(data), checkImportContent(string(data))
+}
+
+func checkImportContent(content string) string {
+    switch {
+    case strings.TrimSpace(content) == "":
+        return "file is empty"
+    case len(content) > maxImportFileBytes:
+        return "file is larger than 1 MiB"
+    case !utf8.ValidString(content):
+        return "file is not UTF-8 text"
+    }
+    return ""
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+    "context"
+    "time"
+
+    "snipetty.com/main/database"
+    "snipetty.com/main/preview"
+    "snipetty.com/main/repositories"
+)
+
+// The services depend on these interfaces rather than the GORM repositories
+// so they can be tested against in-memory fakes.
+
+type SnippetRepository interface {
+    Create(ctx context.Context, snippet *repositories.CreateSnippetRequest) (string, error)
+    CreateMany(ctx context.Context, snippets []repositories.CreateSnippetRequest) ([]string, error)
+    FindByLanguage(ctx context.Context, language string) ([]repositories.Snippet, error)
+    FindByUsername(ctx context.Context, username string) ([]repositories.Snippet, error)
+    FindByUsernameInBatches(ctx context.Context, username string, batchSize int, fn func([]repositories.Snippet) error) error
+    FindByOrganization(ctx context.Context, orgID uint, membersOnly bool) ([]repositories.Snippet, error)
+    FindRecent(ctx context.Context, limit int) ([]repositories.Snippet, error)
+    FindPublic(ctx context.Context, filter repositories.SnippetFilter, limit int) ([]repositories.Snippet, error)
+    FindByID(ctx context.Context, id string) (*repositories.Snippet, error)
+    Update(ctx context.Context, id string, snippet *repositories.CreateSnippetRequest) error
+    FindRevisions(ctx context.Context, id string) ([]repositories.SnippetRevision, error)
+    Delete(ctx context.Context, id string) error
+}
+
+type UserRepository interface {
+    Create(ctx context.Context, user *repositories.User) error
+    FindAll(ctx context.Context) ([]repositories.User, error)
+    FindByID(ctx context.Context, id uint) (*repositories.User, error)
+    FindByUsername(ctx context.Context, username string) (*repositories.User, error)
+    CountByRole(ctx context.Context, role repositories.Role) (int64, error)
+    FindByOIDCSubject(ctx context.Context, subject string) (*repositories.User, error)
+    Update(ctx context.Context, user *repositories.User) error
+    Delete(ctx context.Context, id uint) error
+    DeleteAccount(ctx context.Context, id uint, transferTo uint) error
+}
+
+type CollectionRepository interface {
+    Create(ctx context.Context, collection *repositories.Collection) error
+    FindByID(ctx context.Context, id uint) (*repositories.Collection, error)
+    FindByUserID(ctx context.Context, userID uint) ([]repositories.Collection, error)
+    Update(ctx context.Context, collection *repositories.Collection) error
+    Delete(ctx context.Context, id uint) error
+    AddSnippet(ctx context.Context, collectionID uint, snippetID string) error
+    RemoveSnippet(ctx context.Context, collectionID uint, snippetID string) error
+    Reorder(ctx context.Context, collectionID uint, snippetIDs []string) error
+}
+
+type OrganizationRepository interface {
+    Create(ctx context.Context, org *repositories.Organization, ownerID uint) error
+    FindBySlug(ctx context.Context, slug string) (*repositories.Organization, error)
+    FindAll(ctx context.Context) ([]repositories.Organization, error)
+    FindByUserID(ctx context.Context, userID uint) ([]repositories.Organization, error)
+    FindMember(ctx context.Context, orgID, userID uint) (*repositories.OrganizationMember, error)
+    AddMember(ctx context.Context, member *repositories.OrganizationMember) error
+    UpdateMember(ctx context.Context, member *repositories.OrganizationMember) error
+    RemoveMember(ctx context.Context, orgID, userID uint) error
+}
+
+type CollaboratorRepository interface {
+    FindBySnippet(ctx context.Context, snippetID string) ([]repositories.SnippetCollaborator, error)
+    Find(ctx context.Context, snippetID string, userID uint) (*repositories.SnippetCollaborator, error)
+    Save(ctx context.Context, collaborator *repositories.SnippetCollaborator) error
+    Remove(ctx context.Context, snippetID string, userID uint) error
+}
+
+// BackupStore writes and lists database backups.
+type BackupStore interface {
+    Create(ctx context.Context) (*database.BackupFile, error)
+    List() ([]database.BackupFile, error)
+    Path(name string) (string, error)
+}
+
+// PreviewStore renders link preview images of snippets and caches them.
+type PreviewStore interface {
+    Image(id string, version time.Time, card preview.Card) (string, error)
+    Invalidate(id string) error
+}
+
+type AuditRepository interface {
+    Create(ctx context.Context, event *repositories.AuditEvent) error
+    Find(ctx context.Context, filter repositories.AuditFilter) ([]repositories.AuditEvent, error)
+}
+
+var (
+    _ SnippetRepository      = (*repositories.SnippetRepository)(nil)
+    _ UserRepository         = (*repositories.UserRepository)(nil)
+    _ AuditRepository        = (*repositories.AuditRepository)(nil)
+    _ CollectionRepository   = (*repositories.CollectionRepository)(nil)
+    _ OrganizationRepository = (*repositories.OrganizationRepository)(nil)
+    _ CollaboratorRepository = (*repositories.CollaboratorRepository)(nil)
+    _ BackupStore            = (*database.Backups)(nil)
+    _ PreviewStore           = (*preview.Cache)(nil)
+)
@@ -0,0 +1,213 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/coreos/go-oidc/v3/oidc"
+    "golang.org/x/oauth2"
+    "gorm.io/gorm"
+    "snipetty.com/main/config"
+    "snipetty.com/main/repositories"
+)
+
+var (
+    ErrEmailNotVerified = errors.New("identity provider did not return a verified email")
+    ErrOIDCAccountTaken = errors.New("This identity provider account is linked to another user")
+)
+
+type OIDCService struct {
+    config   oauth2.Config
+    verifier *oidc.IDTokenVerifier
+    users    UserRepository
+    audit    *AuditService
+}
+
+// oidcClaims are the ID token claims used to link or provision a user.
+type oidcClaims struct {
+    Subject           string `json:"sub"`
+    Email             string `json:"email"`
+    EmailVerified     bool   `json:"email_verified"`
+    PreferredUsername string `json:"preferred_username"`
+}
+
+// NewOIDCService runs provider discovery against cfg.IssuerURL. Pass a context
+// built with oidc.ClientContext to use a custom HTTP client (e.g. for a mock
+// issuer in tests).
+func NewOIDCService(ctx context.Context, cfg config.OIDC, users UserRepository, audit *AuditService) (*OIDCService, error) {
+    provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+    if err != nil {
+        return nil, fmt.Errorf("oidc discovery: %w", err)
+    }
+
+    scopes := cfg.Scopes
+    if len(scopes) == 0 {
+        scopes = []string{"profile", "email"}
+    }
+    if !containsString(scopes, oidc.ScopeOpenID) {
+        scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+    }
+
+    return &OIDCService{
+        config: oauth2.Config{
+            ClientID:     cfg.ClientID,
+            ClientSecret: cfg.ClientSecret,
+            RedirectURL:  cfg.RedirectURL,
+            Endpoint:     provider.Endpoint(),
+            Scopes:       scopes,
+        },
+        verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+        users:    users,
+        audit:    audit,
+    }, nil
+}
+
+// AuthCodeURL returns the provider URL the browser is redirected to.
+func (s *OIDCService) AuthCodeURL(state, nonce string) string {
+    return s.config.AuthCodeURL(state, oidc.Nonce(nonce))
+}
+
+// Authenticate exchanges the authorization code, verifies the ID token and
+// returns the linked local user, provisioning one on first login.
+func (s *OIDCService) Authenticate(ctx context.Context, code, nonce string) (*repositories.User, error) {
+    claims, err := s.verify(ctx, code, nonce)
+    if err != nil {
+        return nil, err
+    }
+    user, err := s.findOrProvision(ctx, claims)
+    if err != nil {
+        return nil, err
+    }
+    if user.Disabled {
+        s.audit.Record(ctx, repositories.AuditLoginFailed, user, user.Username, "account disabled")
+        return nil, ErrAccountDisabled
+    }
+    s.audit.Record(ctx, repositories.AuditLogin, user, user.Username, "oidc")
+    return user, nil
+}
+
+// Link attaches the identity provider account that signed in to the local
+// user userID, who can then sign in either way. Local accounts have no
+// verified email to match, so this is the only way to link them.
+func (s *OIDCService) Link(ctx context.Context, userID uint, code, nonce string) (*repositories.User, error) {
+    claims, err := s.verify(ctx, code, nonce)
+    if err != nil {
+        return nil, err
+    }
+    user, err := s.users.FindByID(ctx, userID)
+    if err != nil {
+        return nil, err
+    }
+    linked, err := s.users.FindByOIDCSubject(ctx, claims.Subject)
+    if err == nil && linked.ID != user.ID {
+        return nil, ErrOIDCAccountTaken
+    }
+    if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, err
+    }
+
+    subject := claims.Subject
+    user.OIDCSubject = &subject
+    if err := s.users.Update(ctx, user); err != nil {
+        return nil, err
+    }
+    s.audit.Record(ctx, repositories.AuditLinkOIDC, user, user.Username, "")
+    return user, nil
+}
+
+// verify exchanges the authorization code and returns the claims of the ID
+// token, which must carry nonce.
+func (s *OIDCService) verify(ctx context.Context, code, nonce string) (oidcClaims, error) {
+    var claims oidcClaims
+    token, err := s.config.Exchange(ctx, code)
+    if err != nil {
+        return claims, fmt.Errorf("oidc code exchange: %w", err)
+    }
+    rawIDToken, ok := token.Extra("id_token").(string)
+    if !ok {
+        return claims, errors.New("oidc token response has no id_token")
+    }
+    idToken, err := s.verifier.Verify(ctx, rawIDToken)
+    if err != nil {
+        return claims, fmt.Errorf("oidc id_token: %w", err)
+    }
+    if idToken.Nonce != nonce {
+        return claims, errors.New("oidc nonce mismatch")
+    }
+    err = idToken.Claims(&claims)
+    return claims, err
+}
+
+// findOrProvision returns the user linked to the subject, creating one on
+// first login.
+func (s *OIDCService) findOrProvision(ctx context.Context, claims oidcClaims) (*repositories.User, error) {
+    user, err := s.users.FindByOIDCSubject(ctx, claims.Subject)
+    if err == nil {
+        return user, nil
+    }
+    if !errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, err
+    }
+
+    // Only keep an email the issuer vouches for
+    if claims.Email == "" || !claims.EmailVerified {
+        return nil, ErrEmailNotVerified
+    }
+
+    subject := claims.Subject
+    username, err := s.availableUsername(ctx, claims)
+    if err != nil {
+        return nil, err
+    }
+    // No password: the account can only sign in through the identity provider
+    user = &repositories.User{
+        Username:    username,
+        Email:       claims.Email,
+        OIDCSubject: &subject,
+        Role:        repositories.RoleUser,
+    }
+    if err := s.users.Create(ctx, user); err != nil {
+        return nil, err
+    }
+    s.audit.Record(ctx, repositories.AuditRegister, user, user.Username, "oidc")
+    return user, nil
+}
+
+// availableUsername derives a username from the claims, adding a numeric
+// suffix if it is already taken. Names that registration would refuse
+// become "user" instead.
+func (s *OIDCService) availableUsername(ctx context.Context, claims oidcClaims) (string, error) {
+    base := claims.PreferredUsername
+    if base == "" {
+        base, _, _ = strings.Cut(claims.Email, "@")
+    }
+    base = strings.ToLower(strings.TrimSpace(base))
+    if !usernamePattern.MatchString(base) {
+        base = "user"
+    }
+
+    candidate := base
+    // Suffixes must not make the name too long
+    base = base[:min(len(base), 28)]
+    for i := 2; ; i++ {
+        _, err := s.users.FindByUsername(ctx, candidate)
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return candidate, nil
+        }
+        if err != nil {
+            return "", err
+        }
+        candidate = fmt.Sprintf("%s%d", base, i)
+    }
+}
+
+func containsString(list []string, value string) bool {
+    for _, v := range list {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
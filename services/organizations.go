@@ -0,0 +1,217 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "regexp"
+
+    "gorm.io/gorm"
+    "snipetty.com/main/repositories"
+)
+
+var (
+    ErrNotOrgMember   = errors.New("You are not a member of this organization")
+    ErrNotOrgAdmin    = errors.New("Only organization owners and admins can manage members")
+    ErrNotOrgOwner    = errors.New("Only organization owners can add, change or remove owners")
+    ErrLastOwner      = errors.New("An organization needs at least one owner")
+    ErrInvalidOrgRole = errors.New("Role must be owner, admin or member")
+    ErrInvalidSlug    = errors.New("The URL name may only contain lowercase letters, digits and dashes")
+    ErrSlugTaken      = errors.New("An organization with this URL name already exists")
+    ErrUnknownMember  = errors.New("No user has that username")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// OrganizationPage is an organization as one viewer sees it.
+type OrganizationPage struct {
+    Organization *repositories.Organization
+    Snippets     []repositories.Snippet
+    Role         string // The viewer's role, empty for non-members
+}
+
+type OrganizationService struct {
+    repo     OrganizationRepository
+    users    UserRepository
+    snippets SnippetRepository
+    audit    *AuditService
+}
+
+func NewOrganizationService(repo OrganizationRepository, users UserRepository, snippets SnippetRepository, audit *AuditService) *OrganizationService {
+    return &OrganizationService{repo: repo, users: users, snippets: snippets, audit: audit}
+}
+
+// Create stores a new organization with actor as its owner.
+func (s *OrganizationService) Create(ctx context.Context, actor *repositories.User, input repositories.OrganizationInput) (*repositories.Organization, error) {
+    if !slugPattern.MatchString(input.Slug) {
+        return nil, ErrInvalidSlug
+    }
+    _, err := s.repo.FindBySlug(ctx, input.Slug)
+    if err == nil {
+        return nil, ErrSlugTaken
+    }
+    if !errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, err
+    }
+    org := &repositories.Organization{Slug: input.Slug, Name: input.Name, Description: input.Description}
+    if err := s.repo.Create(ctx, org, actor.ID); err != nil {
+        return nil, err
+    }
+    s.audit.Record(ctx, repositories.AuditCreateOrg, actor, org.Slug, org.Name)
+    return org, nil
+}
+
+func (s *OrganizationService) List(ctx context.Context) ([]repositories.Organization, error) {
+    return s.repo.FindAll(ctx)
+}
+
+// ListByUser returns the organizations userID belongs to.
+func (s *OrganizationService) ListByUser(ctx context.Context, userID uint) ([]repositories.Organization, error) {
+    return s.repo.FindByUserID(ctx, userID)
+}
+
+// Get returns an organization with the snippets viewerID may see; members
+// also see members-only snippets.
+func (s *OrganizationService) Get(ctx context.Context, viewerID uint, slug string) (*OrganizationPage, error) {
+    org, err := s.repo.FindBySlug(ctx, slug)
+    if err != nil {
+        return nil, err
+    }
+    page := &OrganizationPage{Organization: org}
+    if member := memberOf(org, viewerID); member != nil {
+        page.Role = member.Role
+    }
+    page.Snippets, err = s.snippets.FindByOrganization(ctx, org.ID, page.Role != "")
+    if err != nil {
+        return nil, err
+    }
+    return page, nil
+}
+
+func (s *OrganizationService) AddMember(ctx context.Context, actor *repositories.User, slug string, input repositories.MemberInput) error {
+    if !validOrgRole(input.Role) {
+        return ErrInvalidOrgRole
+    }
+    org, role, err := s.managed(ctx, actor, slug)
+    if err != nil {
+        return err
+    }
+    if input.Role == repositories.OrgRoleOwner && role != repositories.OrgRoleOwner {
+        return ErrNotOrgOwner
+    }
+    user, err := s.users.FindByUsername(ctx, input.Username)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return ErrUnknownMember
+    }
+    if err != nil {
+        return err
+    }
+    member := &repositories.OrganizationMember{OrganizationID: org.ID, UserID: user.ID, Role: input.Role}
+    if err := s.repo.AddMember(ctx, member); err != nil {
+        return err
+    }
+    s.audit.Record(ctx, repositories.AuditAddOrgMember, actor, org.Slug, fmt.Sprintf("%s as %s", user.Username, input.Role))
+    return nil
+}
+
+func (s *OrganizationService) SetMemberRole(ctx context.Context, actor *repositories.User, slug string, userID uint, role string) error {
+    if !validOrgRole(role) {
+        return ErrInvalidOrgRole
+    }
+    org, actorRole, err := s.managed(ctx, actor, slug)
+    if err != nil {
+        return err
+    }
+    member := memberOf(org, userID)
+    if member == nil {
+        return gorm.ErrRecordNotFound
+    }
+    if (member.Role == repositories.OrgRoleOwner || role == repositories.OrgRoleOwner) && actorRole != repositories.OrgRoleOwner {
+        return ErrNotOrgOwner
+    }
+    if member.Role == repositories.OrgRoleOwner && role != repositories.OrgRoleOwner && owners(org) == 1 {
+        return ErrLastOwner
+    }
+    previous := member.Role
+    member.Role = role
+    if err := s.repo.UpdateMember(ctx, member); err != nil {
+        return err
+    }
+    s.audit.Record(ctx, repositories.AuditSetOrgRole, actor, org.Slug, fmt.Sprintf("%s: %s -> %s", member.User.Username, previous, role))
+    return nil
+}
+
+// RemoveMember takes userID out of the organization. Members may always
+// leave; removing someone else takes an owner or admin.
+func (s *OrganizationService) RemoveMember(ctx context.Context, actor *repositories.User, slug string, userID uint) error {
+    org, err := s.repo.FindBySlug(ctx, slug)
+    if err != nil {
+        return err
+    }
+    member := memberOf(org, userID)
+    if member == nil {
+        return gorm.ErrRecordNotFound
+    }
+    if actor.ID != userID {
+        actorMember := memberOf(org, actor.ID)
+        if actorMember == nil || actorMember.Role == repositories.OrgRoleMember {
+            return ErrNotOrgAdmin
+        }
+        if member.Role == repositories.OrgRoleOwner && actorMember.Role != repositories.OrgRoleOwner {
+            return ErrNotOrgOwner
+        }
+    }
+    if member.Role == repositories.OrgRoleOwner && owners(org) == 1 {
+        return ErrLastOwner
+    }
+    if err := s.repo.RemoveMember(ctx, org.ID, userID); err != nil {
+        return err
+    }
+    s.audit.Record(ctx, repositories.AuditRemoveOrgMember, actor, org.Slug, member.User.Username)
+    return nil
+}
+
+// managed loads the organization and actor's role in it, or returns
+// ErrNotOrgAdmin unless actor is one of its owners or admins.
+func (s *OrganizationService) managed(ctx context.Context, actor *repositories.User, slug string) (*repositories.Organization, string, error) {
+    org, err := s.repo.FindBySlug(ctx, slug)
+    if err != nil {
+        return nil, "", err
+    }
+    member := memberOf(org, actor.ID)
+    if member == nil || member.Role == repositories.OrgRoleMember {
+        return nil, "", ErrNotOrgAdmin
+    }
+    return org, member.Role, nil
+}
+
+func memberOf(org *repositories.Organization, userID uint) *repositories.OrganizationMember {
+    if userID == 0 {
+        return nil
+    }
+    for i := range org.Members {
+        if org.Members[i].UserID == userID {
+            return &org.Members[i]
+        }
+    }
+    return nil
+}
+
+func owners(org *repositories.Organization) int {
+    n := 0
+    for _, member := range org.Members {
+        if member.Role == repositories.OrgRoleOwner {
+            n++
+        }
+    }
+    return n
+}
+
+func validOrgRole(role string) bool {
+    for _, r := range repositories.OrgRoles {
+        if r == role {
+            return true
+        }
+    }
+    return false
+}
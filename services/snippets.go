@@ -1,50 +1,142 @@
 package services
 
 import (
+    "context"
     "errors"
+    "fmt"
+    "log/slog"
+
+    "go.opentelemetry.io/otel/attribute"
+    "gorm.io/gorm"
+    "snipetty.com/main/preview"
     "snipetty.com/main/repositories"
 )
 
+var (
+    // ErrNotSnippetOwner is returned when someone without the access tries to
+    // change a snippet (see SnippetAccess). Moderators remove snippets through
+    // AdminService instead.
+    ErrNotSnippetOwner         = errors.New("Not authorized to change this snippet")
+    ErrSnippetVisibility       = errors.New("Visibility must be public, or members for organization snippets")
+    ErrInvalidCollaboratorRole = errors.New("Role must be viewer or editor")
+    ErrCollaboratorIsAuthor    = errors.New("The author already has full access")
+    ErrViewerNeedsOrganization = errors.New("Personal snippets are public; viewers can only be added to organization snippets")
+    ErrPreviewsDisabled        = errors.New("Link previews are disabled")
+)
+
 type LanguageSnippets struct {
     Language string                 // The language name (e.g., "Python", "Go").
     Snippets []repositories.Snippet // The list of snippets for this language.
 }
 
 type SnippetService struct {
-    repo *repositories.SnippetRepository
+    repo     SnippetRepository
+    users    UserRepository
+    grants   Grants
+    audit    *AuditService
+    previews PreviewStore // Nil disables link preview images
 }
 
-func NewSnippetService(repo *repositories.SnippetRepository) *SnippetService {
-    return &SnippetService{repo: repo}
+func NewSnippetService(repo SnippetRepository, users UserRepository, grants Grants, audit *AuditService, previews PreviewStore) *SnippetService {
+    return &SnippetService{repo: repo, users: users, grants: grants, audit: audit, previews: previews}
 }
 
-func (s *SnippetService) CreateSnippet(input *repositories.CreateSnippetRequest) (string, error) {
+// CreateSnippet stores a new snippet written by actor, owned by the
+// organization in input if there is one. Only its members may do so.
+func (s *SnippetService) CreateSnippet(ctx context.Context, actor *repositories.User, input *repositories.CreateSnippetRequest) (_ string, err error) {
+    ctx, span := startSpan(ctx, "SnippetService.CreateSnippet")
+    defer func() { endSpan(span, err) }()
+
     if s.repo == nil {
         return "", errors.New("repository is nil")
     }
-    id, err := s.repo.Create(input)
-    return id, err
+    if err := checkVisibility(input.OrganizationID != 0, input.Visibility); err != nil {
+        return "", err
+    }
+    if input.OrganizationID != 0 {
+        if _, err := s.grants.member(ctx, input.OrganizationID, actor.ID); err != nil {
+            return "", err
+        }
+    }
+    input.UID = fmt.Sprintf("%d", actor.ID)
+    id, err := s.repo.Create(ctx, input)
+    if err != nil {
+        return "", err
+    }
+    s.audit.Record(ctx, repositories.AuditCreateSnippet, actor, id, input.Title)
+    return id, nil
 }
 
-func (s *SnippetService) GetSnippetByID(id string) (*repositories.Snippet, error) {
+// GetSnippetByID returns a snippet viewerID may see; viewerID is 0 for
+// guests. Hidden snippets are reported as gorm.ErrRecordNotFound.
+func (s *SnippetService) GetSnippetByID(ctx context.Context, viewerID uint, id string) (_ *repositories.Snippet, err error) {
+    ctx, span := startSpan(ctx, "SnippetService.GetSnippetByID", attribute.String("snippet.id", id))
+    defer func() { endSpan(span, err) }()
+
     if s.repo == nil {
         return nil, errors.New("repository is nil")
     }
-    return s.repo.FindByID(id)
+    return s.visible(ctx, viewerID, id)
+}
+
+// Access reports what viewerID may do with snippet; viewerID is 0 for guests.
+func (s *SnippetService) Access(ctx context.Context, viewerID uint, snippet *repositories.Snippet) (SnippetAccess, error) {
+    return s.grants.access(ctx, viewerID, snippet)
 }
 
-func (s *SnippetService) UpdateSnippet(id string, input repositories.CreateSnippetRequest) (error) {
+func (s *SnippetService) UpdateSnippet(ctx context.Context, actor *repositories.User, id string, input repositories.CreateSnippetRequest) (err error) {
+    ctx, span := startSpan(ctx, "SnippetService.UpdateSnippet", attribute.String("snippet.id", id))
+    defer func() { endSpan(span, err) }()
+
     if s.repo == nil {
         return errors.New("repository is nil")
     }
-    return s.repo.Update(id, &input)
+    snippet, err := s.authorize(ctx, actor, id, func(a SnippetAccess) bool { return a.Edit })
+    if err != nil {
+        return err
+    }
+    if input.Visibility != "" && input.Visibility != snippet.Visibility {
+        if err := checkVisibility(snippet.OrganizationID != nil, input.Visibility); err != nil {
+            return err
+        }
+        // Deciding who sees the snippet takes the same access as deleting it
+        access, err := s.Access(ctx, actor.ID, snippet)
+        if err != nil {
+            return err
+        }
+        if !access.Delete {
+            return ErrNotSnippetOwner
+        }
+    }
+    input.EditorID = actor.ID
+    if err := s.repo.Update(ctx, id, &input); err != nil {
+        return err
+    }
+    s.invalidatePreview(ctx, id)
+    s.audit.Record(ctx, repositories.AuditUpdateSnippet, actor, id, input.Title)
+    return nil
+}
+
+// Revisions lists who saved the snippet and when, newest first, to those
+// who may view it.
+func (s *SnippetService) Revisions(ctx context.Context, viewerID uint, id string) (_ []repositories.SnippetRevision, err error) {
+    ctx, span := startSpan(ctx, "SnippetService.Revisions", attribute.String("snippet.id", id))
+    defer func() { endSpan(span, err) }()
+
+    if _, err := s.visible(ctx, viewerID, id); err != nil {
+        return nil, err
+    }
+    return s.repo.FindRevisions(ctx, id)
 }
 
-func (s *SnippetService) GetSnippetsByLanguage(languages []string) ([]LanguageSnippets, error) {
+func (s *SnippetService) GetSnippetsByLanguage(ctx context.Context, languages []string) (_ []LanguageSnippets, err error) {
+    ctx, span := startSpan(ctx, "SnippetService.GetSnippetsByLanguage", attribute.StringSlice("snippet.languages", languages))
+    defer func() { endSpan(span, err) }()
+
     groupedSnippets := []LanguageSnippets{}
 
     for _, lang := range languages {
-        snippets, err := s.repo.FindByLanguage(lang)
+        snippets, err := s.repo.FindByLanguage(ctx, lang)
         if err != nil {
             return nil, err
         }
@@ -57,16 +149,144 @@ func (s *SnippetService) GetSnippetsByLanguage(languages []string) ([]LanguageSn
     return groupedSnippets, nil
 }
 
-func (s *SnippetService) GetSnippetsByUsername(username string) ([]repositories.Snippet, error) {
+func (s *SnippetService) GetSnippetsByUsername(ctx context.Context, username string) (_ []repositories.Snippet, err error) {
+    ctx, span := startSpan(ctx, "SnippetService.GetSnippetsByUsername", attribute.String("user.name", username))
+    defer func() { endSpan(span, err) }()
+
     if s.repo == nil {
         return nil, errors.New("repository is nil")
     }
-    return s.repo.FindByUsername(username)
+    return s.repo.FindByUsername(ctx, username)
+}
+
+// FeedSize is how many snippets a feed lists.
+const FeedSize = 50
+
+// Feed returns the newest public snippets matching filter. A feed of an
+// unknown user is gorm.ErrRecordNotFound rather than empty, so readers
+// subscribed to a mistyped name find out.
+func (s *SnippetService) Feed(ctx context.Context, filter repositories.SnippetFilter) (_ []repositories.Snippet, err error) {
+    ctx, span := startSpan(ctx, "SnippetService.Feed", attribute.String("user.name", filter.Username), attribute.String("snippet.language", filter.Language))
+    defer func() { endSpan(span, err) }()
+
+    if filter.Username != "" {
+        if _, err := s.users.FindByUsername(ctx, filter.Username); err != nil {
+            return nil, err
+        }
+    }
+    return s.repo.FindPublic(ctx, filter, FeedSize)
 }
 
-func (s *SnippetService) DeleteSnippet(id string) error {
+func (s *SnippetService) DeleteSnippet(ctx context.Context, actor *repositories.User, id string) (err error) {
+    ctx, span := startSpan(ctx, "SnippetService.DeleteSnippet", attribute.String("snippet.id", id))
+    defer func() { endSpan(span, err) }()
+
     if s.repo == nil {
         return errors.New("repository is nil")
     }
-    return s.repo.Delete(id)
-}
\ No newline at end of file
+    if _, err := s.authorize(ctx, actor, id, func(a SnippetAccess) bool { return a.Delete }); err != nil {
+        return err
+    }
+    if err := s.repo.Delete(ctx, id); err != nil {
+        return err
+    }
+    s.invalidatePreview(ctx, id)
+    s.audit.Record(ctx, repositories.AuditDeleteSnippet, actor, id, "")
+    return nil
+}
+
+// HasPreviews reports whether snippets have link preview images.
+func (s *SnippetService) HasPreviews() bool {
+    return s.previews != nil
+}
+
+// PreviewImage returns the file of the snippet's link preview image,
+// rendering it if needed. Only public snippets have one, as sites unfurling
+// a link see it as a guest.
+func (s *SnippetService) PreviewImage(ctx context.Context, id string) (_ string, err error) {
+    ctx, span := startSpan(ctx, "SnippetService.PreviewImage", attribute.String("snippet.id", id))
+    defer func() { endSpan(span, err) }()
+
+    if s.previews == nil {
+        return "", ErrPreviewsDisabled
+    }
+    snippet, err := s.GetSnippetByID(ctx, 0, id)
+    if err != nil {
+        return "", err
+    }
+    return s.previews.Image(snippet.ID, snippet.UpdatedAt, preview.Card{
+        Title:    snippet.Title,
+        Author:   snippet.User.Username,
+        Language: snippet.Language,
+        Code:     snippet.Content,
+    })
+}
+
+// invalidatePreview drops the cached preview of a changed snippet. Failing
+// is not fatal: previews are keyed by the snippet's update time anyway.
+func (s *SnippetService) invalidatePreview(ctx context.Context, id string) {
+    if s.previews == nil {
+        return
+    }
+    if err := s.previews.Invalidate(id); err != nil {
+        slog.WarnContext(ctx, "invalidate snippet preview", "snippet", id, "error", err)
+    }
+}
+
+// visible loads the snippet, or returns gorm.ErrRecordNotFound when viewerID
+// may not see it.
+func (s *SnippetService) visible(ctx context.Context, viewerID uint, id string) (*repositories.Snippet, error) {
+    snippet, err := s.repo.FindByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    access, err := s.Access(ctx, viewerID, snippet)
+    if err != nil {
+        return nil, err
+    }
+    if !access.View {
+        return nil, gorm.ErrRecordNotFound
+    }
+    return snippet, nil
+}
+
+// authorize loads the snippet and returns it when allowed reports that
+// actor's access is enough. It returns gorm.ErrRecordNotFound when actor may
+// not see the snippet, and ErrNotSnippetOwner when they see it but allowed
+// refuses.
+func (s *SnippetService) authorize(ctx context.Context, actor *repositories.User, id string, allowed func(SnippetAccess) bool) (*repositories.Snippet, error) {
+    var actorID uint
+    if actor != nil {
+        actorID = actor.ID
+    }
+    snippet, err := s.repo.FindByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    access, err := s.Access(ctx, actorID, snippet)
+    if err != nil {
+        return nil, err
+    }
+    // Snippets the actor may not see do not exist for them
+    if !access.View {
+        return nil, gorm.ErrRecordNotFound
+    }
+    if actor == nil || !allowed(access) {
+        return nil, ErrNotSnippetOwner
+    }
+    return snippet, nil
+}
+
+// checkVisibility allows public snippets, and members-only ones when they
+// belong to an organization. Empty means public.
+func checkVisibility(inOrg bool, visibility string) error {
+    switch visibility {
+    case "", repositories.VisibilityPublic:
+        return nil
+    case repositories.VisibilityMembers:
+        if inOrg {
+            return nil
+        }
+    }
+    return ErrSnippetVisibility
+}
@@ -0,0 +1,528 @@
+package services_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"gorm.io/gorm"
+	"snipetty.com/main/repositories"
+	"snipetty.com/main/services"
+)
+
+// fakeSnippets is an in-memory services.SnippetRepository.
+type fakeSnippets struct {
+	snippets map[string]*repositories.Snippet
+	users    map[string]repositories.User
+	next     int
+}
+
+func newFakeSnippets(users ...repositories.User) *fakeSnippets {
+	f := &fakeSnippets{snippets: map[string]*repositories.Snippet{}, users: map[string]repositories.User{}}
+	for _, u := range users {
+		f.users[fmt.Sprint(u.ID)] = u
+	}
+	return f
+}
+
+func (f *fakeSnippets) Create(ctx context.Context, in *repositories.CreateSnippetRequest) (string, error) {
+	user, ok := f.users[in.UID]
+	if !ok {
+		return "", gorm.ErrRecordNotFound
+	}
+	f.next++
+	id := fmt.Sprintf("%s-%d", user.Username, f.next)
+	f.snippets[id] = &repositories.Snippet{ID: id, UserID: user.ID, User: user, Title: in.Title, Content: in.Content, Language: in.Language, Description: in.Description, Visibility: in.Visibility}
+	if in.OrganizationID != 0 {
+		orgID := in.OrganizationID
+		f.snippets[id].OrganizationID = &orgID
+	}
+	return id, nil
+}
+
+// fakeOrgs answers membership lookups; the other methods are not used by
+// SnippetService.
+type fakeOrgs struct {
+	services.OrganizationRepository
+	roles map[[2]uint]string // {organization, user} -> role
+}
+
+func (f *fakeOrgs) FindMember(ctx context.Context, orgID, userID uint) (*repositories.OrganizationMember, error) {
+	role, ok := f.roles[[2]uint{orgID, userID}]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &repositories.OrganizationMember{OrganizationID: orgID, UserID: userID, Role: role}, nil
+}
+
+func (f *fakeSnippets) CreateMany(ctx context.Context, in []repositories.CreateSnippetRequest) ([]string, error) {
+	var ids []string
+	for i := range in {
+		id, err := f.Create(ctx, &in[i])
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (f *fakeSnippets) find(match func(*repositories.Snippet) bool) []repositories.Snippet {
+	var out []repositories.Snippet
+	for _, s := range f.snippets {
+		if match(s) {
+			out = append(out, *s)
+		}
+	}
+	return out
+}
+
+func (f *fakeSnippets) FindByLanguage(ctx context.Context, language string) ([]repositories.Snippet, error) {
+	return f.find(func(s *repositories.Snippet) bool { return s.Language == language }), nil
+}
+
+func (f *fakeSnippets) FindByUsername(ctx context.Context, username string) ([]repositories.Snippet, error) {
+	return f.find(func(s *repositories.Snippet) bool { return s.User.Username == username }), nil
+}
+
+func (f *fakeSnippets) FindByUsernameInBatches(ctx context.Context, username string, batchSize int, fn func([]repositories.Snippet) error) error {
+	all, _ := f.FindByUsername(ctx, username)
+	for len(all) > 0 {
+		n := min(batchSize, len(all))
+		if err := fn(all[:n]); err != nil {
+			return err
+		}
+		all = all[n:]
+	}
+	return nil
+}
+
+func (f *fakeSnippets) FindByOrganization(ctx context.Context, orgID uint, membersOnly bool) ([]repositories.Snippet, error) {
+	return f.find(func(s *repositories.Snippet) bool {
+		return s.OrganizationID != nil && *s.OrganizationID == orgID && (membersOnly || s.Visibility != repositories.VisibilityMembers)
+	}), nil
+}
+
+func (f *fakeSnippets) FindPublic(ctx context.Context, filter repositories.SnippetFilter, limit int) ([]repositories.Snippet, error) {
+	all := f.find(func(s *repositories.Snippet) bool {
+		return s.Visibility != repositories.VisibilityMembers &&
+			(filter.Username == "" || s.User.Username == filter.Username) &&
+			(filter.Language == "" || s.Language == filter.Language)
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].CreatedAt.After(all[j].CreatedAt) })
+	if limit > 0 && len(all) > limit {
+		all = all[:limit]
+	}
+	return all, nil
+}
+
+func (f *fakeSnippets) FindRecent(ctx context.Context, limit int) ([]repositories.Snippet, error) {
+	all := f.find(func(*repositories.Snippet) bool { return true })
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	return all, nil
+}
+
+func (f *fakeSnippets) FindByID(ctx context.Context, id string) (*repositories.Snippet, error) {
+	s, ok := f.snippets[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	snippet := *s
+	return &snippet, nil
+}
+
+func (f *fakeSnippets) Update(ctx context.Context, id string, in *repositories.CreateSnippetRequest) error {
+	s, ok := f.snippets[id]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	s.Title, s.Content, s.Language, s.Description = in.Title, in.Content, in.Language, in.Description
+	return nil
+}
+
+func (f *fakeSnippets) FindRevisions(ctx context.Context, id string) ([]repositories.SnippetRevision, error) {
+	return nil, nil
+}
+
+func (f *fakeSnippets) Delete(ctx context.Context, id string) error {
+	if _, ok := f.snippets[id]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	delete(f.snippets, id)
+	return nil
+}
+
+// fakeAudit records audit events in memory.
+type fakeAudit struct {
+	events []repositories.AuditEvent
+}
+
+func (f *fakeAudit) Create(ctx context.Context, event *repositories.AuditEvent) error {
+	f.events = append(f.events, *event)
+	return nil
+}
+
+func (f *fakeAudit) Find(context.Context, repositories.AuditFilter) ([]repositories.AuditEvent, error) {
+	return f.events, nil
+}
+
+var (
+	alice = repositories.User{ID: 1, Username: "alice", Role: repositories.RoleUser}
+	bob   = repositories.User{ID: 2, Username: "bob", Role: repositories.RoleUser}
+)
+
+func input(title string) repositories.CreateSnippetRequest {
+	return repositories.CreateSnippetRequest{Title: title, Content: "fmt.Println()", Language: "Go", Description: "d"}
+}
+
+func TestSnippetServiceCreate(t *testing.T) {
+	repo := newFakeSnippets(alice)
+	audit := &fakeAudit{}
+	svc := services.NewSnippetService(repo, nil, services.Grants{}, services.NewAuditService(audit), nil)
+
+	// UID comes from the actor, whatever the form said
+	in := input("hello")
+	in.UID = "99"
+	id, err := svc.CreateSnippet(context.Background(), &alice, &in)
+	if err != nil {
+		t.Fatalf("CreateSnippet: %v", err)
+	}
+	snippet, err := svc.GetSnippetByID(context.Background(), alice.ID, id)
+	if err != nil {
+		t.Fatalf("GetSnippetByID: %v", err)
+	}
+	if snippet.UserID != alice.ID || snippet.Title != "hello" {
+		t.Errorf("snippet = %+v, want title hello owned by alice", snippet)
+	}
+	if len(audit.events) != 1 || audit.events[0].Action != repositories.AuditCreateSnippet || audit.events[0].Target != id {
+		t.Errorf("audit events = %+v, want one create_snippet for %s", audit.events, id)
+	}
+}
+
+func TestSnippetServiceOwnership(t *testing.T) {
+	tests := []struct {
+		name    string
+		actor   *repositories.User
+		id      string
+		wantErr error
+	}{
+		{"owner", &alice, "alice-1", nil},
+		{"other user", &bob, "alice-1", services.ErrNotSnippetOwner},
+		{"no actor", nil, "alice-1", services.ErrNotSnippetOwner},
+		{"missing snippet", &alice, "alice-404", gorm.ErrRecordNotFound},
+	}
+	operations := []struct {
+		name   string
+		run    func(*services.SnippetService, *repositories.User, string) error
+		action string
+	}{
+		{"update", func(s *services.SnippetService, actor *repositories.User, id string) error {
+			return s.UpdateSnippet(context.Background(), actor, id, input("changed"))
+		}, repositories.AuditUpdateSnippet},
+		{"delete", func(s *services.SnippetService, actor *repositories.User, id string) error {
+			return s.DeleteSnippet(context.Background(), actor, id)
+		}, repositories.AuditDeleteSnippet},
+	}
+
+	for _, op := range operations {
+		for _, tt := range tests {
+			t.Run(op.name+"/"+tt.name, func(t *testing.T) {
+				repo := newFakeSnippets(alice, bob)
+				audit := &fakeAudit{}
+				svc := services.NewSnippetService(repo, nil, services.Grants{}, services.NewAuditService(audit), nil)
+				in := input("original")
+				if _, err := svc.CreateSnippet(context.Background(), &alice, &in); err != nil {
+					t.Fatalf("CreateSnippet: %v", err)
+				}
+				audit.events = nil
+
+				err := op.run(svc, tt.actor, tt.id)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+
+				snippet, findErr := repo.FindByID(context.Background(), "alice-1")
+				switch {
+				case tt.wantErr != nil && (findErr != nil || snippet.Title != "original"):
+					t.Errorf("snippet changed by a rejected %s", op.name)
+				case tt.wantErr == nil && op.name == "delete" && findErr == nil:
+					t.Errorf("snippet still exists after delete")
+				case tt.wantErr == nil && op.name == "update" && snippet.Title != "changed":
+					t.Errorf("title = %q, want changed", snippet.Title)
+				}
+
+				wantEvents := 0
+				if tt.wantErr == nil {
+					wantEvents = 1
+				}
+				if len(audit.events) != wantEvents {
+					t.Fatalf("got %d audit events, want %d", len(audit.events), wantEvents)
+				}
+				if wantEvents == 1 && audit.events[0].Action != op.action {
+					t.Errorf("audit action = %q, want %q", audit.events[0].Action, op.action)
+				}
+			})
+		}
+	}
+}
+
+func TestSnippetServiceGetSnippetsByLanguage(t *testing.T) {
+	repo := newFakeSnippets(alice)
+	svc := services.NewSnippetService(repo, nil, services.Grants{}, nil, nil)
+	for _, lang := range []string{"Go", "Go", "Rust"} {
+		in := input("x")
+		in.Language = lang
+		if _, err := svc.CreateSnippet(context.Background(), &alice, &in); err != nil {
+			t.Fatalf("CreateSnippet: %v", err)
+		}
+	}
+
+	groups, err := svc.GetSnippetsByLanguage(context.Background(), []string{"Go", "Python", "Rust"})
+	if err != nil {
+		t.Fatalf("GetSnippetsByLanguage: %v", err)
+	}
+	want := map[string]int{"Go": 2, "Python": 0, "Rust": 1}
+	if len(groups) != len(want) {
+		t.Fatalf("got %d groups, want %d", len(groups), len(want))
+	}
+	for _, g := range groups {
+		if len(g.Snippets) != want[g.Language] {
+			t.Errorf("%s: got %d snippets, want %d", g.Language, len(g.Snippets), want[g.Language])
+		}
+	}
+}
+
+func TestSnippetServiceExportSnippets(t *testing.T) {
+	repo := newFakeSnippets(alice, bob)
+	audit := &fakeAudit{}
+	svc := services.NewSnippetService(repo, nil, services.Grants{}, services.NewAuditService(audit), nil)
+	// More than one batch
+	for i := 0; i < 150; i++ {
+		in := input(fmt.Sprintf("Snippet #%d", i))
+		if i%2 == 1 {
+			in.Language = "Haskell"
+		}
+		if _, err := svc.CreateSnippet(context.Background(), &alice, &in); err != nil {
+			t.Fatalf("CreateSnippet: %v", err)
+		}
+	}
+	in := input("bob's")
+	if _, err := svc.CreateSnippet(context.Background(), &bob, &in); err != nil {
+		t.Fatalf("CreateSnippet: %v", err)
+	}
+	audit.events = nil
+
+	var buf bytes.Buffer
+	if err := svc.ExportSnippets(context.Background(), &alice, &buf); err != nil {
+		t.Fatalf("ExportSnippets: %v", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("not a zip: %v", err)
+	}
+	if len(archive.File) != 151 {
+		t.Fatalf("archive has %d files, want 150 snippets and the manifest", len(archive.File))
+	}
+	names := map[string]bool{}
+	for _, f := range archive.File {
+		names[f.Name] = true
+	}
+	for _, want := range []string{"snippets/alice-1-snippet-0.go", "snippets/alice-2-snippet-1.txt", services.ExportManifestName} {
+		if !names[want] {
+			t.Errorf("archive is missing %s", want)
+		}
+	}
+	if len(audit.events) != 1 || audit.events[0].Action != repositories.AuditExportSnippets {
+		t.Errorf("audit events = %+v, want one export_snippets", audit.events)
+	}
+}
+
+func TestSnippetServiceImportSnippets(t *testing.T) {
+	gistZip := func(files map[string]string) []byte {
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		for name, content := range files {
+			f, _ := w.Create("0123abcd-main/" + name)
+			f.Write([]byte(content))
+		}
+		w.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name         string
+		upload       []byte
+		wantErr      error
+		wantFormat   string
+		wantImported int
+		wantSkipped  int
+		wantFailed   int
+	}{
+		{
+			name:         "gist json",
+			upload:       []byte(`{"description": "Sorting", "files": {"sort.py": {"filename": "sort.py", "language": "Python", "content": "sorted(x)"}, "README.md": {"filename": "README.md", "language": "Markdown", "content": "# Sort"}}}`),
+			wantFormat:   "Gist JSON",
+			wantImported: 1,
+			wantSkipped:  1,
+		},
+		{
+			name:         "gist json array with detected language",
+			upload:       []byte(`[{"files": {"a.go": {"content": "package a"}}}, {"files": {"snippet.txt": {"language": "Rust", "content": "fn main() {}"}}}]`),
+			wantFormat:   "Gist JSON",
+			wantImported: 2,
+		},
+		{
+			name:         "gist zip",
+			upload:       gistZip(map[string]string{"main.go": "package main", "app.tsx": "export {}", "notes.md": "hi"}),
+			wantFormat:   "Gist zip",
+			wantImported: 2,
+			wantSkipped:  1,
+		},
+		{
+			name:         "empty file rejects the import",
+			upload:       gistZip(map[string]string{"main.go": "package main", "empty.py": "  "}),
+			wantErr:      services.ErrImportRejected,
+			wantFormat:   "Gist zip",
+			wantFailed:   1,
+			wantImported: 1,
+		},
+		{
+			name:    "invalid json",
+			upload:  []byte(`{"files": `),
+			wantErr: services.ErrInvalidImport,
+		},
+		{
+			name:    "unsupported",
+			upload:  []byte("plain text"),
+			wantErr: services.ErrUnsupportedImport,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeSnippets(alice)
+			svc := services.NewSnippetService(repo, nil, services.Grants{}, nil, nil)
+
+			report, err := svc.ImportSnippets(context.Background(), &alice, bytes.NewReader(tt.upload), int64(len(tt.upload)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantFormat == "" {
+				return
+			}
+			if report.Format != tt.wantFormat || report.Imported != tt.wantImported || report.Skipped != tt.wantSkipped || report.Failed != tt.wantFailed {
+				t.Errorf("report = %s %d/%d/%d, want %s %d/%d/%d imported/skipped/failed", report.Format,
+					report.Imported, report.Skipped, report.Failed, tt.wantFormat, tt.wantImported, tt.wantSkipped, tt.wantFailed)
+			}
+			stored := len(repo.snippets)
+			if tt.wantErr != nil && stored != 0 {
+				t.Errorf("%d snippets stored by a rejected import", stored)
+			}
+			if tt.wantErr == nil && stored != tt.wantImported {
+				t.Errorf("%d snippets stored, want %d", stored, tt.wantImported)
+			}
+		})
+	}
+}
+
+// fakeCollaborators answers collaborator lookups by {snippet, user}.
+type fakeCollaborators struct {
+	services.CollaboratorRepository
+	roles map[string]string // "snippet/user" -> role
+}
+
+func (f *fakeCollaborators) Find(ctx context.Context, snippetID string, userID uint) (*repositories.SnippetCollaborator, error) {
+	role, ok := f.roles[fmt.Sprintf("%s/%d", snippetID, userID)]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &repositories.SnippetCollaborator{SnippetID: snippetID, UserID: userID, Role: role}, nil
+}
+
+func TestSnippetServiceAccess(t *testing.T) {
+	carol := repositories.User{ID: 3, Username: "carol", Role: repositories.RoleUser}
+	dave := repositories.User{ID: 4, Username: "dave", Role: repositories.RoleUser}
+	erin := repositories.User{ID: 5, Username: "erin", Role: repositories.RoleUser}
+	frank := repositories.User{ID: 6, Username: "frank", Role: repositories.RoleUser}
+	repo := newFakeSnippets(alice, bob, carol, dave, erin, frank)
+	orgs := &fakeOrgs{roles: map[[2]uint]string{
+		{7, alice.ID}: repositories.OrgRoleMember,
+		{7, bob.ID}:   repositories.OrgRoleMember,
+		{7, carol.ID}: repositories.OrgRoleAdmin,
+	}}
+	collaborators := &fakeCollaborators{roles: map[string]string{}}
+	svc := services.NewSnippetService(repo, nil, services.Grants{Orgs: orgs, Collaborators: collaborators}, nil, nil)
+	ctx := context.Background()
+
+	in := input("runbook")
+	in.OrganizationID = 7
+	in.Visibility = repositories.VisibilityMembers
+	if _, err := svc.CreateSnippet(ctx, &dave, &in); !errors.Is(err, services.ErrNotOrgMember) {
+		t.Errorf("non-member creates org snippet: err = %v, want ErrNotOrgMember", err)
+	}
+	id, err := svc.CreateSnippet(ctx, &alice, &in)
+	if err != nil {
+		t.Fatalf("CreateSnippet: %v", err)
+	}
+	personal := input("mine")
+	personal.Visibility = repositories.VisibilityMembers
+	if _, err := svc.CreateSnippet(ctx, &alice, &personal); !errors.Is(err, services.ErrSnippetVisibility) {
+		t.Errorf("members-only personal snippet: err = %v, want ErrSnippetVisibility", err)
+	}
+
+	collaborators.roles[fmt.Sprintf("%s/%d", id, erin.ID)] = repositories.CollaboratorViewer
+	collaborators.roles[fmt.Sprintf("%s/%d", id, frank.ID)] = repositories.CollaboratorEditor
+	snippet, _ := repo.FindByID(ctx, id)
+	tests := []struct {
+		name   string
+		viewer uint
+		want   services.SnippetAccess
+	}{
+		{"author", alice.ID, services.SnippetAccess{View: true, Edit: true, Delete: true}},
+		{"member", bob.ID, services.SnippetAccess{View: true, Edit: true}},
+		{"org admin", carol.ID, services.SnippetAccess{View: true, Edit: true, Delete: true}},
+		{"non-member", dave.ID, services.SnippetAccess{}},
+		{"viewer", erin.ID, services.SnippetAccess{View: true}},
+		{"editor", frank.ID, services.SnippetAccess{View: true, Edit: true}},
+		{"guest", 0, services.SnippetAccess{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := svc.Access(ctx, tt.viewer, snippet)
+			if err != nil {
+				t.Fatalf("Access: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Access = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := svc.GetSnippetByID(ctx, dave.ID, id); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("non-member gets members-only snippet: err = %v, want not found", err)
+	}
+
+	// Authors who leave the organization lose access to its snippets
+	delete(orgs.roles, [2]uint{7, alice.ID})
+	if got, err := svc.Access(ctx, alice.ID, snippet); err != nil || got != (services.SnippetAccess{}) {
+		t.Errorf("former member's access = %+v, %v, want none", got, err)
+	}
+	orgs.roles[[2]uint{7, alice.ID}] = repositories.OrgRoleMember
+	if err := svc.UpdateSnippet(ctx, &frank, id, input("edited")); err != nil {
+		t.Errorf("editor updates: %v", err)
+	}
+	if err := svc.DeleteSnippet(ctx, &frank, id); !errors.Is(err, services.ErrNotSnippetOwner) {
+		t.Errorf("editor deletes: err = %v, want ErrNotSnippetOwner", err)
+	}
+	if err := svc.DeleteSnippet(ctx, &bob, id); !errors.Is(err, services.ErrNotSnippetOwner) {
+		t.Errorf("member deletes: err = %v, want ErrNotSnippetOwner", err)
+	}
+	if err := svc.DeleteSnippet(ctx, &carol, id); err != nil {
+		t.Errorf("org admin deletes: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+    "context"
+    "errors"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/trace"
+    "gorm.io/gorm"
+)
+
+var tracer = otel.Tracer("snipetty.com/main/services")
+
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+    return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan marks span as failed for unexpected errors and ends it. Not found
+// and permission errors are normal outcomes, not failures.
+func endSpan(span trace.Span, err error) {
+    if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, ErrNotSnippetOwner) {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
+}
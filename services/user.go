@@ -1,9 +1,227 @@
 package services
 
 import (
+    "context"
+    "errors"
+    "fmt"
+    "regexp"
+    "sort"
+
+    "golang.org/x/crypto/bcrypt"
+    "gorm.io/gorm"
     "snipetty.com/main/repositories"
 )
 
+var (
+    ErrUsernameTaken      = errors.New("Username already used")
+    ErrInvalidUsername    = errors.New("Usernames are up to 32 letters, digits, dots, dashes and underscores, and do not start with a dot")
+    ErrInvalidCredentials = errors.New("Invalid username or password")
+    ErrInvalidTransfer    = errors.New("Snippets must be transferred to another existing user")
+    ErrAccountDisabled    = errors.New("This account has been disabled")
+)
+
+// usernamePattern keeps usernames usable in URLs and snippet IDs.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,31}$`)
+
 type UserService struct {
-    repo *repositories.UserRepository
-}
\ No newline at end of file
+    repo     UserRepository
+    snippets SnippetRepository
+    orgs     OrganizationRepository
+    audit    *AuditService
+}
+
+// LanguageCount is the number of snippets a user wrote in one language.
+type LanguageCount struct {
+    Language string
+    Count    int
+}
+
+type ProfileStats struct {
+    SnippetCount int
+    Languages    []LanguageCount // Most used first
+}
+
+// Profile is everything shown on a user's public page.
+type Profile struct {
+    User     *repositories.User
+    Snippets []repositories.Snippet
+    Stats    ProfileStats
+}
+
+func NewUserService(repo UserRepository, snippets SnippetRepository, orgs OrganizationRepository, audit *AuditService) *UserService {
+    return &UserService{repo: repo, snippets: snippets, orgs: orgs, audit: audit}
+}
+
+func (s *UserService) Register(ctx context.Context, input repositories.AuthInput) (*repositories.User, error) {
+    if s.repo == nil {
+        return nil, errors.New("repository is nil")
+    }
+    if !usernamePattern.MatchString(input.Username) {
+        return nil, ErrInvalidUsername
+    }
+    _, err := s.repo.FindByUsername(ctx, input.Username)
+    if err == nil {
+        return nil, ErrUsernameTaken
+    }
+    if !errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, err
+    }
+
+    passwordHash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+    if err != nil {
+        return nil, errors.New("Failed to hash password")
+    }
+
+    user := &repositories.User{
+        Username: input.Username,
+        Password: string(passwordHash),
+        Role:     repositories.RoleUser,
+    }
+    if err := s.repo.Create(ctx, user); err != nil {
+        return nil, err
+    }
+    s.audit.Record(ctx, repositories.AuditRegister, user, user.Username, "")
+    return user, nil
+}
+
+// Authenticate checks a username/password pair. Unknown users and wrong
+// passwords both return ErrInvalidCredentials. Every attempt is audited.
+func (s *UserService) Authenticate(ctx context.Context, input repositories.AuthInput) (*repositories.User, error) {
+    if s.repo == nil {
+        return nil, errors.New("repository is nil")
+    }
+    user, err := s.repo.FindByUsername(ctx, input.Username)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        s.audit.Record(ctx, repositories.AuditLoginFailed, &repositories.User{Username: input.Username}, input.Username, "unknown user")
+        return nil, ErrInvalidCredentials
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+        s.audit.Record(ctx, repositories.AuditLoginFailed, user, user.Username, "wrong password")
+        return nil, ErrInvalidCredentials
+    }
+    if user.Disabled {
+        s.audit.Record(ctx, repositories.AuditLoginFailed, user, user.Username, "account disabled")
+        return nil, ErrAccountDisabled
+    }
+    s.audit.Record(ctx, repositories.AuditLogin, user, user.Username, "password")
+    return user, nil
+}
+
+func (s *UserService) GetUserByID(ctx context.Context, id uint) (*repositories.User, error) {
+    if s.repo == nil {
+        return nil, errors.New("repository is nil")
+    }
+    return s.repo.FindByID(ctx, id)
+}
+
+func (s *UserService) GetProfile(ctx context.Context, username string) (*Profile, error) {
+    if s.repo == nil || s.snippets == nil {
+        return nil, errors.New("repository is nil")
+    }
+    user, err := s.repo.FindByUsername(ctx, username)
+    if err != nil {
+        return nil, err
+    }
+    snippets, err := s.snippets.FindByUsername(ctx, username)
+    if err != nil {
+        return nil, err
+    }
+    return &Profile{
+        User:     user,
+        Snippets: snippets,
+        Stats:    profileStats(snippets),
+    }, nil
+}
+
+func (s *UserService) UpdateProfile(ctx context.Context, id uint, input repositories.ProfileInput) (*repositories.User, error) {
+    if s.repo == nil {
+        return nil, errors.New("repository is nil")
+    }
+    user, err := s.repo.FindByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    user.DisplayName = input.DisplayName
+    user.Bio = input.Bio
+    user.AvatarURL = input.AvatarURL
+    return user, s.repo.Update(ctx, user)
+}
+
+// DeleteAccount removes the account after confirming the password (or the
+// username, for accounts that only sign in through OIDC). Personal snippets
+// are either deleted or handed over to input.TransferTo; organization
+// snippets stay with the organization. The last owner of an organization has
+// to hand it over first.
+func (s *UserService) DeleteAccount(ctx context.Context, id uint, input repositories.DeleteAccountInput) error {
+    if s.repo == nil {
+        return errors.New("repository is nil")
+    }
+    user, err := s.repo.FindByID(ctx, id)
+    if err != nil {
+        return err
+    }
+
+    if user.Password == "" {
+        if input.Confirm != user.Username {
+            return ErrInvalidCredentials
+        }
+    } else if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+        return ErrInvalidCredentials
+    }
+
+    if s.orgs != nil {
+        orgs, err := s.orgs.FindByUserID(ctx, user.ID)
+        if err != nil {
+            return err
+        }
+        for i := range orgs {
+            if member := memberOf(&orgs[i], user.ID); member != nil && member.Role == repositories.OrgRoleOwner && owners(&orgs[i]) == 1 {
+                return fmt.Errorf("%w, and you are the only owner of %s", ErrLastOwner, orgs[i].Name)
+            }
+        }
+    }
+
+    var transferTo uint
+    detail := "snippets deleted"
+    if input.Mode == "transfer" {
+        target, err := s.repo.FindByUsername(ctx, input.TransferTo)
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return ErrInvalidTransfer
+        }
+        if err != nil {
+            return err
+        }
+        if target.ID == user.ID {
+            return ErrInvalidTransfer
+        }
+        transferTo = target.ID
+        detail = "snippets transferred to " + target.Username
+    }
+
+    if err := s.repo.DeleteAccount(ctx, user.ID, transferTo); err != nil {
+        return err
+    }
+    s.audit.Record(ctx, repositories.AuditDeleteAccount, user, user.Username, detail)
+    return nil
+}
+
+func profileStats(snippets []repositories.Snippet) ProfileStats {
+    counts := map[string]int{}
+    for _, snippet := range snippets {
+        counts[snippet.Language]++
+    }
+    languages := make([]LanguageCount, 0, len(counts))
+    for lang, count := range counts {
+        languages = append(languages, LanguageCount{Language: lang, Count: count})
+    }
+    sort.Slice(languages, func(i, j int) bool {
+        if languages[i].Count != languages[j].Count {
+            return languages[i].Count > languages[j].Count
+        }
+        return languages[i].Language < languages[j].Language
+    })
+    return ProfileStats{SnippetCount: len(snippets), Languages: languages}
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"snipetty.com/main/database"
+)
+
+const spanKey = "tracing:span"
+
+// gormPlugin records a client span per statement, as a child of the span in
+// the statement's context (see repositories' WithContext calls).
+type gormPlugin struct {
+	tracer trace.Tracer
+}
+
+// GormPlugin returns a plugin to install with db.Use.
+func GormPlugin() gorm.Plugin {
+	return gormPlugin{tracer: otel.Tracer(instrumentation)}
+}
+
+func (p gormPlugin) Name() string {
+	return "tracing"
+}
+
+func (p gormPlugin) Initialize(db *gorm.DB) error {
+	return database.RegisterHooks(db, "tracing", database.StatementHooks{
+		Before: p.start,
+		After:  func(string) func(*gorm.DB) { return end },
+	})
+}
+
+func (p gormPlugin) start(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		_, span := p.tracer.Start(db.Statement.Context, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemKey.String(db.Dialector.Name()),
+				semconv.DBOperationName(operation),
+				semconv.DBCollectionName(db.Statement.Table),
+			),
+		)
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func end(db *gorm.DB) {
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+
+	// The SQL keeps its placeholders; parameter values are not recorded
+	span.SetAttributes(
+		semconv.DBQueryText(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.RowsAffected),
+	)
+	if db.Statement.Table != "" {
+		span.SetAttributes(semconv.DBCollectionName(db.Statement.Table))
+	}
+	if err := db.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}